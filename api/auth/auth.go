@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+// The bearer token in the Authorization header, if any
+func bearerToken(c *gin.Context) string {
+	header := c.GetHeader("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// A new random session token, and the hash of it to store
+func NewToken() (string, string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(bytes)
+	return token, HashToken(token), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Register the defaults of the auth config, once at startup
+func SetDefaults() {
+	// How often a user's last_seen_at is updated, at most
+	viper.SetDefault("last_seen_interval", "5m")
+}
+
+// Middleware identifies who the request is from by its bearer token: the
+// configured admin_token, or the token of an unexpired session of an active
+// user, in which case the session's user is the caller. Requests without a
+// token are anonymous, and any other token is rejected.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("DB").(*pg.DB)
+
+		token := bearerToken(c)
+		if token == "" {
+			return
+		}
+
+		adminToken := viper.GetString("admin_token")
+		if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+			c.Set("Admin", true)
+			return
+		}
+
+		var session models.Session
+		err := db.Model(&session).
+			Where("token_hash = ?", HashToken(token)).
+			Where("expires_at > now()").
+			Select()
+		if err != nil {
+			if err != pg.ErrNoRows {
+				c.Error(err)
+			}
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
+			return
+		}
+
+		var caller models.UserAccount
+		caller.Id = session.UserId
+		if err := db.Model(&caller).WherePK().Select(); err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid token"})
+			return
+		}
+		// Sessions end when a user is suspended, but don't rely on it
+		if caller.Status != models.UserStatusActive {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "User Account is " + caller.Status})
+			return
+		}
+		c.Set("Session", &session)
+		c.Set("Caller", &caller)
+
+		// Not worth failing the request over
+		_, err = db.Exec(`
+			UPDATE user_accounts SET last_seen_at = now()
+			WHERE id = ? AND (last_seen_at IS NULL OR last_seen_at < now() - make_interval(secs => ?))`,
+			caller.Id, viper.GetDuration("last_seen_interval").Seconds())
+		if err != nil {
+			c.Error(err)
+		}
+	}
+}
+
+// Whether the request bears the admin_token
+func IsAdmin(c *gin.Context) bool {
+	return c.GetBool("Admin")
+}
+
+// The user the request is from, or nil if it's anonymous or from the admin
+func Caller(c *gin.Context) *models.UserAccount {
+	if caller, ok := c.Get("Caller"); ok {
+		return caller.(*models.UserAccount)
+	}
+	return nil
+}
+
+// The session the request is from, or nil if it isn't from a session
+func CurrentSession(c *gin.Context) *models.Session {
+	if session, ok := c.Get("Session"); ok {
+		return session.(*models.Session)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// KafkaPublisher publishes messages to Kafka topics. Messages are partitioned
+// by key the way Java producers do it, so messages with the same key are in
+// one partition, in order.
+type KafkaPublisher struct {
+	writer  *kafka.Writer
+	timeout time.Duration
+}
+
+func NewKafkaPublisher(brokers []string, timeout time.Duration) *KafkaPublisher {
+	return &KafkaPublisher{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Balancer:     &kafka.Murmur2Balancer{},
+			RequiredAcks: kafka.RequireAll,
+			// Messages are published one call at a time, so don't wait for
+			// more to batch with them
+			BatchTimeout:           10 * time.Millisecond,
+			WriteTimeout:           timeout,
+			AllowAutoTopicCreation: true,
+		},
+		timeout: timeout,
+	}
+}
+
+// Publish the messages, waiting for every in-sync replica to have them
+func (p *KafkaPublisher) Publish(messages ...Message) error {
+	kafkaMessages := make([]kafka.Message, len(messages))
+	for i, message := range messages {
+		kafkaMessages[i] = kafka.Message{
+			Topic:   message.Topic,
+			Key:     []byte(message.Key),
+			Value:   message.Value,
+			Headers: []kafka.Header{{Key: "content-type", Value: []byte(CloudEventContentType)}},
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	return p.writer.WriteMessages(ctx, kafkaMessages...)
+}
+
+func (p *KafkaPublisher) Close() error {
+	return p.writer.Close()
+}
@@ -0,0 +1,55 @@
+package broker
+
+import (
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NATSPublisher publishes messages to NATS subjects. Messages published on
+// one connection reach each subscriber in order.
+type NATSPublisher struct {
+	conn    *nats.Conn
+	err     error
+	timeout time.Duration
+}
+
+func NewNATSPublisher(url string, timeout time.Duration) *NATSPublisher {
+	conn, err := nats.Connect(url,
+		nats.Name("golang-user-crud"),
+		nats.Timeout(timeout),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		// Messages are published again rather than buffered while disconnected
+		nats.ReconnectBufSize(-1))
+	return &NATSPublisher{conn: conn, err: err, timeout: timeout}
+}
+
+// Publish the messages, then wait for the server to have them. The id is sent
+// as Nats-Msg-Id, so JetStream streams drop repeats.
+func (p *NATSPublisher) Publish(messages ...Message) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.conn.IsConnected() {
+		return errors.New("nats: not connected")
+	}
+	for _, message := range messages {
+		msg := nats.NewMsg(message.Topic)
+		msg.Header.Set("Content-Type", CloudEventContentType)
+		msg.Header.Set(nats.MsgIdHdr, message.Id)
+		msg.Data = message.Value
+		if err := p.conn.PublishMsg(msg); err != nil {
+			return err
+		}
+	}
+	return p.conn.FlushTimeout(p.timeout)
+}
+
+func (p *NATSPublisher) Close() error {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	return nil
+}
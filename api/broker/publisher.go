@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The content type of a message whose value is a CloudEvent, in structured
+// mode
+const CloudEventContentType = "application/cloudevents+json"
+
+// An event in the CloudEvents 1.0 JSON format
+type CloudEvent struct {
+	SpecVersion string `json:"specversion"`
+	// Unique for the source, so consumers can drop repeats
+	Id     string `json:"id"`
+	Source string `json:"source"`
+	Type   string `json:"type"`
+	// What the event is about, within the source
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	// The partitioning extension: the key the event was published with
+	PartitionKey string      `json:"partitionkey,omitempty"`
+	Data         interface{} `json:"data"`
+}
+
+// A message to publish
+type Message struct {
+	// The Kafka topic or NATS subject
+	Topic string
+	// Messages with the same key are delivered in the order they're published
+	Key string
+	// Unique per event, so brokers that can drop repeats do
+	Id    string
+	Value []byte
+}
+
+// Publisher sends messages to a broker
+type Publisher interface {
+	// Publish the messages in order, returning once the broker has them all.
+	// On an error, some may have been published, so they should all be
+	// published again.
+	Publish(messages ...Message) error
+	Close() error
+}
+
+// NoopPublisher drops messages, for when there's no broker
+type NoopPublisher struct{}
+
+func (NoopPublisher) Publish(messages ...Message) error {
+	return nil
+}
+
+func (NoopPublisher) Close() error {
+	return nil
+}
+
+// Register the defaults of the broker config, once at startup
+func SetDefaults() {
+	// none, nats or kafka
+	viper.SetDefault("broker", "none")
+	// The NATS servers, comma separated
+	viper.SetDefault("nats_url", "nats://localhost:4222")
+	// The Kafka brokers to bootstrap from, comma separated
+	viper.SetDefault("kafka_brokers", "localhost:9092")
+	// How long the broker has to take messages before they're published again
+	viper.SetDefault("broker_timeout", "10s")
+}
+
+// The publisher chosen by the broker config. It connects in the background,
+// so publishing fails until the broker can be reached.
+func NewPublisher() Publisher {
+	timeout := viper.GetDuration("broker_timeout")
+	switch viper.GetString("broker") {
+	case "nats":
+		return NewNATSPublisher(viper.GetString("nats_url"), timeout)
+	case "kafka":
+		return NewKafkaPublisher(strings.Split(viper.GetString("kafka_brokers"), ","), timeout)
+	default:
+		return NoopPublisher{}
+	}
+}
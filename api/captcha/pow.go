@@ -0,0 +1,114 @@
+package captcha
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProofOfWork makes clients spend CPU instead of solving a captcha. The
+// client gets a signed challenge and finds a nonce such that the SHA-256 of
+// "<challenge>:<nonce>" starts with Difficulty zero bits, then responds with
+// "<challenge>:<nonce>". Each challenge can only be used once.
+type ProofOfWork struct {
+	Difficulty int
+	TTL        time.Duration
+	key        []byte
+	mu         sync.Mutex
+	// The challenges that have been used, until they expire
+	used map[string]time.Time
+}
+
+// A new proof of work verifier, signing challenges with the key, or a random
+// one if it's empty
+func NewProofOfWork(difficulty int, ttl time.Duration, key []byte) *ProofOfWork {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(err)
+		}
+	}
+	return &ProofOfWork{Difficulty: difficulty, TTL: ttl, key: key, used: map[string]time.Time{}}
+}
+
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// A new challenge, as its expiry and a random salt, signed
+func (p *ProofOfWork) Challenge() (*Challenge, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	expiresAt := time.Now().Add(p.TTL)
+	payload := fmt.Sprintf("%d.%s", expiresAt.Unix(), base64.RawURLEncoding.EncodeToString(salt))
+	return &Challenge{
+		Challenge:  payload + "." + p.sign(payload),
+		Difficulty: p.Difficulty,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// The number of zero bits the hash starts with
+func leadingZeroBits(hash []byte) int {
+	zeros := 0
+	for _, b := range hash {
+		zeros += bits.LeadingZeros8(b)
+		if b != 0 {
+			break
+		}
+	}
+	return zeros
+}
+
+func (p *ProofOfWork) Verify(response string, remoteIP string) error {
+	if response == "" {
+		return ErrMissing
+	}
+
+	separator := strings.LastIndex(response, ":")
+	if separator < 0 {
+		return ErrFailed
+	}
+	challenge := response[:separator]
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 3 || !hmac.Equal([]byte(parts[2]), []byte(p.sign(parts[0]+"."+parts[1]))) {
+		return ErrFailed
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrFailed
+	}
+	expiresAt := time.Unix(expires, 0)
+	now := time.Now()
+	if now.After(expiresAt) {
+		return ErrFailed
+	}
+	hash := sha256.Sum256([]byte(response))
+	if leadingZeroBits(hash[:]) < p.Difficulty {
+		return ErrFailed
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for used, usedExpiresAt := range p.used {
+		if now.After(usedExpiresAt) {
+			delete(p.used, used)
+		}
+	}
+	if _, ok := p.used[challenge]; ok {
+		return ErrFailed
+	}
+	p.used[challenge] = expiresAt
+	return nil
+}
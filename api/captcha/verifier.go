@@ -0,0 +1,121 @@
+package captcha
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+var (
+	ErrMissing = errors.New("captcha is required")
+	ErrFailed  = errors.New("captcha is incorrect")
+)
+
+// Verifier checks the response a client gives to a captcha, or the proof of
+// work it did. Errors other than ErrMissing and ErrFailed mean the response
+// couldn't be checked.
+type Verifier interface {
+	Verify(response string, remoteIP string) error
+}
+
+// A challenge the client must solve before responding
+type Challenge struct {
+	Challenge  string    `json:"challenge"`
+	Difficulty int       `json:"difficulty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// Challenger is a Verifier that issues the challenges it checks
+type Challenger interface {
+	Verifier
+	Challenge() (*Challenge, error)
+}
+
+// NoVerifier accepts every response, for when there's no captcha
+type NoVerifier struct{}
+
+func (NoVerifier) Verify(response string, remoteIP string) error {
+	return nil
+}
+
+// SiteVerifier checks responses with a siteverify API, which reCAPTCHA,
+// hCaptcha and Turnstile all have
+type SiteVerifier struct {
+	URL    string
+	Secret string
+	Client *http.Client
+}
+
+func (v *SiteVerifier) Verify(response string, remoteIP string) error {
+	if response == "" {
+		return ErrMissing
+	}
+
+	res, err := v.Client.PostForm(v.URL, url.Values{
+		"secret":   {v.Secret},
+		"response": {response},
+		"remoteip": {remoteIP},
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return errors.New("captcha verification failed with " + res.Status)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return ErrFailed
+	}
+	return nil
+}
+
+// Register the defaults of the captcha config, once at startup
+func SetDefaults() {
+	viper.SetDefault("captcha", "")
+	// For siteverify, e.g. https://hcaptcha.com/siteverify
+	viper.SetDefault("captcha_verify_url", "")
+	viper.SetDefault("captcha_secret", "")
+	// For pow, the leading zero bits the hash of a solution needs
+	viper.SetDefault("pow_difficulty", 20)
+	// For pow, how long a challenge can be solved for
+	viper.SetDefault("pow_ttl", "10m")
+	// For pow, the key challenges are signed with, which instances behind a
+	// load balancer must share. Without one a random key is used.
+	viper.SetDefault("pow_signing_key", "")
+}
+
+// The verifier chosen by the captcha config
+func NewVerifier() Verifier {
+	switch viper.GetString("captcha") {
+	case "siteverify":
+		return &SiteVerifier{
+			URL:    viper.GetString("captcha_verify_url"),
+			Secret: viper.GetString("captcha_secret"),
+			Client: &http.Client{Timeout: 10 * time.Second},
+		}
+	case "pow":
+		return NewProofOfWork(viper.GetInt("pow_difficulty"), viper.GetDuration("pow_ttl"), []byte(viper.GetString("pow_signing_key")))
+	default:
+		return NoVerifier{}
+	}
+}
+
+func Middleware() gin.HandlerFunc {
+	verifier := NewVerifier()
+
+	return func(c *gin.Context) {
+		c.Set("Captcha", verifier)
+	}
+}
@@ -7,8 +7,10 @@ import (
 )
 
 var PK_ERROR_CODE = "ERROR #23505"
+var FK_ERROR_CODE = "ERROR #23503"
 
-func Middleware() gin.HandlerFunc {
+// Register the defaults of the database config, once at startup
+func SetDefaults() {
 	// We need tcp to go across containers
 	viper.SetDefault("db_network", "tcp")
 	// docker compose DB host
@@ -17,7 +19,9 @@ func Middleware() gin.HandlerFunc {
 	viper.SetDefault("db_user", "postgres")
 	viper.SetDefault("db_password", "postgres")
 	viper.SetDefault("db_database", "postgres")
+}
 
+func Connect() *pg.DB {
 	options := pg.Options{
 		Network:  viper.GetString("db_network"),
 		Addr:     viper.GetString("db_addr"),
@@ -26,7 +30,11 @@ func Middleware() gin.HandlerFunc {
 		Database: viper.GetString("db_database"),
 	}
 
-	db := pg.Connect(&options)
+	return pg.Connect(&options)
+}
+
+func Middleware() gin.HandlerFunc {
+	db := Connect()
 
 	return func(c *gin.Context) {
 		c.Set("DB", db)
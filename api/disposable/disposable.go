@@ -0,0 +1,76 @@
+package disposable
+
+import (
+	"bufio"
+	_ "embed"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+//go:embed domains.txt
+var builtIn string
+
+var (
+	domains     map[string]bool
+	loadDomains sync.Once
+)
+
+// Add the domains in the list, one per line, skipping blanks and # comments
+func addDomains(list io.Reader) error {
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		domain := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if domain != "" && !strings.HasPrefix(domain, "#") {
+			domains[domain] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// Register the defaults of the disposable domains config, once at startup
+func SetDefaults() {
+	viper.SetDefault("disposable_domains_file", "")
+}
+
+// The built in list, and the one in disposable_domains_file if it's set
+func load() {
+	domains = map[string]bool{}
+	addDomains(strings.NewReader(builtIn))
+
+	path := viper.GetString("disposable_domains_file")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		log.Printf("Error reading disposable_domains_file: %s", err)
+		return
+	}
+	defer file.Close()
+	if err := addDomains(file); err != nil {
+		log.Printf("Error reading disposable_domains_file: %s", err)
+	}
+}
+
+// Whether the email address is at a disposable domain, or a subdomain of one
+func IsDisposable(email string) bool {
+	loadDomains.Do(load)
+
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+	for domain != "" {
+		if domains[domain] {
+			return true
+		}
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+	return false
+}
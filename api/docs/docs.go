@@ -24,15 +24,226 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/users": {
+        "/attributes": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the schemas of all custom user attributes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer token, with attributes:private to include private attributes",
+                        "name": "Authorization",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The attribute schemas",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.AttributeSchema"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/attributes/:name": {
             "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the schema of a custom user attribute",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The name of the attribute",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token, with attributes:private to see a private attribute",
+                        "name": "Authorization",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The attribute schema",
+                        "schema": {
+                            "$ref": "#/definitions/models.AttributeSchema"
+                        }
+                    }
+                }
+            },
+            "put": {
                 "consumes": [
                     "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Retrieve all users",
+                "summary": "Register or replace the schema of a custom user attribute",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The name of the attribute",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The JSON Schema values of the attribute must match, and whether it is private",
+                        "name": "schema",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.AttributeSchema"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token with attributes:manage",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The attribute schema",
+                        "schema": {
+                            "$ref": "#/definitions/models.AttributeSchema"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing the attributes:manage permission",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete the schema of a custom user attribute no user has",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The name of the attribute",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token with attributes:manage",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "Missing the attributes:manage permission",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "Some users have the attribute",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/events": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the user events after a sequence, waiting for some if there are none yet",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The sequence of the last event handled. default: 0, the oldest kept",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 100, at most 1000",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Seconds to wait for an event, at most 60. default: 0",
+                        "name": "wait",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The events, oldest first, and the sequence to retrieve events after next",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEventPage"
+                        }
+                    }
+                }
+            }
+        },
+        "/events/stream": {
+            "get": {
+                "produces": [
+                    "text/event-stream"
+                ],
+                "summary": "Stream the user events after a sequence, and then as they happen, as server-sent events",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The sequence of the last event handled. default: 0, the oldest kept",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "The id of the last event received, to resume a dropped stream. Overrides after.",
+                        "name": "Last-Event-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Each event's id is its sequence, its event its type, and its data the event as JSON",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/groups": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve all groups",
                 "parameters": [
                     {
                         "type": "integer",
@@ -49,11 +260,11 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "The user entities",
+                        "description": "The groups",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/models.UserOutgoing"
+                                "$ref": "#/definitions/models.Group"
                             }
                         }
                     }
@@ -66,15 +277,15 @@ var doc = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Create a user",
+                "summary": "Create a group",
                 "parameters": [
                     {
-                        "description": "The user data to be created",
-                        "name": "user",
+                        "description": "The group to be created",
+                        "name": "group",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserIncoming"
+                            "$ref": "#/definitions/models.GroupBase"
                         }
                     }
                 ],
@@ -82,22 +293,22 @@ var doc = `{
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "type": "body"
+                            "$ref": "#/definitions/models.Group"
                         }
                     }
                 }
             }
         },
-        "/users/:id": {
+        "/groups/:id": {
             "get": {
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Retrieve a user by id",
+                "summary": "Retrieve a group by id",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "The id of the user to be retrieved",
+                        "description": "The id of the group to be retrieved",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -105,9 +316,9 @@ var doc = `{
                 ],
                 "responses": {
                     "200": {
-                        "description": "The user entity for that id",
+                        "description": "The group for that id",
                         "schema": {
-                            "$ref": "#/definitions/models.UserOutgoing"
+                            "$ref": "#/definitions/models.Group"
                         }
                     }
                 }
@@ -119,30 +330,36 @@ var doc = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Update a user by id",
+                "summary": "Update a group by id",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "The id of the user to be updated",
+                        "description": "The id of the group to be updated",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "The user data to be updated",
-                        "name": "user",
+                        "description": "The group data to be updated",
+                        "name": "group",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.UserIncoming"
+                            "$ref": "#/definitions/models.GroupBase"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "The updated user entity for that id",
+                        "description": "The updated group",
                         "schema": {
-                            "$ref": "#/definitions/models.UserOutgoing"
+                            "$ref": "#/definitions/models.Group"
+                        }
+                    },
+                    "400": {
+                        "description": "The group would be nested in itself",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
@@ -151,11 +368,11 @@ var doc = `{
                 "produces": [
                     "application/json"
                 ],
-                "summary": "Delete a user by id",
+                "summary": "Delete a group by id. Its nested groups move up to its parent.",
                 "parameters": [
                     {
                         "type": "integer",
-                        "description": "The id of the user to be deleted",
+                        "description": "The id of the group to be deleted",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -170,67 +387,3899 @@ var doc = `{
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "models.UserIncoming": {
-            "type": "object",
-            "required": [
-                "password",
-                "user_name"
-            ],
-            "properties": {
-                "email": {
-                    "type": "string"
-                },
-                "first_name": {
-                    "type": "string"
-                },
-                "last_name": {
-                    "type": "string"
-                },
-                "middle_name": {
-                    "type": "string"
-                },
-                "password": {
-                    "type": "string"
-                },
-                "primary_phone_number": {
-                    "type": "string"
-                },
-                "user_name": {
-                    "type": "string"
+        },
+        "/groups/:id/members": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the users in a group",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the group",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "include the users in groups nested in it",
+                        "name": "nested",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The users in the group",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserOutgoing"
+                            }
+                        }
+                    }
                 }
             }
         },
-        "models.UserOutgoing": {
-            "type": "object",
-            "required": [
-                "user_name"
-            ],
-            "properties": {
-                "email": {
-                    "type": "string"
-                },
-                "first_name": {
-                    "type": "string"
-                },
-                "id": {
-                    "type": "integer"
-                },
-                "last_name": {
-                    "type": "string"
-                },
-                "middle_name": {
-                    "type": "string"
+        "/groups/:id/members/:user_id": {
+            "put": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add a user to a group",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the group",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Remove a user from a group",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the group",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/invitations": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the outstanding invitations",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The invitations, including expired ones",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Invitation"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Invite someone by email, creating a pending user they complete by accepting",
+                "parameters": [
+                    {
+                        "description": "Who to invite",
+                        "name": "invitation",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.InvitationIncoming"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "The invitation, which was mailed with its token",
+                        "schema": {
+                            "$ref": "#/definitions/models.Invitation"
+                        }
+                    },
+                    "409": {
+                        "description": "The email already has a user, or an outstanding invitation",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/invitations/:id": {
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Revoke an invitation, deleting its user if they're still pending",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the invitation",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/invitations/:id/resend": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Send an invitation again, with a new token and expiry",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the invitation",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The invitation, which was mailed with its new token",
+                        "schema": {
+                            "$ref": "#/definitions/models.Invitation"
+                        }
+                    },
+                    "429": {
+                        "description": "The invitation was sent too recently",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/invitations/:token/accept": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Accept an invitation, choosing a user_name and password, which makes the user active",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The token from the invitation",
+                        "name": "token",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The user_name and password chosen",
+                        "name": "acceptance",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.InvitationAcceptance"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The now active user, who can sign in",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "404": {
+                        "description": "The token is invalid, or the invitation was revoked or sent again",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "The invitation has expired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/organizations": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve all organizations",
+                "responses": {
+                    "200": {
+                        "description": "The organizations",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Organization"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create an organization",
+                "parameters": [
+                    {
+                        "description": "The organization to be created",
+                        "name": "organization",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OrganizationBase"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Organization"
+                        }
+                    }
+                }
+            }
+        },
+        "/organizations/:id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve an organization by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the organization to be retrieved",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The organization for that id",
+                        "schema": {
+                            "$ref": "#/definitions/models.Organization"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update an organization by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the organization to be updated",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The organization data to be updated",
+                        "name": "organization",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.OrganizationBase"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The updated organization",
+                        "schema": {
+                            "$ref": "#/definitions/models.Organization"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete an organization by id, with its groups, roles and attributes",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the organization to be deleted",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "The organization has users, or is the default_tenant",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/organizations/current": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the organization the request is for",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The slug of the organization, if not signed in",
+                        "name": "X-Tenant",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The organization",
+                        "schema": {
+                            "$ref": "#/definitions/models.Organization"
+                        }
+                    }
+                }
+            }
+        },
+        "/register": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Sign up, creating a pending user who becomes active once their email is verified",
+                "parameters": [
+                    {
+                        "description": "The user signing up, with the captcha response if one is needed",
+                        "name": "registration",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Registration"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "The pending user. A token to verify their email was mailed to them.",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "403": {
+                        "description": "Registration is closed, or not open to the email's domain",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many registrations from the client's IP",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/register/challenge": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get a challenge to solve for the captcha, when it's proof of work",
+                "responses": {
+                    "200": {
+                        "description": "The challenge, to respond to with \u003cchallenge\u003e:\u003cnonce\u003e",
+                        "schema": {
+                            "$ref": "#/definitions/captcha.Challenge"
+                        }
+                    },
+                    "404": {
+                        "description": "The captcha doesn't use challenges",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/register/resend": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Mail a new token to verify a registration, if the email has one outstanding",
+                "parameters": [
+                    {
+                        "description": "The email the user registered with",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.EmailVerificationResend"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "Whether or not the email has a registration, so as not to reveal which do",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/register/verify": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Verify the email of a registered user with the token mailed to it, making them active",
+                "parameters": [
+                    {
+                        "description": "The token that was mailed",
+                        "name": "token",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.EmailVerificationConfirm"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The now active user, who can sign in",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "404": {
+                        "description": "The token is incorrect, or was replaced by a newer one",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "The token has expired",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve all roles",
+                "responses": {
+                    "200": {
+                        "description": "The roles",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Role"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create a role",
+                "parameters": [
+                    {
+                        "description": "The role to be created",
+                        "name": "role",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.RoleBase"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.Role"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles/:id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a role by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role to be retrieved",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The role for that id",
+                        "schema": {
+                            "$ref": "#/definitions/models.Role"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a role by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role to be updated",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The role data to be updated",
+                        "name": "role",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.RoleBase"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The updated role",
+                        "schema": {
+                            "$ref": "#/definitions/models.Role"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a role by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role to be deleted",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles/:id/groups/:group_id": {
+            "put": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Give a role to every user in a group, and in groups nested in it",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the group",
+                        "name": "group_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Take a role from a group",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the group",
+                        "name": "group_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/roles/:id/users/:user_id": {
+            "put": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Give a role to a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Take a role from a user",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the role",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Sign in, creating a session",
+                "parameters": [
+                    {
+                        "description": "The user's user_name and password",
+                        "name": "credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.SessionIncoming"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "The session, with the bearer token for the Authorization header",
+                        "schema": {
+                            "$ref": "#/definitions/models.Session"
+                        }
+                    },
+                    "401": {
+                        "description": "The user_name or password is wrong",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "403": {
+                        "description": "The user isn't active",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/sessions/current": {
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Sign out, ending the session the request is from",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Bearer token of the session",
+                        "name": "Authorization",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users": {
+            "get": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve all users",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this user_name",
+                        "name": "user_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this first_name",
+                        "name": "first_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this last_name",
+                        "name": "last_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this email",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this status: pending, active, suspended or disabled",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this value of the attribute, e.g. attr[department]=sales",
+                        "name": "attr[name]",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only users in this group, directly or through a nested group",
+                        "name": "group",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only these fields of each user, comma separated, e.g. id,user_name",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "include related resources with each user: groups",
+                        "name": "expand",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user entities",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserOutgoing"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create a user",
+                "parameters": [
+                    {
+                        "description": "The user data to be created",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserIncoming"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Makes retries replay the original response",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "body"
+                        }
+                    },
+                    "422": {
+                        "description": "The Idempotency-Key was used with a different request",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user to be retrieved",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "only these fields of the user, comma separated, e.g. id,user_name",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "include related resources with the user: groups",
+                        "name": "expand",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached representation",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user entity for that id",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "304": {
+                        "description": "The cached representation is current",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a user by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user to be updated",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The user data to be updated",
+                        "name": "user",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserIncoming"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being updated",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The updated user entity for that id",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "412": {
+                        "description": "The user was modified since the ETag was retrieved",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a user by id",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user to be deleted",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being deleted",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "412": {
+                        "description": "The user was modified since the ETag was retrieved",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/addresses": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's postal addresses",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's postal addresses",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserAddress"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add a postal address to a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The postal address, primary if it's the first",
+                        "name": "address",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserAddress"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserAddress"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/addresses/:contact_id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's postal address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the postal address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserAddress"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a user's postal address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the postal address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The postal address, made primary if is_primary",
+                        "name": "address",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserAddress"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserAddress"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a user's postal address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the postal address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/data-export": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Export everything stored about a user, to answer a data subject access request",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's data, as an attachment",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserDataExport"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/disable": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Disable a user for good",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Why the user is disabled",
+                        "name": "change",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserStatusChange"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The disabled user",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "409": {
+                        "description": "The user is already disabled",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/emails": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's email addresses",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's email addresses",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserEmail"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add an email address to a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The email address, primary if it's the first",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEmail"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEmail"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/emails/:contact_id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's email address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the email address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEmail"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a user's email address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the email address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The email address, made primary if is_primary",
+                        "name": "email",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEmail"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserEmail"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a user's email address",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the email address",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/erase": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Erase a user's personal data, keeping an anonymous, disabled user and a record of the erasure",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Why the user is erased, e.g. the request it answers",
+                        "name": "erasure",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserErasureIncoming"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being erased",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "What's left of the user",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "412": {
+                        "description": "The user was modified since the ETag was retrieved",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/groups": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the groups a user is in",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "include the groups those groups are nested in",
+                        "name": "nested",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's groups",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Group"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/names": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the user_names a user had before their current one",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The old user_names, most recent first",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserNameChange"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/permissions": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the permissions a user has when signed in",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's effective permissions",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/phones": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's phone numbers",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user's phone numbers",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.UserPhone"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Add a phone number to a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The phone number, primary if it's the first",
+                        "name": "phone",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserPhone"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserPhone"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/phones/:contact_id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user's phone number",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the phone number",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserPhone"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a user's phone number",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the phone number",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The phone number, made primary if is_primary",
+                        "name": "phone",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserPhone"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserPhone"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a user's phone number",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the phone number",
+                        "name": "contact_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/phones/confirm": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Confirm a user's primary phone number with the code sent to it",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user whose phone number is being verified",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The code that was sent",
+                        "name": "code",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.PhoneVerificationConfirm"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user entity, with the phone number verified",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "400": {
+                        "description": "The code is incorrect",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "No code is outstanding",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "410": {
+                        "description": "The code has expired, or the phone number has changed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many incorrect codes, the code has been discarded",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/phones/verify": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Send a code to verify a user's primary phone number",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user whose phone number is to be verified",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "The code was sent",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "The phone number is already verified",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "A code was sent too recently",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/reactivate": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Reactivate a suspended user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Why the user is reactivated",
+                        "name": "change",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserStatusChange"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The reactivated user",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "409": {
+                        "description": "The user isn't suspended",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/rename": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Change a user's user_name, keeping the old one reserved for them for the user_name_cooldown",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The new user_name",
+                        "name": "rename",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserRename"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The renamed user",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "400": {
+                        "description": "The user_name is taken, reserved, or another user's recent name",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/:id/suspend": {
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Suspend an active user, who can't sign in until reactivated",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The public_id (or, for now, id) of the user",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Why the user is suspended",
+                        "name": "change",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserStatusChange"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being changed",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The suspended user",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "409": {
+                        "description": "The user isn't active",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/by-email/:email": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user by primary email, which is matched case insensitively",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The email of the user to be retrieved",
+                        "name": "email",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "only these fields of the user, comma separated",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached representation",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user with that email",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "401": {
+                        "description": "Lookups aren't open to anonymous callers",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/by-phone/:phone": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user by primary phone number, in any format that parses to it",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The phone number of the user to be retrieved",
+                        "name": "phone",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "The region to parse the phone number in, if it has no country code",
+                        "name": "region",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only these fields of the user, comma separated",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached representation",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user with that phone number",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "401": {
+                        "description": "Lookups aren't open to anonymous callers",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "409": {
+                        "description": "More than one user has that phone number",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/by-username/:user_name": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a user by user_name, in any case. An old user_name redirects to the user who had it.",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "The user_name of the user to be retrieved",
+                        "name": "user_name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "only these fields of the user, comma separated",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached representation",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The user with that user_name",
+                        "schema": {
+                            "$ref": "#/definitions/models.UserOutgoing"
+                        }
+                    },
+                    "301": {
+                        "description": "The user_name is an old one, and Location is its user",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "401": {
+                        "description": "Lookups aren't open to anonymous callers",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/export": {
+            "get": {
+                "produces": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "summary": "Export users as CSV or NDJSON",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv (default) or ndjson",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "comma separated fields to export (default: all)",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this user_name",
+                        "name": "user_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this first_name",
+                        "name": "first_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this last_name",
+                        "name": "last_name",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this email",
+                        "name": "email",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this status: pending, active, suspended or disabled",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only users with this value of the attribute, e.g. attr[department]=sales",
+                        "name": "attr[name]",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "only users in this group, directly or through a nested group",
+                        "name": "group",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The users, one per line",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/import": {
+            "post": {
+                "consumes": [
+                    "text/csv",
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Import users from CSV or NDJSON",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "csv or ndjson (default: from the content type)",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "validate and report without creating users",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "import as a background job (default: for large files)",
+                        "name": "async",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "map a column to a user field, e.g. map[Login]=user_name",
+                        "name": "map[column]",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The import report",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportJob"
+                        }
+                    },
+                    "202": {
+                        "description": "The background import job",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportJob"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/import/:id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the progress of a background import",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the import job",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The import job",
+                        "schema": {
+                            "$ref": "#/definitions/models.ImportJob"
+                        }
+                    }
+                }
+            }
+        },
+        "/users/retention-report": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Report the users the next run of the retention rules will warn, suspend or erase",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The users, erasures first, then suspensions, then warnings",
+                        "schema": {
+                            "$ref": "#/definitions/models.RetentionReport"
+                        }
+                    }
+                }
+            }
+        },
+        "/users:batch": {
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update users in a batch",
+                "parameters": [
+                    {
+                        "description": "The users to be updated, by public_id (or, for now, id), and whether to update them atomically (default) or best effort",
+                        "name": "users",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserBatchUpdate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The status of each item",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The atomic batch failed, nothing was updated",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create users in a batch",
+                "parameters": [
+                    {
+                        "description": "The users to be created, and whether to create them atomically (default) or best effort",
+                        "name": "users",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserBatchCreate"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The status of each item in the best effort batch",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    },
+                    "201": {
+                        "description": "The status of each item in the atomic batch",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The atomic batch failed, nothing was created",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete users in a batch",
+                "parameters": [
+                    {
+                        "description": "The public_ids (or, for now, ids) of the users to be deleted, optionally their versions, and whether to delete them atomically (default) or best effort",
+                        "name": "users",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.UserBatchDelete"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The status of each item",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    },
+                    "400": {
+                        "description": "The atomic batch failed, nothing was deleted",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve all webhooks",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The webhooks",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.Webhook"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Create a webhook, which user events are posted to",
+                "parameters": [
+                    {
+                        "description": "The webhook to be created",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookIncoming"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "The webhook, with the secret its payloads are signed with",
+                        "schema": {
+                            "$ref": "#/definitions/models.Webhook"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/:id": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve a webhook by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the webhook to be retrieved",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The webhook for that id",
+                        "schema": {
+                            "$ref": "#/definitions/models.Webhook"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update a webhook by id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the webhook to be updated",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "The webhook data to be updated. The secret is kept unless a new one is given.",
+                        "name": "webhook",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookIncoming"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The updated webhook",
+                        "schema": {
+                            "$ref": "#/definitions/models.Webhook"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Delete a webhook by id, and its deliveries",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the webhook to be deleted",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/:id/deliveries": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Retrieve the deliveries of events to a webhook, newest first",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the webhook",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 1",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "default: 20",
+                        "name": "page_size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "only deliveries with this status: pending, delivered or dead",
+                        "name": "status",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The deliveries",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.WebhookDelivery"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webhooks/:id/deliveries/:delivery_id/redeliver": {
+            "post": {
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Queue a delivery to be sent again now, with all its attempts, e.g. once it's dead",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "The id of the webhook",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "The id of the delivery",
+                        "name": "delivery_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "The delivery, pending",
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookDelivery"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "captcha.Challenge": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "difficulty": {
+                    "type": "integer"
+                },
+                "expires_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.AttributeSchema": {
+            "type": "object",
+            "required": [
+                "schema"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "private": {
+                    "type": "boolean"
+                },
+                "schema": {
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.BatchItemResult": {
+            "type": "object",
+            "properties": {
+                "index": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "integer"
+                },
+                "user": {
+                    "$ref": "#/definitions/models.UserOutgoing"
+                }
+            }
+        },
+        "models.BatchResult": {
+            "type": "object",
+            "properties": {
+                "mode": {
+                    "type": "string"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchItemResult"
+                    }
+                }
+            }
+        },
+        "models.EmailVerificationConfirm": {
+            "type": "object",
+            "required": [
+                "token"
+            ],
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.EmailVerificationResend": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Group": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "parent_id": {
+                    "description": "The group this group is nested in, if any",
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.GroupBase": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "parent_id": {
+                    "description": "The group this group is nested in, if any",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ImportError": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string"
+                },
+                "row": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.ImportJob": {
+            "type": "object",
+            "properties": {
+                "created": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "errors": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.ImportError"
+                    }
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "finished_at": {
+                    "type": "string"
+                },
+                "format": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                },
+                "processed": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Invitation": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "invited_by": {
+                    "type": "integer"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.InvitationAcceptance": {
+            "type": "object",
+            "required": [
+                "password",
+                "user_name"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.InvitationIncoming": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "middle_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Organization": {
+            "type": "object",
+            "required": [
+                "name",
+                "slug"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "slug": {
+                    "description": "Names the organization in the X-Tenant header, and as a subdomain",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.OrganizationBase": {
+            "type": "object",
+            "required": [
+                "name",
+                "slug"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "slug": {
+                    "description": "Names the organization in the X-Tenant header, and as a subdomain",
+                    "type": "string"
+                }
+            }
+        },
+        "models.Pagination": {
+            "type": "object",
+            "properties": {
+                "page": {
+                    "type": "integer"
+                },
+                "pageSize": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.PhoneVerificationConfirm": {
+            "type": "object",
+            "required": [
+                "code"
+            ],
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Registration": {
+            "type": "object",
+            "required": [
+                "password",
+                "user_name"
+            ],
+            "properties": {
+                "attributes": {
+                    "description": "Custom attributes, each validated by its registered schema",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "captcha": {
+                    "description": "The captcha response, or the proof of work, if the captcha config is set",
+                    "type": "string"
+                },
+                "country": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "middle_name": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "phone_region": {
+                    "description": "The region to parse primary_phone_number in, if it has no country code",
+                    "type": "string"
+                },
+                "primary_phone_number": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.RetentionCandidate": {
+            "type": "object",
+            "properties": {
+                "action": {
+                    "type": "string"
+                },
+                "user": {
+                    "$ref": "#/definitions/models.UserOutgoing"
+                }
+            }
+        },
+        "models.RetentionReport": {
+            "type": "object",
+            "properties": {
+                "counts": {
+                    "description": "The number of users each action will be taken on",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer"
+                    }
+                },
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.RetentionCandidate"
+                    }
+                },
+                "next_run_at": {
+                    "type": "string"
+                },
+                "pagination": {
+                    "$ref": "#/definitions/models.Pagination"
+                },
+                "rules": {
+                    "$ref": "#/definitions/models.RetentionRules"
+                }
+            }
+        },
+        "models.RetentionRules": {
+            "type": "object",
+            "properties": {
+                "erase_days": {
+                    "type": "integer"
+                },
+                "suspend_days": {
+                    "type": "integer"
+                },
+                "warn_days": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.Role": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.RoleBase": {
+            "type": "object",
+            "required": [
+                "name"
+            ],
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "permissions": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.Session": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.SessionIncoming": {
+            "type": "object",
+            "required": [
+                "password",
+                "user_name"
+            ],
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserAddress": {
+            "type": "object",
+            "required": [
+                "country",
+                "line1"
+            ],
+            "properties": {
+                "city": {
+                    "type": "string"
+                },
+                "country": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "is_primary": {
+                    "type": "boolean"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "line1": {
+                    "type": "string"
+                },
+                "line2": {
+                    "type": "string"
+                },
+                "postal_code": {
+                    "type": "string"
+                },
+                "region": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserBatchCreate": {
+            "type": "object",
+            "required": [
+                "items"
+            ],
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserIncoming"
+                    }
+                },
+                "mode": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserBatchDelete": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "description": "Only while numeric_user_ids is on",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "mode": {
+                    "type": "string"
+                },
+                "public_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "versions": {
+                    "description": "The version in each user's ETag, in the same order. If given, a user\nis only deleted if it's still at that version.",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                }
+            }
+        },
+        "models.UserBatchUpdate": {
+            "type": "object",
+            "required": [
+                "items"
+            ],
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserBatchUpdateItem"
+                    }
+                },
+                "mode": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserBatchUpdateItem": {
+            "type": "object",
+            "required": [
+                "password",
+                "user_name"
+            ],
+            "properties": {
+                "attributes": {
+                    "description": "Custom attributes, each validated by its registered schema",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "country": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "middle_name": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "phone_region": {
+                    "description": "The region to parse primary_phone_number in, if it has no country code",
+                    "type": "string"
+                },
+                "primary_phone_number": {
+                    "type": "string"
+                },
+                "public_id": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "The version in the user's ETag. If set, the user is only updated if\nit's still at that version.",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserDataExport": {
+            "type": "object",
+            "properties": {
+                "addresses": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserAddress"
+                    }
+                },
+                "emails": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserEmail"
+                    }
+                },
+                "erasures": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserErasure"
+                    }
+                },
+                "exported_at": {
+                    "type": "string"
+                },
+                "groups": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Group"
+                    }
+                },
+                "invitations": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Invitation"
+                    }
+                },
+                "name_changes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserNameChange"
+                    }
+                },
+                "phones": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserPhone"
+                    }
+                },
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Role"
+                    }
+                },
+                "sessions": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Session"
+                    }
+                },
+                "user": {
+                    "$ref": "#/definitions/models.UserOutgoing"
+                }
+            }
+        },
+        "models.UserEmail": {
+            "type": "object",
+            "required": [
+                "email"
+            ],
+            "properties": {
+                "email": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "is_primary": {
+                    "type": "boolean"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserErasure": {
+            "type": "object",
+            "properties": {
+                "erased_at": {
+                    "type": "string"
+                },
+                "erased_by": {
+                    "description": "The user who erased them, or nil for the admin",
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "public_id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserErasureIncoming": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserEvent": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {
+                    "description": "The user after the change, or before it if they were deleted",
+                    "$ref": "#/definitions/models.UserOutgoing"
+                },
+                "id": {
+                    "description": "The same wherever the event is delivered, to tell repeats apart",
+                    "type": "string"
+                },
+                "sequence": {
+                    "description": "Orders the organization's events. Consumers resume after the last one\nthey handled.",
+                    "type": "integer"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserEventPage": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UserEvent"
+                    }
+                },
+                "next": {
+                    "description": "The sequence to ask for events after next time",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserIncoming": {
+            "type": "object",
+            "required": [
+                "password",
+                "user_name"
+            ],
+            "properties": {
+                "attributes": {
+                    "description": "Custom attributes, each validated by its registered schema",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "country": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "middle_name": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                },
+                "phone_region": {
+                    "description": "The region to parse primary_phone_number in, if it has no country code",
+                    "type": "string"
+                },
+                "primary_phone_number": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserNameChange": {
+            "type": "object",
+            "properties": {
+                "changed_at": {
+                    "type": "string"
+                },
+                "reserved_until": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserOutgoing": {
+            "type": "object",
+            "required": [
+                "user_name"
+            ],
+            "properties": {
+                "attributes": {
+                    "description": "Custom attributes, each validated by its registered schema",
+                    "type": "object",
+                    "additionalProperties": true
+                },
+                "country": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "email_verified_at": {
+                    "type": "string"
+                },
+                "first_name": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_login_at": {
+                    "description": "When the user last signed in, and last made a request. last_seen_at is\nonly updated every few minutes, and neither changes the ETag.",
+                    "type": "string"
+                },
+                "last_name": {
+                    "type": "string"
+                },
+                "last_seen_at": {
+                    "type": "string"
+                },
+                "middle_name": {
+                    "type": "string"
+                },
+                "phone_verified_at": {
+                    "type": "string"
                 },
                 "primary_phone_number": {
                     "type": "string"
                 },
+                "primary_phone_number_display": {
+                    "type": "string"
+                },
+                "primary_phone_number_type": {
+                    "type": "string"
+                },
+                "public_id": {
+                    "description": "The id to use in URLs, which doesn't give away how many users there are",
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "status_changed_at": {
+                    "type": "string"
+                },
+                "status_reason": {
+                    "type": "string"
+                },
+                "user_name": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UserPhone": {
+            "type": "object",
+            "required": [
+                "phone_number"
+            ],
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "is_primary": {
+                    "type": "boolean"
+                },
+                "label": {
+                    "type": "string"
+                },
+                "phone_number": {
+                    "type": "string"
+                },
+                "phone_region": {
+                    "description": "The region to parse phone_number in, if it has no country code",
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.UserRename": {
+            "type": "object",
+            "required": [
+                "user_name"
+            ],
+            "properties": {
                 "user_name": {
                     "type": "string"
                 }
             }
+        },
+        "models.UserStatusChange": {
+            "type": "object",
+            "required": [
+                "reason"
+            ],
+            "properties": {
+                "reason": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Webhook": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "disabled": {
+                    "description": "Stops new events being sent. Deliveries already queued still are.",
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "The events sent to the webhook, e.g. user.created. Every event if empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "secret": {
+                    "description": "Only shown when the webhook is created",
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.WebhookDelivery": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "delivered_at": {
+                    "type": "string"
+                },
+                "event": {
+                    "type": "string"
+                },
+                "event_id": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "integer"
+                },
+                "last_attempt_at": {
+                    "type": "string"
+                },
+                "last_error": {
+                    "type": "string"
+                },
+                "next_attempt_at": {
+                    "type": "string"
+                },
+                "payload": {
+                    "$ref": "#/definitions/models.WebhookPayload"
+                },
+                "response_status": {
+                    "description": "Of the last attempt, if the webhook responded",
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "webhook_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.WebhookIncoming": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "description": {
+                    "type": "string"
+                },
+                "disabled": {
+                    "description": "Stops new events being sent. Deliveries already queued still are.",
+                    "type": "boolean"
+                },
+                "events": {
+                    "description": "The events sent to the webhook, e.g. user.created. Every event if empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "secret": {
+                    "description": "The key payloads are signed with. One is generated when a webhook is\ncreated without one, and it's kept when a webhook is updated without one.",
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.WebhookPayload": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {
+                    "$ref": "#/definitions/models.UserOutgoing"
+                },
+                "id": {
+                    "description": "The event's id, the same in each delivery of it",
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// A user's attributes can't be written, with the status to respond with
+type attributeError struct {
+	status  int
+	message string
+}
+
+func (e *attributeError) Error() string {
+	return e.message
+}
+
+// The names of the attributes in sorted order, so queries are deterministic
+func attributeNames(attributes map[string]interface{}) []string {
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func privateAttributeNames(db orm.DB) (map[string]bool, error) {
+	var names []string
+	if err := db.Model((*models.AttributeSchema)(nil)).Column("name").Where("private").Where(inTenant).Select(&names); err != nil {
+		return nil, err
+	}
+	private := make(map[string]bool)
+	for _, name := range names {
+		private[name] = true
+	}
+	return private, nil
+}
+
+// Check every attribute is registered and its value matches its schema
+func validateAttributes(db orm.DB, attributes map[string]interface{}) error {
+	if len(attributes) == 0 {
+		return nil
+	}
+	names := attributeNames(attributes)
+
+	var schemas []models.AttributeSchema
+	if err := db.Model(&schemas).WhereIn("name IN (?)", names).Where(inTenant).Select(); err != nil {
+		return err
+	}
+	schemasByName := make(map[string]models.AttributeSchema)
+	for _, schema := range schemas {
+		schemasByName[schema.Name] = schema
+	}
+
+	for _, name := range names {
+		schema, ok := schemasByName[name]
+		if !ok {
+			return &attributeError{http.StatusBadRequest, fmt.Sprintf("attributes.%s is not a registered attribute", name)}
+		}
+		result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema.Schema), gojsonschema.NewGoLoader(attributes[name]))
+		if err != nil {
+			return err
+		}
+		if !result.Valid() {
+			var problems []string
+			for _, resultError := range result.Errors() {
+				problems = append(problems, resultError.Description())
+			}
+			return &attributeError{http.StatusBadRequest, fmt.Sprintf("attributes.%s: %s", name, strings.Join(problems, "; "))}
+		}
+	}
+	return nil
+}
+
+// Only callers with the attributes:private permission can write private
+// attributes. Anyone else gets an error if they try, and the user's existing
+// private attributes are kept when they update it, as they couldn't see them
+// to send them back.
+func restrictPrivateAttributes(db orm.DB, userAccount *models.UserAccount, allowed bool) error {
+	if allowed {
+		return nil
+	}
+	private, err := privateAttributeNames(db)
+	if err != nil || len(private) == 0 {
+		return err
+	}
+	for _, name := range attributeNames(userAccount.Attributes) {
+		if private[name] {
+			return &attributeError{http.StatusForbidden, fmt.Sprintf("attributes.%s is private", name)}
+		}
+	}
+	if userAccount.Id == 0 {
+		return nil
+	}
+
+	var current models.UserAccount
+	current.Id = userAccount.Id
+	if err := db.Model(&current).Column("attributes").WherePK().Where(inTenant).Select(); err != nil {
+		// Missing users are reported by the update
+		if err == pg.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+	for name, value := range current.Attributes {
+		if private[name] {
+			userAccount.Attributes[name] = value
+		}
+	}
+	return nil
+}
+
+// Remove the private attributes from users about to be sent to a caller
+// without the attributes:private permission
+func redactPrivateAttributes(c *gin.Context, db orm.DB, usersOutgoing ...*models.UserOutgoing) {
+	if policy.Allowed(c, policy.AttributesPrivate) {
+		return
+	}
+	removePrivateAttributes(db, usersOutgoing...)
+}
+
+// Remove the private attributes from users, whoever they're sent to
+func removePrivateAttributes(db orm.DB, usersOutgoing ...*models.UserOutgoing) {
+	private, err := privateAttributeNames(db)
+	for _, userOutgoing := range usersOutgoing {
+		if userOutgoing == nil {
+			continue
+		}
+		// Err on the side of showing nothing
+		if err != nil {
+			userOutgoing.Attributes = map[string]interface{}{}
+			continue
+		}
+		for name := range private {
+			delete(userOutgoing.Attributes, name)
+		}
+	}
+}
+
+// Load an attribute schema. Private ones don't exist as far as callers without
+// the attributes:private permission are concerned. Returns false, after
+// writing the error response, if there isn't one.
+func retrieveAttributeSchema(c *gin.Context, db orm.DB, name string) (*models.AttributeSchema, bool) {
+	var attributeSchema models.AttributeSchema
+	attributeSchema.Name = name
+	err := db.Model(&attributeSchema).WherePK().Where(inTenant).Select()
+	if err != nil || (attributeSchema.Private && !policy.Allowed(c, policy.AttributesPrivate)) {
+		if err != nil {
+			c.Error(err)
+		}
+		c.JSON(http.StatusNotFound, gin.H{"message": "Attribute not found"})
+		return nil, false
+	}
+	return &attributeSchema, true
+}
+
+// @Summary Retrieve the schemas of all custom user attributes
+// @Produce  json
+// @Param   Authorization header string false "Bearer token, with attributes:private to include private attributes"
+// @Success 200 {array} models.AttributeSchema "The attribute schemas"
+// @Router /attributes [get]
+func RetrieveAttributeSchemas(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	attributeSchemas := []models.AttributeSchema{}
+	query := db.Model(&attributeSchemas).Where(inTenant).Order("name")
+	if !policy.Allowed(c, policy.AttributesPrivate) {
+		query = query.Where("NOT private")
+	}
+	if err := query.Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attributeSchemas})
+}
+
+// @Summary Retrieve the schema of a custom user attribute
+// @Produce  json
+// @Param   name path string true "The name of the attribute"
+// @Param   Authorization header string false "Bearer token, with attributes:private to see a private attribute"
+// @Success 200 {object} models.AttributeSchema "The attribute schema"
+// @Router /attributes/:name [get]
+func RetrieveAttributeSchema(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var attributeName models.AttributeName
+	if err := c.ShouldBindUri(&attributeName); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	attributeSchema, ok := retrieveAttributeSchema(c, db, attributeName.Name)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, attributeSchema)
+}
+
+// @Summary Register or replace the schema of a custom user attribute
+// @Accept  json
+// @Produce  json
+// @Param   name path string true "The name of the attribute"
+// @Param   schema body models.AttributeSchema true "The JSON Schema values of the attribute must match, and whether it is private"
+// @Param   Authorization header string true "Bearer token with attributes:manage"
+// @Success 200 {object} models.AttributeSchema "The attribute schema"
+// @Failure 403 {string} nil "Missing the attributes:manage permission"
+// @Router /attributes/:name [put]
+func UpdateAttributeSchema(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var attributeName models.AttributeName
+	if err := c.ShouldBindUri(&attributeName); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Get the request body
+	var attributeSchema models.AttributeSchema
+	if err := c.BindJSON(&attributeSchema); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	// The URL name overrides any model name
+	attributeSchema.AttributeName = attributeName
+
+	// The schema itself must be valid
+	if _, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(attributeSchema.Schema)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "schema is not a valid JSON Schema: " + err.Error()})
+		return
+	}
+
+	_, err := db.Model(&attributeSchema).
+		Value("tenant_id", "?tenant_id").
+		OnConflict("(tenant_id, name) DO UPDATE").
+		Set("schema = EXCLUDED.schema, private = EXCLUDED.private, updated_at = now()").
+		Returning("*").
+		Insert()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attributeSchema)
+}
+
+// @Summary Delete the schema of a custom user attribute no user has
+// @Produce  json
+// @Param   name path string true "The name of the attribute"
+// @Param   Authorization header string true "Bearer token with attributes:manage"
+// @Success 204 {string} nil
+// @Failure 403 {string} nil "Missing the attributes:manage permission"
+// @Failure 409 {string} nil "Some users have the attribute"
+// @Router /attributes/:name [delete]
+func DeleteAttributeSchema(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var attributeName models.AttributeName
+	if err := c.ShouldBindUri(&attributeName); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var status int
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		// Users' values would no longer validate
+		inUse, err := tx.Model((*models.UserAccount)(nil)).Where("jsonb_exists(attributes, ?)", attributeName.Name).Where(inTenant).Exists()
+		if err != nil {
+			return err
+		}
+		if inUse {
+			status = http.StatusConflict
+			return errors.New("Attribute is in use")
+		}
+
+		res, err := tx.Model(&models.AttributeSchema{AttributeName: attributeName}).WherePK().Where(inTenant).Delete()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			status = http.StatusNotFound
+			return errors.New("Attribute not found")
+		}
+		return nil
+	})
+	if err != nil {
+		c.Error(err)
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+		c.JSON(status, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
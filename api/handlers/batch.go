@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+// Batch endpoints are routed as /users:action, which only matches :batch
+func isBatchAction(c *gin.Context) bool {
+	if c.Param("action") != ":batch" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Not found"})
+		return false
+	}
+	return true
+}
+
+func setBatchDefaults() {
+	// The most items in a batch
+	viper.SetDefault("batch_max_items", 1000)
+	// The largest batch body, in bytes
+	viper.SetDefault("batch_max_bytes", 10<<20)
+	// How many passwords in a batch are hashed at once
+	viper.SetDefault("batch_hash_workers", runtime.NumCPU())
+}
+
+func checkBatchSize(c *gin.Context, size int) bool {
+	if size > viper.GetInt("batch_max_items") {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "too many items in batch"})
+		return false
+	}
+	return true
+}
+
+// Bind the batch in the request body, refusing bodies over batch_max_bytes
+// before any of it is read
+func bindBatch(c *gin.Context, batch interface{}) bool {
+	maxBytes := viper.GetInt64("batch_max_bytes")
+	if c.Request.ContentLength > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "batch is too large"})
+		return false
+	}
+	// Bodies without a length are cut off just past the limit
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxBytes+1))
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	if int64(len(body)) > maxBytes {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": "batch is too large"})
+		return false
+	}
+	if err := binding.JSON.BindBody(body, batch); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	return true
+}
+
+// The status and message for a failed write of a user account
+func userWriteError(err error) (int, string) {
+	var attributeErr *attributeError
+	if errors.As(err, &attributeErr) {
+		return attributeErr.status, attributeErr.message
+	}
+	var userNameErr *userNameError
+	if errors.As(err, &userNameErr) {
+		return userNameErr.status, userNameErr.message
+	}
+	if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
+		if strings.Contains(err.Error(), "user_name") {
+			return http.StatusBadRequest, "user_name already exists"
+		}
+		if strings.Contains(err.Error(), "email") {
+			return http.StatusBadRequest, "email already exists"
+		}
+		// Like two requests both making a contact primary
+		return http.StatusConflict, "changed by another request, try again"
+	}
+	// The user was deleted by another request
+	if strings.Contains(err.Error(), database.FK_ERROR_CODE) {
+		return http.StatusNotFound, "User Account not found"
+	}
+	return http.StatusServiceUnavailable, err.Error()
+}
+
+// How a batch item names its user: by public_id or, while numeric_user_ids
+// is on, id
+func batchUserParam(publicId string, id uint) string {
+	if publicId != "" {
+		return publicId
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// Validate and normalize the incoming users, hashing passwords in parallel
+// with a bounded number of workers
+func normalizeIncomingUserAccounts(usersIncoming []models.UserIncoming) ([]*models.UserAccount, []error) {
+	userAccounts := make([]*models.UserAccount, len(usersIncoming))
+	errs := make([]error, len(usersIncoming))
+
+	// Without a worker, nothing would take the indexes
+	workers := viper.GetInt("batch_hash_workers")
+	if workers < 1 {
+		workers = 1
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := binding.Validator.ValidateStruct(&usersIncoming[i]); err != nil {
+					errs[i] = err
+					continue
+				}
+				userAccounts[i], errs[i] = normalizeIncomingUserAccount(usersIncoming[i])
+			}
+		}()
+	}
+	for i := range usersIncoming {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	return userAccounts, errs
+}
+
+// Write every item whose result is still pending (zero status). In atomic mode
+// all the writes happen in one transaction, and any failure rolls back the
+// batch. Returns the status code for the batch as a whole.
+func runBatch(db *pg.DB, mode string, results []models.BatchItemResult, successStatus int, write func(db orm.DB, i int) models.BatchItemResult) int {
+	if mode == models.BatchModeBestEffort {
+		// Each item is written in a transaction of its own
+		for i := range results {
+			if results[i].Status == 0 {
+				db.RunInTransaction(func(tx *pg.Tx) error {
+					results[i] = write(tx, i)
+					if results[i].Status >= http.StatusMultipleChoices {
+						return errors.New(results[i].Message)
+					}
+					return nil
+				})
+			}
+		}
+		return http.StatusOK
+	}
+
+	// Atomic: nothing is written if any item is invalid
+	status := successStatus
+	for _, result := range results {
+		if result.Status != 0 {
+			status = http.StatusBadRequest
+		}
+	}
+
+	if status == successStatus {
+		err := db.RunInTransaction(func(tx *pg.Tx) error {
+			for i := range results {
+				results[i] = write(tx, i)
+				if results[i].Status >= http.StatusMultipleChoices {
+					status = results[i].Status
+					return errors.New(results[i].Message)
+				}
+			}
+			return nil
+		})
+		if err != nil && status == successStatus {
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	// Everything else in a failed batch was rolled back or never written
+	if status != successStatus {
+		for i := range results {
+			if results[i].Status < http.StatusMultipleChoices {
+				results[i] = models.BatchItemResult{
+					Index:   i,
+					Status:  http.StatusFailedDependency,
+					Message: "not written because another item in the batch failed",
+				}
+			}
+		}
+	}
+	return status
+}
+
+// @Summary Create users in a batch
+// @Accept  json
+// @Produce  json
+// @Param   users      	body	models.UserBatchCreate	true "The users to be created, and whether to create them atomically (default) or best effort"
+// @Success 201 {object} models.BatchResult "The status of each item in the atomic batch"
+// @Success 200 {object} models.BatchResult "The status of each item in the best effort batch"
+// @Failure 400 {object} models.BatchResult "The atomic batch failed, nothing was created"
+// @Router /users:batch [post]
+func CreateUsersBatch(c *gin.Context) {
+	if !isBatchAction(c) {
+		return
+	}
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var batch models.UserBatchCreate
+	if !bindBatch(c, &batch) {
+		return
+	}
+	if batch.Mode == "" {
+		batch.Mode = models.BatchModeAtomic
+	}
+	if !checkBatchSize(c, len(batch.Items)) {
+		return
+	}
+
+	userAccounts, errs := normalizeIncomingUserAccounts(batch.Items)
+	results := make([]models.BatchItemResult, len(batch.Items))
+	for i, err := range errs {
+		if err != nil {
+			results[i] = models.BatchItemResult{Index: i, Status: http.StatusBadRequest, Message: err.Error()}
+		}
+	}
+
+	status := runBatch(db, batch.Mode, results, http.StatusCreated, func(db orm.DB, i int) models.BatchItemResult {
+		userAccount := userAccounts[i]
+		err := restrictPrivateAttributes(db, userAccount, policy.Allowed(c, policy.AttributesPrivate))
+		if err == nil {
+			err = insertUserAccount(db, userAccount)
+		}
+		if err == nil {
+			err = recordUserEvent(db, models.UserEventCreated, userAccount)
+		}
+		if err != nil {
+			status, message := userWriteError(err)
+			return models.BatchItemResult{Index: i, Status: status, Message: message}
+		}
+		return models.BatchItemResult{
+			Index:  i,
+			Status: http.StatusCreated,
+			User:   newUserOutgoing(userAccount),
+		}
+	})
+
+	for _, result := range results {
+		redactPrivateAttributes(c, db, result.User)
+	}
+	c.JSON(status, models.BatchResult{Mode: batch.Mode, Results: results})
+}
+
+// @Summary Update users in a batch
+// @Accept  json
+// @Produce  json
+// @Param   users      	body	models.UserBatchUpdate	true "The users to be updated, by public_id (or, for now, id), and whether to update them atomically (default) or best effort"
+// @Success 200 {object} models.BatchResult "The status of each item"
+// @Failure 400 {object} models.BatchResult "The atomic batch failed, nothing was updated"
+// @Router /users:batch [put]
+func UpdateUsersBatch(c *gin.Context) {
+	if !isBatchAction(c) {
+		return
+	}
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var batch models.UserBatchUpdate
+	if !bindBatch(c, &batch) {
+		return
+	}
+	if batch.Mode == "" {
+		batch.Mode = models.BatchModeAtomic
+	}
+	if !checkBatchSize(c, len(batch.Items)) {
+		return
+	}
+
+	usersIncoming := make([]models.UserIncoming, len(batch.Items))
+	for i, item := range batch.Items {
+		usersIncoming[i] = item.UserIncoming
+	}
+	userAccounts, errs := normalizeIncomingUserAccounts(usersIncoming)
+	results := make([]models.BatchItemResult, len(batch.Items))
+	for i, err := range errs {
+		if err != nil {
+			results[i] = models.BatchItemResult{Index: i, Status: http.StatusBadRequest, Message: err.Error()}
+		} else if batch.Items[i].Version == 0 && viper.GetBool("require_if_match") {
+			results[i] = models.BatchItemResult{Index: i, Status: http.StatusPreconditionRequired, Message: "version is required"}
+		}
+	}
+
+	status := runBatch(db, batch.Mode, results, http.StatusOK, func(db orm.DB, i int) models.BatchItemResult {
+		userAccount := userAccounts[i]
+		userId, err := resolveUserID(db, batchUserParam(batch.Items[i].PublicId, batch.Items[i].Id))
+		if err != nil {
+			return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		if userId == 0 {
+			return models.BatchItemResult{Index: i, Status: http.StatusNotFound, Message: "User Account not found"}
+		}
+		userAccount.UserID = models.UserID{Id: userId}
+		if err := restrictPrivateAttributes(db, userAccount, policy.Allowed(c, policy.AttributesPrivate)); err != nil {
+			status, message := userWriteError(err)
+			return models.BatchItemResult{Index: i, Status: status, Message: message}
+		}
+		version := batch.Items[i].Version
+		res, err := updateUserAccount(db, userAccount, int(version))
+		if err != nil {
+			status, message := userWriteError(err)
+			return models.BatchItemResult{Index: i, Status: status, Message: message}
+		}
+		if res.RowsAffected() == 0 {
+			if version != 0 {
+				exists, err := db.Model((*models.UserAccount)(nil)).Where("id = ?", userAccount.Id).Where(inTenant).Exists()
+				if err != nil {
+					return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+				}
+				if exists {
+					return models.BatchItemResult{Index: i, Status: http.StatusPreconditionFailed, Message: "User Account has been modified"}
+				}
+			}
+			return models.BatchItemResult{Index: i, Status: http.StatusNotFound, Message: "User Account not found"}
+		}
+		if err := recordUserEvent(db, models.UserEventUpdated, userAccount); err != nil {
+			return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		return models.BatchItemResult{
+			Index:  i,
+			Status: http.StatusOK,
+			User:   newUserOutgoing(userAccount),
+		}
+	})
+
+	for _, result := range results {
+		redactPrivateAttributes(c, db, result.User)
+	}
+	c.JSON(status, models.BatchResult{Mode: batch.Mode, Results: results})
+}
+
+// @Summary Delete users in a batch
+// @Accept  json
+// @Produce  json
+// @Param   users      	body	models.UserBatchDelete	true "The public_ids (or, for now, ids) of the users to be deleted, optionally their versions, and whether to delete them atomically (default) or best effort"
+// @Success 200 {object} models.BatchResult "The status of each item"
+// @Failure 400 {object} models.BatchResult "The atomic batch failed, nothing was deleted"
+// @Router /users:batch [delete]
+func DeleteUsersBatch(c *gin.Context) {
+	if !isBatchAction(c) {
+		return
+	}
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var batch models.UserBatchDelete
+	if !bindBatch(c, &batch) {
+		return
+	}
+	if batch.Mode == "" {
+		batch.Mode = models.BatchModeAtomic
+	}
+	if len(batch.PublicIds) > 0 && len(batch.Ids) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "give public_ids or ids, not both"})
+		return
+	}
+	params := batch.PublicIds
+	for _, id := range batch.Ids {
+		params = append(params, batchUserParam("", id))
+	}
+	if !checkBatchSize(c, len(params)) {
+		return
+	}
+	if len(batch.Versions) > 0 && len(batch.Versions) != len(params) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "give a version for every user, or none"})
+		return
+	}
+	versions := batch.Versions
+	if len(versions) == 0 {
+		versions = make([]uint, len(params))
+	}
+
+	results := make([]models.BatchItemResult, len(params))
+	for i, version := range versions {
+		if version == 0 && viper.GetBool("require_if_match") {
+			results[i] = models.BatchItemResult{Index: i, Status: http.StatusPreconditionRequired, Message: "version is required"}
+		}
+	}
+
+	status := runBatch(db, batch.Mode, results, http.StatusOK, func(db orm.DB, i int) models.BatchItemResult {
+		userId, err := resolveUserID(db, params[i])
+		if err != nil {
+			return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		if userId == 0 {
+			return models.BatchItemResult{Index: i, Status: http.StatusNotFound, Message: "User Account not found"}
+		}
+		var userAccount models.UserAccount
+		userAccount.Id = userId
+		query := db.Model(&userAccount).WherePK().Where(inTenant)
+		if versions[i] != 0 {
+			query = query.Where("version = ?", versions[i])
+		}
+		res, err := query.Returning("*").Delete()
+		if err != nil {
+			return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		if res.RowsAffected() == 0 {
+			if versions[i] != 0 {
+				exists, err := db.Model((*models.UserAccount)(nil)).Where("id = ?", userId).Where(inTenant).Exists()
+				if err != nil {
+					return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+				}
+				if exists {
+					return models.BatchItemResult{Index: i, Status: http.StatusPreconditionFailed, Message: "User Account has been modified"}
+				}
+			}
+			return models.BatchItemResult{Index: i, Status: http.StatusNotFound, Message: "User Account not found"}
+		}
+		if err := recordUserEvent(db, models.UserEventDeleted, &userAccount); err != nil {
+			return models.BatchItemResult{Index: i, Status: http.StatusServiceUnavailable, Message: err.Error()}
+		}
+		return models.BatchItemResult{Index: i, Status: http.StatusNoContent}
+	})
+
+	c.JSON(status, models.BatchResult{Mode: batch.Mode, Results: results})
+}
@@ -0,0 +1,557 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/pii"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/nyaruka/phonenumbers"
+)
+
+// A kind of contact method a user can have many of, exactly one of which is
+// primary
+type contactKind struct {
+	name  string
+	table string
+	// The column of the kind's table holding the contact
+	column string
+	// The user field the contact is encrypted as, if it can be, which is
+	// indexed in the column's _index column
+	field string
+	// The user_accounts column the primary contact is projected onto, if any
+	flatColumn string
+	// The user_accounts column recording when the flat column was verified,
+	// if any, which is cleared when the primary contact changes
+	verifiedColumn string
+	newContact     func() models.ContactMethod
+	newContacts    func() interface{}
+	normalize      func(contact models.ContactMethod, userAccount *models.UserAccount) error
+}
+
+var emailContacts = contactKind{
+	name:           "Email",
+	table:          "user_emails",
+	column:         "email",
+	field:          "email",
+	flatColumn:     "email",
+	verifiedColumn: "email_verified_at",
+	newContact:     func() models.ContactMethod { return &models.UserEmail{} },
+	newContacts:    func() interface{} { return &[]models.UserEmail{} },
+	normalize: func(contact models.ContactMethod, userAccount *models.UserAccount) error {
+		// Checked with the same email binding as the user's email
+		userEmail := contact.(*models.UserEmail)
+		userEmail.Email = strings.TrimSpace(userEmail.Email)
+		return binding.Validator.ValidateStruct(userEmail)
+	},
+}
+
+var phoneContacts = contactKind{
+	name:           "Phone",
+	table:          "user_phones",
+	column:         "phone_number",
+	field:          "primary_phone_number",
+	flatColumn:     "primary_phone_number",
+	verifiedColumn: "phone_verified_at",
+	newContact:     func() models.ContactMethod { return &models.UserPhone{} },
+	newContacts:    func() interface{} { return &[]models.UserPhone{} },
+	normalize: func(contact models.ContactMethod, userAccount *models.UserAccount) error {
+		userPhone := contact.(*models.UserPhone)
+		region := phoneRegion(userPhone.PhoneRegion, userAccount.Country)
+		phoneNumber, err := parsePhoneNumber("phone_number", userPhone.PhoneNumber, region)
+		if err != nil {
+			return err
+		}
+		userPhone.PhoneNumber = phonenumbers.Format(phoneNumber, phonenumbers.E164)
+		userPhone.PhoneRegion = ""
+		return nil
+	},
+}
+
+var addressContacts = contactKind{
+	name:        "Address",
+	table:       "user_addresses",
+	column:      "line1",
+	newContact:  func() models.ContactMethod { return &models.UserAddress{} },
+	newContacts: func() interface{} { return &[]models.UserAddress{} },
+	normalize: func(contact models.ContactMethod, userAccount *models.UserAccount) error {
+		userAddress := contact.(*models.UserAddress)
+		userAddress.Country = strings.ToUpper(userAddress.Country)
+		return nil
+	},
+}
+
+// Load the user the contact methods belong to. Returns false, after writing
+// the error response, if there isn't one.
+func retrieveContactUser(c *gin.Context, db orm.DB, userId uint) (*models.UserAccount, bool) {
+	var userAccount models.UserAccount
+	userAccount.Id = userId
+	if err := db.Model(&userAccount).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return nil, false
+	}
+	return &userAccount, true
+}
+
+// Copy the user's primary contact of this kind onto the user account, with
+// its blind index, and record the user as updated
+func projectPrimaryContact(db orm.DB, kind contactKind, userId uint) error {
+	if kind.flatColumn == "" {
+		return nil
+	}
+	if kind.verifiedColumn != "" {
+		// Encrypted copies of the same value differ, but their indexes don't
+		_, err := db.Exec(`
+			UPDATE user_accounts SET ? = NULL
+			WHERE id = ? AND ? IS DISTINCT FROM (SELECT ? FROM ? WHERE user_id = ? AND is_primary)`,
+			pg.F(kind.verifiedColumn), userId, pg.F(matchColumn(kind.flatColumn, kind.field)),
+			pg.F(matchColumn(kind.column, kind.field)), pg.F(kind.table), userId)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := db.Exec(`
+		UPDATE user_accounts
+		SET (?, ?) = (SELECT ?, ? FROM ? WHERE user_id = ? AND is_primary), version = version + 1
+		WHERE id = ?`,
+		pg.F(kind.flatColumn), pg.F(kind.flatColumn+"_index"), pg.F(kind.column), pg.F(kind.column+"_index"),
+		pg.F(kind.table), userId, userId)
+	if err != nil {
+		return err
+	}
+
+	var userAccount models.UserAccount
+	userAccount.Id = userId
+	if err := db.Model(&userAccount).WherePK().Select(); err != nil {
+		return err
+	}
+	return recordUserEvent(db, models.UserEventUpdated, &userAccount)
+}
+
+// Make the oldest of the user's contacts of this kind primary, if none is
+func promoteContact(db orm.DB, kind contactKind, userId uint) error {
+	_, err := db.Exec(`
+		UPDATE ? SET is_primary = TRUE
+		WHERE id = (SELECT min(id) FROM ? WHERE user_id = ?)
+		AND NOT EXISTS (SELECT 1 FROM ? WHERE user_id = ? AND is_primary)`,
+		pg.F(kind.table), pg.F(kind.table), userId, pg.F(kind.table), userId)
+	return err
+}
+
+func demoteContacts(db orm.DB, kind contactKind, userId uint) error {
+	_, err := db.Exec("UPDATE ? SET is_primary = FALSE WHERE user_id = ? AND is_primary", pg.F(kind.table), userId)
+	return err
+}
+
+// Keep the primary contact of this kind in step with the user account's flat
+// field, which is set to the new primary if it's cleared. Called as part of
+// an insert or update of the user account, so doesn't bump its version.
+func syncPrimaryContact(db orm.DB, kind contactKind, userId uint, value *string) error {
+	if *value != "" {
+		stored, index, err := encryptField(db, kind.field, *value)
+		if err != nil {
+			return err
+		}
+		res, err := db.Exec("UPDATE ? SET ? = ?, ? = NULLIF(?, '') WHERE user_id = ? AND is_primary",
+			pg.F(kind.table), pg.F(kind.column), stored, pg.F(kind.column+"_index"), index, userId)
+		if err != nil || res.RowsAffected() > 0 {
+			return err
+		}
+		_, err = db.Exec("INSERT INTO ? (user_id, ?, ?, is_primary) VALUES (?, ?, NULLIF(?, ''), TRUE)",
+			pg.F(kind.table), pg.F(kind.column), pg.F(kind.column+"_index"), userId, stored, index)
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM ? WHERE user_id = ? AND is_primary", pg.F(kind.table), userId); err != nil {
+		return err
+	}
+	if err := promoteContact(db, kind, userId); err != nil {
+		return err
+	}
+	if _, err := db.QueryOne(pg.Scan(value), "SELECT coalesce(max(?), '') FROM ? WHERE user_id = ? AND is_primary",
+		pg.F(kind.column), pg.F(kind.table), userId); err != nil || *value == "" {
+		return err
+	}
+	_, err := db.Exec(`
+		UPDATE user_accounts SET (?, ?) = (SELECT ?, ? FROM ? WHERE user_id = ? AND is_primary)
+		WHERE id = ?`,
+		pg.F(kind.flatColumn), pg.F(kind.flatColumn+"_index"), pg.F(kind.column), pg.F(kind.column+"_index"),
+		pg.F(kind.table), userId, userId)
+	if err != nil {
+		return err
+	}
+	if pii.Encrypted(kind.field) {
+		*value, err = pii.Decrypt(db, *value)
+	}
+	return err
+}
+
+// Keep the primary contacts in step with the user account's flat fields
+func syncPrimaryContacts(db orm.DB, userAccount *models.UserAccount) error {
+	if err := syncPrimaryContact(db, emailContacts, userAccount.Id, &userAccount.Email); err != nil {
+		return err
+	}
+	return syncPrimaryContact(db, phoneContacts, userAccount.Id, &userAccount.PrimaryPhoneNumber)
+}
+
+func listContacts(c *gin.Context, kind contactKind) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+	if _, ok := retrieveContactUser(c, db, userId.Id); !ok {
+		return
+	}
+
+	contacts := kind.newContacts()
+	if err := db.Model(contacts).Where("user_id = ?", userId.Id).Order("id").Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": contacts})
+}
+
+func createContact(c *gin.Context, kind contactKind) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the request body
+	contact := kind.newContact()
+	if err := c.BindJSON(contact); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	userAccount, ok := retrieveContactUser(c, db, userId.Id)
+	if !ok {
+		return
+	}
+	if err := kind.normalize(contact, userAccount); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	base := contact.ContactBase()
+	base.Id = 0
+	base.UserId = userId.Id
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		// The first contact of a kind is always primary
+		count, err := tx.Model(kind.newContacts()).Where("user_id = ?", userId.Id).Count()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			base.IsPrimary = true
+		}
+		if base.IsPrimary {
+			if err := demoteContacts(tx, kind, userId.Id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Model(contact).Insert(); err != nil {
+			return err
+		}
+		return projectPrimaryContact(tx, kind, userId.Id)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, contact)
+}
+
+// Load the contact in the URL. Returns false, after writing the error
+// response, if there isn't one.
+func retrieveContact(c *gin.Context, db orm.DB, kind contactKind, contactId *models.ContactID) (models.ContactMethod, bool) {
+	if err := c.ShouldBindUri(contactId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return nil, false
+	}
+	if !bindUserID(c, db, &contactId.UserID) {
+		return nil, false
+	}
+
+	contact := kind.newContact()
+	contact.ContactBase().Id = contactId.ContactId
+	err := db.Model(contact).
+		WherePK().
+		Where("user_id = ?", contactId.Id).
+		Where("user_id IN (" + tenantUserIdsQuery + ")").
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": kind.name + " not found"})
+		return nil, false
+	}
+	return contact, true
+}
+
+func retrieveContactHandler(c *gin.Context, kind contactKind) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var contactId models.ContactID
+	contact, ok := retrieveContact(c, db, kind, &contactId)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func updateContact(c *gin.Context, kind contactKind) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var contactId models.ContactID
+	existing, ok := retrieveContact(c, db, kind, &contactId)
+	if !ok {
+		return
+	}
+
+	// Get the request body
+	contact := kind.newContact()
+	if err := c.BindJSON(contact); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	userAccount, ok := retrieveContactUser(c, db, contactId.Id)
+	if !ok {
+		return
+	}
+	if err := kind.normalize(contact, userAccount); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// The URL IDs override any model IDs
+	base := contact.ContactBase()
+	base.Id = contactId.ContactId
+	base.UserId = contactId.Id
+	if existing.ContactBase().IsPrimary && !base.IsPrimary {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "make another " + kind.name + " primary instead"})
+		return
+	}
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		if base.IsPrimary {
+			if err := demoteContacts(tx, kind, contactId.Id); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Model(contact).WherePK().Update(); err != nil {
+			return err
+		}
+		return projectPrimaryContact(tx, kind, contactId.Id)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusOK, contact)
+}
+
+func deleteContact(c *gin.Context, kind contactKind) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var contactId models.ContactID
+	contact, ok := retrieveContact(c, db, kind, &contactId)
+	if !ok {
+		return
+	}
+
+	// Another contact becomes primary if the primary is deleted
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(contact).WherePK().Delete(); err != nil {
+			return err
+		}
+		if err := promoteContact(tx, kind, contactId.Id); err != nil {
+			return err
+		}
+		return projectPrimaryContact(tx, kind, contactId.Id)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Retrieve a user's email addresses
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {array} models.UserEmail "The user's email addresses"
+// @Router /users/:id/emails [get]
+func RetrieveUserEmails(c *gin.Context) {
+	listContacts(c, emailContacts)
+}
+
+// @Summary Add an email address to a user
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   email body models.UserEmail true "The email address, primary if it's the first"
+// @Success 201 {object} models.UserEmail
+// @Router /users/:id/emails [post]
+func CreateUserEmail(c *gin.Context) {
+	createContact(c, emailContacts)
+}
+
+// @Summary Retrieve a user's email address
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the email address"
+// @Success 200 {object} models.UserEmail
+// @Router /users/:id/emails/:contact_id [get]
+func RetrieveUserEmail(c *gin.Context) {
+	retrieveContactHandler(c, emailContacts)
+}
+
+// @Summary Update a user's email address
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the email address"
+// @Param   email body models.UserEmail true "The email address, made primary if is_primary"
+// @Success 200 {object} models.UserEmail
+// @Router /users/:id/emails/:contact_id [put]
+func UpdateUserEmail(c *gin.Context) {
+	updateContact(c, emailContacts)
+}
+
+// @Summary Delete a user's email address
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the email address"
+// @Success 204 {string} nil
+// @Router /users/:id/emails/:contact_id [delete]
+func DeleteUserEmail(c *gin.Context) {
+	deleteContact(c, emailContacts)
+}
+
+// @Summary Retrieve a user's phone numbers
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {array} models.UserPhone "The user's phone numbers"
+// @Router /users/:id/phones [get]
+func RetrieveUserPhones(c *gin.Context) {
+	listContacts(c, phoneContacts)
+}
+
+// @Summary Add a phone number to a user
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   phone body models.UserPhone true "The phone number, primary if it's the first"
+// @Success 201 {object} models.UserPhone
+// @Router /users/:id/phones [post]
+func CreateUserPhone(c *gin.Context) {
+	createContact(c, phoneContacts)
+}
+
+// @Summary Retrieve a user's phone number
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the phone number"
+// @Success 200 {object} models.UserPhone
+// @Router /users/:id/phones/:contact_id [get]
+func RetrieveUserPhone(c *gin.Context) {
+	retrieveContactHandler(c, phoneContacts)
+}
+
+// @Summary Update a user's phone number
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the phone number"
+// @Param   phone body models.UserPhone true "The phone number, made primary if is_primary"
+// @Success 200 {object} models.UserPhone
+// @Router /users/:id/phones/:contact_id [put]
+func UpdateUserPhone(c *gin.Context) {
+	updateContact(c, phoneContacts)
+}
+
+// @Summary Delete a user's phone number
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the phone number"
+// @Success 204 {string} nil
+// @Router /users/:id/phones/:contact_id [delete]
+func DeleteUserPhone(c *gin.Context) {
+	deleteContact(c, phoneContacts)
+}
+
+// @Summary Retrieve a user's postal addresses
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {array} models.UserAddress "The user's postal addresses"
+// @Router /users/:id/addresses [get]
+func RetrieveUserAddresses(c *gin.Context) {
+	listContacts(c, addressContacts)
+}
+
+// @Summary Add a postal address to a user
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   address body models.UserAddress true "The postal address, primary if it's the first"
+// @Success 201 {object} models.UserAddress
+// @Router /users/:id/addresses [post]
+func CreateUserAddress(c *gin.Context) {
+	createContact(c, addressContacts)
+}
+
+// @Summary Retrieve a user's postal address
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the postal address"
+// @Success 200 {object} models.UserAddress
+// @Router /users/:id/addresses/:contact_id [get]
+func RetrieveUserAddress(c *gin.Context) {
+	retrieveContactHandler(c, addressContacts)
+}
+
+// @Summary Update a user's postal address
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the postal address"
+// @Param   address body models.UserAddress true "The postal address, made primary if is_primary"
+// @Success 200 {object} models.UserAddress
+// @Router /users/:id/addresses/:contact_id [put]
+func UpdateUserAddress(c *gin.Context) {
+	updateContact(c, addressContacts)
+}
+
+// @Summary Delete a user's postal address
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   contact_id path int true "The id of the postal address"
+// @Success 204 {string} nil
+// @Router /users/:id/addresses/:contact_id [delete]
+func DeleteUserAddress(c *gin.Context) {
+	deleteContact(c, addressContacts)
+}
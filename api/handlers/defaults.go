@@ -0,0 +1,20 @@
+package handlers
+
+// Register the defaults of the handlers' config. It's called once at
+// startup, as viper's defaults can't be set while they're being read.
+func SetDefaults() {
+	setPreconditionDefaults()
+	setBatchDefaults()
+	setPhoneDefaults()
+	setPublicIdDefaults()
+	setSessionDefaults()
+	setUserNameDefaults()
+	setPhoneVerificationDefaults()
+	setRegistrationDefaults()
+	setInvitationDefaults()
+	setImportDefaults()
+	setRetentionDefaults()
+	setUserEventRelayDefaults()
+	setWebhookDefaults()
+	setUserEventDefaults()
+}
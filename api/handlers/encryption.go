@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/pii"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// The column a user field's value is matched on: its blind index if it's
+// encrypted, as encrypting the same value twice gives different ciphertexts
+func matchColumn(column string, field string) string {
+	if pii.Encrypted(field) {
+		return column + "_index"
+	}
+	return column
+}
+
+// The params to match a user field's column to a value with "? = ?"
+func piiMatch(field string, value string) []interface{} {
+	if pii.Encrypted(field) {
+		return []interface{}{pg.F(field + "_index"), pii.BlindIndex(field, value)}
+	}
+	return []interface{}{pg.F(field), value}
+}
+
+// The condition and params matching a user's email whatever its case.
+// Encrypted emails are indexed lower cased.
+func emailMatch(email string) (string, []interface{}) {
+	if pii.Encrypted("email") {
+		return "? = ?", piiMatch("email", email)
+	}
+	return "lower(email) = lower(?)", []interface{}{email}
+}
+
+// Encrypt a user field's value, if the field is encrypted, to write it
+// without a model. Returns the value to store and its blind index.
+func encryptField(db orm.DB, field string, value string) (string, string, error) {
+	if !pii.Encrypted(field) {
+		return value, "", nil
+	}
+	stored, err := pii.Encrypt(db, value)
+	return stored, pii.BlindIndex(field, value), err
+}
+
+// The tables with encrypted fields, and the columns a rotation rewrites
+var encryptedTables = []struct {
+	name string
+	// The column the rows are batched by
+	key string
+	// Restricts the rows to the organization's
+	inTenant string
+	newRows  func() interface{}
+	columns  []string
+}{
+	{
+		name:     "user_accounts",
+		key:      "id",
+		inTenant: inTenant,
+		newRows:  func() interface{} { return &[]models.UserAccount{} },
+		columns: []string{
+			"first_name", "middle_name", "last_name", "email", "primary_phone_number",
+			"first_name_index", "middle_name_index", "last_name_index", "email_index", "primary_phone_number_index",
+		},
+	},
+	{
+		name:     "user_emails",
+		key:      "id",
+		inTenant: "user_id IN (" + tenantUserIdsQuery + ")",
+		newRows:  func() interface{} { return &[]models.UserEmail{} },
+		columns:  []string{"email", "email_index"},
+	},
+	{
+		name:     "user_phones",
+		key:      "id",
+		inTenant: "user_id IN (" + tenantUserIdsQuery + ")",
+		newRows:  func() interface{} { return &[]models.UserPhone{} },
+		columns:  []string{"phone_number", "phone_number_index"},
+	},
+	{
+		name:     "phone_verifications",
+		key:      "user_id",
+		inTenant: "user_id IN (" + tenantUserIdsQuery + ")",
+		newRows:  func() interface{} { return &[]models.PhoneVerification{} },
+		columns:  []string{"phone_number", "phone_number_index"},
+	},
+	{
+		name:     "email_verifications",
+		key:      "user_id",
+		inTenant: "user_id IN (" + tenantUserIdsQuery + ")",
+		newRows:  func() interface{} { return &[]models.EmailVerification{} },
+		columns:  []string{"email", "email_index"},
+	},
+	{
+		name:     "invitations",
+		key:      "id",
+		inTenant: inTenant,
+		newRows:  func() interface{} { return &[]models.Invitation{} },
+		columns:  []string{"email", "email_index"},
+	},
+}
+
+// Encrypt the data keys with the current master key, then rewrite the
+// organizations' users' encrypted fields with a new data key and the current
+// index key, a batch of rows per transaction. Fields not encrypted yet are,
+// and ones taken out of pii_fields are decrypted. Returns the number of data
+// keys re-encrypted and the rows rewritten by table.
+func RotateKeys(db *pg.DB, organizations []models.Organization, batchSize int, progress func(organization string, table string, rows int)) (int, map[string]int, error) {
+	rewritten := make(map[string]int)
+	dataKeys, err := pii.RotateDataKeys(db)
+	if err != nil {
+		return 0, rewritten, err
+	}
+
+	for _, organization := range organizations {
+		if err := rotateTenantKeys(db.WithParam("tenant_id", organization.Id), batchSize, rewritten, func(table string, rows int) {
+			progress(organization.Slug, table, rows)
+		}); err != nil {
+			return dataKeys, rewritten, err
+		}
+	}
+	return dataKeys, rewritten, nil
+}
+
+// Rewrite the encrypted fields of the organization bound to db, adding the
+// rows rewritten to the counts by table
+func rotateTenantKeys(db *pg.DB, batchSize int, rewritten map[string]int, progress func(table string, rows int)) error {
+	for _, table := range encryptedTables {
+		key := pg.F(table.key)
+		var lastId uint
+		rows := 0
+		for {
+			var ids []uint
+			_, err := db.Query(&ids, "SELECT ? FROM ? WHERE ? > ? AND "+table.inTenant+" ORDER BY ? LIMIT ?",
+				key, pg.F(table.name), key, lastId, key, batchSize)
+			if err != nil {
+				return err
+			}
+			if len(ids) == 0 {
+				break
+			}
+
+			// The hooks decrypt the rows as they're read, even the fields
+			// no longer encrypted, and encrypt them again as they're written
+			err = db.RunInTransaction(func(tx *pg.Tx) error {
+				rows := table.newRows()
+				if err := tx.ModelContext(pii.ForRotation(context.Background()), rows).Where("? IN (?)", key, pg.In(ids)).For("UPDATE").Select(); err != nil {
+					return err
+				}
+				// Unless they've all been deleted since
+				if reflect.ValueOf(rows).Elem().Len() == 0 {
+					return nil
+				}
+				_, err := tx.Model(rows).Column(table.columns...).WherePK().Update()
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			lastId = ids[len(ids)-1]
+			rows += len(ids)
+			rewritten[table.name] += len(ids)
+			progress(table.name, rows)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/broker"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+// The advisory lock held while events are published, so only one process
+// publishes them, in order
+const userEventRelayLockKey = 50050
+
+// How many events are published at a time
+const userEventRelayBatchSize = 100
+
+func setUserEventRelayDefaults() {
+	// The topic, or NATS subject, events are published to. {organization} is
+	// replaced by the organization's slug, and {type} by the event's type,
+	// e.g. user.created. A user's events are only in order within a topic, so
+	// with {type} a user's update can arrive before their creation.
+	viper.SetDefault("events_topic", "users")
+	// The CloudEvents source of the events, followed by /organizations/ and
+	// the organization's slug
+	viper.SetDefault("events_source", "/golang-user-crud")
+	// How often the outbox is checked for events to publish
+	viper.SetDefault("events_relay_interval", "1s")
+}
+
+// The event as a CloudEvent, keyed by the user's id so a user's events stay
+// in order
+func userEventMessage(event *models.UserEvent, organization *models.Organization) (broker.Message, error) {
+	key := strconv.FormatUint(uint64(event.UserId), 10)
+	cloudEvent := broker.CloudEvent{
+		SpecVersion:     "1.0",
+		Id:              event.Id,
+		Source:          viper.GetString("events_source") + "/organizations/" + organization.Slug,
+		Type:            event.Type,
+		Time:            event.CreatedAt,
+		DataContentType: "application/json",
+		PartitionKey:    key,
+		Data:            event.Data,
+	}
+	if event.Data != nil {
+		cloudEvent.Subject = event.Data.PublicId
+	}
+	value, err := json.Marshal(cloudEvent)
+	if err != nil {
+		return broker.Message{}, err
+	}
+
+	topic := strings.NewReplacer("{organization}", organization.Slug, "{type}", event.Type).
+		Replace(viper.GetString("events_topic"))
+	return broker.Message{Topic: topic, Key: key, Id: event.Id, Value: value}, nil
+}
+
+// Publish the next batch of events after each organization's checkpoint, in
+// sequence order, then advance the checkpoints past them. An organization's
+// events are committed in sequence order, so none are skipped. Returns how
+// many were published.
+func relayUserEventBatch(db *pg.DB, publisher broker.Publisher, organizations map[uint]*models.Organization) (int, error) {
+	var events []models.UserEvent
+	err := db.Model(&events).
+		Join("LEFT JOIN user_event_checkpoints AS checkpoint ON checkpoint.tenant_id = user_event.tenant_id").
+		Where("user_event.sequence > coalesce(checkpoint.sequence, 0)").
+		Order("user_event.sequence").
+		Limit(userEventRelayBatchSize).
+		Select()
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	messages := make([]broker.Message, len(events))
+	checkpoints := make(map[uint]int64)
+	for i := range events {
+		organization := organizations[events[i].TenantId]
+		if organization == nil {
+			// Created since the organizations were loaded
+			events = events[:i]
+			messages = messages[:i]
+			break
+		}
+		if messages[i], err = userEventMessage(&events[i], organization); err != nil {
+			return 0, err
+		}
+		checkpoints[events[i].TenantId] = events[i].Sequence
+	}
+	if len(messages) == 0 {
+		return 0, nil
+	}
+	if err := publisher.Publish(messages...); err != nil {
+		return 0, err
+	}
+
+	for tenantId, sequence := range checkpoints {
+		checkpoint := &models.UserEventCheckpoint{TenantId: tenantId, Sequence: sequence}
+		_, err := db.Model(checkpoint).
+			OnConflict("(tenant_id) DO UPDATE").
+			Set("sequence = EXCLUDED.sequence, updated_at = now()").
+			Insert()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), nil
+}
+
+// Publish every event that hasn't been, unless another process is. Events
+// are published at least once: if a process dies between publishing and
+// checkpointing, they're published again. Returns how many were published.
+func RelayUserEvents(db *pg.DB, publisher broker.Publisher) (int, error) {
+	// The lock belongs to the connection that takes it, so it's taken and
+	// released on one set aside for the relay
+	conn := db.Conn()
+	defer conn.Close()
+	var locked bool
+	if _, err := conn.QueryOne(pg.Scan(&locked), "SELECT pg_try_advisory_lock(?)", userEventRelayLockKey); err != nil || !locked {
+		return 0, err
+	}
+	defer conn.Exec("SELECT pg_advisory_unlock(?)", userEventRelayLockKey)
+
+	var organizationList []models.Organization
+	if err := db.Model(&organizationList).Select(); err != nil {
+		return 0, err
+	}
+	organizations := make(map[uint]*models.Organization)
+	for i := range organizationList {
+		organizations[organizationList[i].Id] = &organizationList[i]
+	}
+
+	published := 0
+	for {
+		n, err := relayUserEventBatch(db, publisher, organizations)
+		published += n
+		if err != nil || n < userEventRelayBatchSize {
+			return published, err
+		}
+	}
+}
+
+// Publish events as they're recorded, forever. Every API process runs this,
+// and whichever takes the lock publishes them.
+func RunUserEventRelay(db *pg.DB, publisher broker.Publisher) {
+	ticker := time.NewTicker(viper.GetDuration("events_relay_interval"))
+	defer ticker.Stop()
+
+	for {
+		if _, err := RelayUserEvents(db, publisher); err != nil {
+			log.Printf("Publishing user events failed: %s", err)
+		}
+		<-ticker.C
+	}
+}
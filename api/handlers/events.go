@@ -0,0 +1,308 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/publicid"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+// The advisory lock, with the tenant's id, held from recording an event until
+// the transaction ends. Events are recorded one transaction at a time per
+// organization, so their sequences are in the order they're committed, and
+// a consumer that has seen an event has seen every event before it.
+const userEventLockKey = 49049
+
+// The channel notified, with the tenant's id, when events are committed
+const userEventChannel = "user_events"
+
+func setUserEventDefaults() {
+	// How often waiting requests look for events, in case a notification was
+	// missed
+	viper.SetDefault("events_poll_interval", "5s")
+	// How often an idle stream sends a comment, so proxies keep it open
+	viper.SetDefault("events_keepalive_interval", "15s")
+	// How long events are kept for consumers to catch up
+	viper.SetDefault("events_retention", "720h")
+	// How often events older than that are deleted
+	viper.SetDefault("events_prune_interval", "1h")
+}
+
+// Record the change to the user, and queue it for the tenant's webhooks. Run
+// it last in the transaction that makes the change, so the event is recorded
+// if and only if the change is made.
+func recordUserEvent(db orm.DB, eventType string, userAccount *models.UserAccount) error {
+	if _, err := db.Exec("SELECT pg_advisory_xact_lock(?, ?tenant_id)", userEventLockKey); err != nil {
+		return err
+	}
+
+	eventId, err := publicid.New()
+	if err != nil {
+		return err
+	}
+	// Consumers don't get private attributes, whoever made the change
+	userOutgoing := newUserOutgoing(userAccount)
+	removePrivateAttributes(db, userOutgoing)
+	event := &models.UserEvent{
+		Id:     eventId,
+		Type:   eventType,
+		UserId: userAccount.Id,
+		Data:   userOutgoing,
+	}
+	if _, err := db.Model(event).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		return err
+	}
+	// Sent when the transaction commits
+	if _, err := db.Exec("SELECT pg_notify(?, ?tenant_id::text)", userEventChannel); err != nil {
+		return err
+	}
+	return enqueueWebhookEvent(db, event)
+}
+
+// Scrub the personal data from the user's events, and from the webhook
+// deliveries of them, leaving only who they were about. Erasing a user does
+// this, so their old details aren't kept in the outbox.
+func scrubUserEvents(db orm.DB, userId uint) error {
+	_, err := db.Exec(`
+		UPDATE user_events SET data = jsonb_build_object('id', user_id, 'public_id', data->'public_id')
+		WHERE user_id = ? AND `+inTenant, userId)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE webhook_deliveries SET payload = jsonb_set(payload, '{data}', user_events.data)
+		FROM user_events
+		WHERE webhook_deliveries.event_id = user_events.id AND webhook_deliveries.tenant_id = ?tenant_id
+			AND user_events.user_id = ? AND user_events.tenant_id = ?tenant_id`, userId)
+	return err
+}
+
+// Wakes the requests waiting for an organization's events when some are
+// committed. Each process listens on one connection.
+type userEventHub struct {
+	once    sync.Once
+	mu      sync.Mutex
+	waiting map[uint]map[chan struct{}]bool
+}
+
+var userEvents = &userEventHub{waiting: make(map[uint]map[chan struct{}]bool)}
+
+func (hub *userEventHub) listen(db *pg.DB) {
+	for notification := range db.Listen(userEventChannel).Channel() {
+		tenantId, err := strconv.ParseUint(notification.Payload, 10, 64)
+		if err != nil {
+			continue
+		}
+		hub.mu.Lock()
+		for wake := range hub.waiting[uint(tenantId)] {
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+		hub.mu.Unlock()
+	}
+}
+
+// A channel that receives when the tenant's events may have been committed.
+// Subscribe before looking for events, so none committed in between are
+// missed.
+func (hub *userEventHub) subscribe(db *pg.DB, tenantId uint) chan struct{} {
+	hub.once.Do(func() { go hub.listen(db) })
+
+	wake := make(chan struct{}, 1)
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.waiting[tenantId] == nil {
+		hub.waiting[tenantId] = make(map[chan struct{}]bool)
+	}
+	hub.waiting[tenantId][wake] = true
+	return wake
+}
+
+func (hub *userEventHub) unsubscribe(tenantId uint, wake chan struct{}) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	delete(hub.waiting[tenantId], wake)
+	if len(hub.waiting[tenantId]) == 0 {
+		delete(hub.waiting, tenantId)
+	}
+}
+
+// Up to limit of the tenant's events after the sequence, in order
+func userEventsAfter(db orm.DB, after int64, limit int) ([]models.UserEvent, error) {
+	events := []models.UserEvent{}
+	err := db.Model(&events).
+		Where(inTenant).
+		Where("sequence > ?", after).
+		Order("sequence").
+		Limit(limit).
+		Select()
+	return events, err
+}
+
+// Delete events older than the retention, returning how many were
+func PruneUserEvents(db *pg.DB) (int, error) {
+	res, err := db.Model((*models.UserEvent)(nil)).
+		Where("created_at < now() - make_interval(secs => ?)", viper.GetDuration("events_retention").Seconds()).
+		Delete()
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected(), nil
+}
+
+// Prune events every interval, forever. Every API process runs this, and
+// deleting the same events twice is harmless.
+func RunUserEventPruner(db *pg.DB) {
+	ticker := time.NewTicker(viper.GetDuration("events_prune_interval"))
+	defer ticker.Stop()
+
+	for {
+		if _, err := PruneUserEvents(db); err != nil {
+			log.Printf("Pruning user events failed: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+// @Summary Retrieve the user events after a sequence, waiting for some if there are none yet
+// @Produce  json
+// @Param   after	query	int	false  "The sequence of the last event handled. default: 0, the oldest kept"
+// @Param   limit	query	int	false  "default: 100, at most 1000"
+// @Param   wait	query	int	false  "Seconds to wait for an event, at most 60. default: 0"
+// @Success 200 {object} models.UserEventPage "The events, oldest first, and the sequence to retrieve events after next"
+// @Router /events [get]
+func RetrieveUserEvents(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	var options models.UserEventsOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if options.Limit == 0 {
+		options.Limit = 100
+	}
+
+	tenantId := tenant.Current(c).Id
+	wake := userEvents.subscribe(db, tenantId)
+	defer userEvents.unsubscribe(tenantId, wake)
+	deadline := time.NewTimer(time.Duration(options.Wait) * time.Second)
+	defer deadline.Stop()
+	poll := time.NewTicker(viper.GetDuration("events_poll_interval"))
+	defer poll.Stop()
+
+	waited := options.Wait == 0
+	for {
+		events, err := userEventsAfter(db, options.After, options.Limit)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		if len(events) > 0 || waited {
+			page := models.UserEventPage{Data: events, Next: options.After}
+			if len(events) > 0 {
+				page.Next = events[len(events)-1].Sequence
+			}
+			c.JSON(http.StatusOK, page)
+			return
+		}
+
+		select {
+		case <-wake:
+		case <-poll.C:
+		case <-deadline.C:
+			waited = true
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
+
+// @Summary Stream the user events after a sequence, and then as they happen, as server-sent events
+// @Produce  text/event-stream
+// @Param   after	query	int	false  "The sequence of the last event handled. default: 0, the oldest kept"
+// @Param   Last-Event-ID	header	string	false  "The id of the last event received, to resume a dropped stream. Overrides after."
+// @Success 200 {string} string "Each event's id is its sequence, its event its type, and its data the event as JSON"
+// @Router /events/stream [get]
+func StreamUserEvents(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	var options models.UserEventsOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if lastEventId := c.GetHeader("Last-Event-ID"); lastEventId != "" {
+		after, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || after < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "Last-Event-ID must be an event's sequence"})
+			return
+		}
+		options.After = after
+	}
+
+	tenantId := tenant.Current(c).Id
+	wake := userEvents.subscribe(db, tenantId)
+	defer userEvents.unsubscribe(tenantId, wake)
+	poll := time.NewTicker(viper.GetDuration("events_poll_interval"))
+	defer poll.Stop()
+	keepalive := time.NewTicker(viper.GetDuration("events_keepalive_interval"))
+	defer keepalive.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stops nginx buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	for {
+		// Send everything that's been committed, a page at a time
+		for {
+			events, err := userEventsAfter(db, options.After, 100)
+			if err != nil {
+				// The client reconnects, resuming after the last event sent
+				c.Error(err)
+				return
+			}
+			for _, event := range events {
+				data, err := json.Marshal(event)
+				if err != nil {
+					c.Error(err)
+					return
+				}
+				fmt.Fprintf(c.Writer, "id: %d\nevent: %s\ndata: %s\n\n", event.Sequence, event.Type, data)
+				options.After = event.Sequence
+			}
+			c.Writer.Flush()
+			if len(events) < 100 {
+				break
+			}
+		}
+
+		select {
+		case <-wake:
+		case <-poll.C:
+		case <-keepalive.C:
+			fmt.Fprint(c.Writer, ": keepalive\n\n")
+			c.Writer.Flush()
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/pii"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// The user_accounts columns that can be exported, in default order. The
+// password_hash is never exported.
+var exportableUserFields = []string{
+	"id",
+	"public_id",
+	"user_name",
+	"first_name",
+	"middle_name",
+	"last_name",
+	"email",
+	"primary_phone_number",
+	"country",
+	"status",
+	"created_at",
+	"updated_at",
+}
+
+// How many rows are fetched from the cursor at a time
+const exportFetchSize = 500
+
+type exportOptions struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv ndjson"`
+	Fields string `form:"fields"`
+}
+
+// The fields requested, in the order requested, or all exportable fields
+func exportFields(fieldsParam string) ([]string, error) {
+	if fieldsParam == "" {
+		return exportableUserFields, nil
+	}
+	var fields []string
+	for _, field := range strings.Split(fieldsParam, ",") {
+		field = strings.TrimSpace(field)
+		found := false
+		for _, exportable := range exportableUserFields {
+			found = found || field == exportable
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown field %s", field)
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+// Write a row, fetched as a JSON object, as a CSV record
+func writeCsvRow(writer *csv.Writer, fields []string, row string) error {
+	var values map[string]interface{}
+	decoder := json.NewDecoder(strings.NewReader(row))
+	decoder.UseNumber()
+	if err := decoder.Decode(&values); err != nil {
+		return err
+	}
+	record := make([]string, len(fields))
+	for i, field := range fields {
+		if values[field] != nil {
+			record[i] = fmt.Sprint(values[field])
+		}
+	}
+	return writer.Write(record)
+}
+
+// Decrypt the encrypted fields of a row, fetched as a JSON object, keeping
+// its fields in order
+func decryptExportRow(db orm.DB, fields []string, row string) (string, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(row), &values); err != nil {
+		return "", err
+	}
+	for _, field := range pii.Fields {
+		// Nulls stay null
+		var value string
+		if !pii.Encrypted(field) || len(values[field]) == 0 || values[field][0] != '"' || json.Unmarshal(values[field], &value) != nil {
+			continue
+		}
+		decrypted, err := pii.Decrypt(db, value)
+		if err != nil {
+			return "", err
+		}
+		if values[field], err = json.Marshal(decrypted); err != nil {
+			return "", err
+		}
+	}
+
+	var buffer bytes.Buffer
+	buffer.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buffer.WriteByte(',')
+		}
+		name, _ := json.Marshal(field)
+		buffer.Write(name)
+		buffer.WriteByte(':')
+		buffer.Write(values[field])
+	}
+	buffer.WriteByte('}')
+	return buffer.String(), nil
+}
+
+// @Summary Export users as CSV or NDJSON
+// @Produce  text/csv
+// @Produce  application/x-ndjson
+// @Param   format      query	string	false  "csv (default) or ndjson"
+// @Param   fields      query	string	false  "comma separated fields to export (default: all)"
+// @Param   user_name   query	string	false  "only users with this user_name"
+// @Param   first_name  query	string	false  "only users with this first_name"
+// @Param   last_name   query	string	false  "only users with this last_name"
+// @Param   email       query	string	false  "only users with this email"
+// @Param   status      query	string	false  "only users with this status: pending, active, suspended or disabled"
+// @Param   attr[name]  query	string	false  "only users with this value of the attribute, e.g. attr[department]=sales"
+// @Param   group       query	int	false  "only users in this group, directly or through a nested group"
+// @Success 200 {string} string "The users, one per line"
+// @Router /users/export [get]
+func ExportUsers(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var options exportOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	filter, ok := bindUserFilter(c, db)
+	if !ok {
+		return
+	}
+	fields, err := exportFields(options.Fields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// The cursor only lives as long as the transaction
+	tx, err := db.Begin()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	query := filterUserAccounts(tx.Model((*models.UserAccount)(nil)).Column(fields...), filter).Order("id")
+	if _, err := tx.Exec("DECLARE user_export NO SCROLL CURSOR FOR SELECT row_to_json(t)::text FROM (?) AS t", query); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	if options.Format == "ndjson" {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", "attachment; filename=users.ndjson")
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename=users.csv")
+	}
+	c.Status(http.StatusOK)
+
+	var buffer bytes.Buffer
+	csvWriter := csv.NewWriter(&buffer)
+	if options.Format != "ndjson" {
+		csvWriter.Write(fields)
+	}
+	for {
+		var rows []string
+		if _, err := tx.Query(&rows, fmt.Sprintf("FETCH %d FROM user_export", exportFetchSize)); err != nil {
+			// Too late to change the status, so just stop
+			c.Error(err)
+			return
+		}
+
+		for _, row := range rows {
+			if row, err = decryptExportRow(tx, fields, row); err != nil {
+				c.Error(err)
+				return
+			}
+			if options.Format == "ndjson" {
+				buffer.WriteString(row)
+				buffer.WriteByte('\n')
+			} else if err := writeCsvRow(csvWriter, fields, row); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+		csvWriter.Flush()
+
+		// Send each batch as soon as it's fetched
+		if _, err := c.Writer.Write(buffer.Bytes()); err != nil {
+			c.Error(err)
+			return
+		}
+		c.Writer.Flush()
+		buffer.Reset()
+
+		if len(rows) < exportFetchSize {
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg/orm"
+)
+
+// Get the filters shared by the endpoints that list users. Returns false,
+// after writing the error response, if they're invalid.
+func bindUserFilter(c *gin.Context, db orm.DB) (models.UserFilter, bool) {
+	var filter models.UserFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return filter, false
+	}
+	filter.Attributes = c.QueryMap("attr")
+
+	// Filtering by email is a lookup
+	if filter.Email != "" && !policy.Allowed(c, policy.UsersLookup) {
+		c.JSON(http.StatusForbidden, gin.H{"message": "Missing permission " + policy.UsersLookup})
+		return filter, false
+	}
+
+	// Filtering by a private attribute would reveal its values
+	if len(filter.Attributes) > 0 && !policy.Allowed(c, policy.AttributesPrivate) {
+		private, err := privateAttributeNames(db)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return filter, false
+		}
+		for name := range filter.Attributes {
+			if private[name] {
+				c.JSON(http.StatusForbidden, gin.H{"message": "attr[" + name + "] is private"})
+				return filter, false
+			}
+		}
+	}
+	return filter, true
+}
+
+// Restrict a user account query to the users of the organization matching
+// the filter
+func filterUserAccounts(query *orm.Query, filter models.UserFilter) *orm.Query {
+	query = query.Where(inTenant)
+	if filter.UserName != "" {
+		query = query.Where("user_name = ?", filter.UserName)
+	}
+	if filter.FirstName != "" {
+		query = query.Where("? = ?", piiMatch("first_name", filter.FirstName)...)
+	}
+	if filter.LastName != "" {
+		query = query.Where("? = ?", piiMatch("last_name", filter.LastName)...)
+	}
+	if filter.Email != "" {
+		query = query.Where("? = ?", piiMatch("email", filter.Email)...)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Group != 0 {
+		query = query.Where("id IN (SELECT user_id FROM group_members WHERE group_id IN ("+subgroupIdsQuery+"))", filter.Group)
+	}
+
+	names := make([]string, 0, len(filter.Attributes))
+	for name := range filter.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		query = filterAttribute(query, name, filter.Attributes[name])
+	}
+	return query
+}
+
+// Match an attribute value from the query string with containment, so the GIN
+// index on attributes is used. Values that look like numbers or booleans also
+// match as those.
+func filterAttribute(query *orm.Query, name string, value string) *orm.Query {
+	asString, _ := json.Marshal(map[string]interface{}{name: value})
+
+	var scalar interface{}
+	if err := json.Unmarshal([]byte(value), &scalar); err != nil {
+		return query.Where("attributes @> ?::jsonb", string(asString))
+	}
+	switch scalar.(type) {
+	case float64, bool:
+		asScalar, _ := json.Marshal(map[string]interface{}{name: scalar})
+		return query.WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			return q.WhereOr("attributes @> ?::jsonb", string(asString)).
+				WhereOr("attributes @> ?::jsonb", string(asScalar)), nil
+		})
+	default:
+		return query.Where("attributes @> ?::jsonb", string(asString))
+	}
+}
@@ -0,0 +1,439 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// The ids of a group and every group nested in it, at any depth
+const subgroupIdsQuery = `
+	WITH RECURSIVE subgroups AS (
+		SELECT id FROM groups WHERE id = ?
+		UNION SELECT groups.id FROM groups JOIN subgroups ON groups.parent_id = subgroups.id
+	) SELECT id FROM subgroups`
+
+// The ids of the groups a user is directly in, and every group those are
+// nested in, at any depth
+const userGroupIdsQuery = `
+	WITH RECURSIVE ancestors AS (
+		SELECT id, parent_id FROM groups WHERE id IN (SELECT group_id FROM group_members WHERE user_id = ?)
+		UNION SELECT groups.id, groups.parent_id FROM groups JOIN ancestors ON groups.id = ancestors.parent_id
+	) SELECT id FROM ancestors`
+
+// The status and message for a failed write of a group
+func groupWriteError(err error) (int, string) {
+	if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
+		return http.StatusBadRequest, "name already exists"
+	}
+	if strings.Contains(err.Error(), database.FK_ERROR_CODE) {
+		return http.StatusBadRequest, "parent_id does not exist"
+	}
+	return http.StatusServiceUnavailable, err.Error()
+}
+
+// Whether nesting the group in its parent would make it its own ancestor
+func groupParentMakesCycle(db orm.DB, group *models.Group) (bool, error) {
+	if group.ParentId == nil {
+		return false, nil
+	}
+	if *group.ParentId == group.Id {
+		return true, nil
+	}
+
+	// Concurrent moves could make a cycle between them
+	if _, err := db.Exec("SELECT pg_advisory_xact_lock(hashtext('groups.parent_id'))"); err != nil {
+		return false, err
+	}
+
+	var cycle bool
+	_, err := db.QueryOne(pg.Scan(&cycle), `
+		WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM groups WHERE id = ?
+			UNION SELECT groups.id, groups.parent_id FROM groups JOIN ancestors ON groups.id = ancestors.parent_id
+		) SELECT exists(SELECT 1 FROM ancestors WHERE id = ?)`,
+		*group.ParentId, group.Id)
+	return cycle, err
+}
+
+// Load a group. Returns false, after writing the error response, if there
+// isn't one.
+func retrieveGroup(c *gin.Context, db orm.DB, groupId uint) (*models.Group, bool) {
+	var group models.Group
+	group.Id = groupId
+	if err := db.Model(&group).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Group not found"})
+		return nil, false
+	}
+	return &group, true
+}
+
+// @Summary Retrieve all groups
+// @Produce  json
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Success 200 {array} models.Group "The groups"
+// @Router /groups [get]
+func RetrieveAllGroups(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	pagination, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+
+	groups := []models.Group{}
+	if err := db.Model(&groups).Where(inTenant).Order("id").Limit(pagination.PageSize).Offset(offset).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": groups, "pagination": pagination})
+}
+
+// @Summary Create a group
+// @Accept  json
+// @Produce  json
+// @Param   group body models.GroupBase true "The group to be created"
+// @Success 201 {object} models.Group
+// @Router /groups [post]
+func CreateGroup(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var group models.Group
+	if err := c.BindJSON(&group.GroupBase); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// A new group can't be anyone's ancestor, so can't make a cycle
+	if _, err := db.Model(&group).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		c.Error(err)
+		status, message := groupWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, group)
+}
+
+// @Summary Retrieve a group by id
+// @Produce  json
+// @Param   id path int true "The id of the group to be retrieved"
+// @Success 200 {object} models.Group "The group for that id"
+// @Router /groups/:id [get]
+func RetrieveGroup(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var groupId models.GroupID
+	if err := c.ShouldBindUri(&groupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	group, ok := retrieveGroup(c, db, groupId.Id)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// @Summary Update a group by id
+// @Accept  json
+// @Produce  json
+// @Param   id path int true "The id of the group to be updated"
+// @Param   group body models.GroupBase true "The group data to be updated"
+// @Success 200 {object} models.Group "The updated group"
+// @Failure 400 {string} nil "The group would be nested in itself"
+// @Router /groups/:id [put]
+func UpdateGroup(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var groupId models.GroupID
+	if err := c.ShouldBindUri(&groupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Get the request body
+	var group models.Group
+	if err := c.BindJSON(&group.GroupBase); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	// The URL ID overrides any model ID
+	group.GroupID = groupId
+
+	var status int
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		cycle, err := groupParentMakesCycle(tx, &group)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			status = http.StatusBadRequest
+			return errors.New("parent_id would nest the group in itself")
+		}
+
+		res, err := tx.Model(&group).
+			Column("name", "description", "parent_id", "updated_at").
+			Value("updated_at", "now()").
+			WherePK().
+			Where(inTenant).
+			Returning("*").
+			Update()
+		if err != nil {
+			return err
+		}
+		if res.RowsAffected() == 0 {
+			status = http.StatusNotFound
+			return errors.New("Group not found")
+		}
+		return nil
+	})
+	if err != nil {
+		c.Error(err)
+		message := err.Error()
+		if status == 0 {
+			status, message = groupWriteError(err)
+		}
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusOK, group)
+}
+
+// @Summary Delete a group by id. Its nested groups move up to its parent.
+// @Produce  json
+// @Param   id path int true "The id of the group to be deleted"
+// @Success 204 {string} nil
+// @Router /groups/:id [delete]
+func DeleteGroup(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var groupId models.GroupID
+	if err := c.ShouldBindUri(&groupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var found bool
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var group models.Group
+		group.GroupID = groupId
+		if err := tx.Model(&group).WherePK().Where(inTenant).For("UPDATE").Select(); err != nil {
+			if err == pg.ErrNoRows {
+				return nil
+			}
+			return err
+		}
+		found = true
+
+		_, err := tx.Model((*models.Group)(nil)).
+			Set("parent_id = ?", group.ParentId).
+			Where("parent_id = ?", group.Id).
+			Update()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Model(&group).WherePK().Delete()
+		return err
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Group not found"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Retrieve the users in a group
+// @Produce  json
+// @Param   id path int true "The id of the group"
+// @Param   nested      query	bool	false  "include the users in groups nested in it"
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Success 200 {array} models.UserOutgoing "The users in the group"
+// @Router /groups/:id/members [get]
+func RetrieveGroupMembers(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var groupId models.GroupID
+	if err := c.ShouldBindUri(&groupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	var options models.GroupsOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	pagination, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+	if _, ok := retrieveGroup(c, db, groupId.Id); !ok {
+		return
+	}
+
+	var userAccounts []models.UserAccount
+	query := db.Model(&userAccounts).Where(inTenant)
+	if options.Nested {
+		query = filterUserAccounts(query, models.UserFilter{Group: groupId.Id})
+	} else {
+		query = query.Where("id IN (SELECT user_id FROM group_members WHERE group_id = ?)", groupId.Id)
+	}
+	if err := query.Order("id").Limit(pagination.PageSize).Offset(offset).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	usersOutgoing := []models.UserOutgoing{}
+	for i := range userAccounts {
+		usersOutgoing = append(usersOutgoing, *newUserOutgoing(&userAccounts[i]))
+	}
+	for i := range usersOutgoing {
+		redactPrivateAttributes(c, db, &usersOutgoing[i])
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": usersOutgoing, "pagination": pagination})
+}
+
+// @Summary Add a user to a group
+// @Produce  json
+// @Param   id path int true "The id of the group"
+// @Param   user_id path string true "The public_id (or, for now, id) of the user"
+// @Success 204 {string} nil
+// @Router /groups/:id/members/:user_id [put]
+func AddGroupMember(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL params
+	var memberId models.GroupMemberID
+	if err := c.ShouldBindUri(&memberId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !bindUserParam(c, db, memberId.UserParam, &memberId.UserId) {
+		return
+	}
+
+	// Adding an existing member changes nothing. The foreign keys keep the
+	// group and the user in the organization.
+	member := &models.GroupMember{GroupId: memberId.Id, UserId: memberId.UserId}
+	if _, err := db.Model(member).Value("tenant_id", "?tenant_id").OnConflict("DO NOTHING").Insert(); err != nil {
+		c.Error(err)
+		if strings.Contains(err.Error(), database.FK_ERROR_CODE) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Group or User Account not found"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Remove a user from a group
+// @Produce  json
+// @Param   id path int true "The id of the group"
+// @Param   user_id path string true "The public_id (or, for now, id) of the user"
+// @Success 204 {string} nil
+// @Router /groups/:id/members/:user_id [delete]
+func RemoveGroupMember(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL params
+	var memberId models.GroupMemberID
+	if err := c.ShouldBindUri(&memberId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !bindUserParam(c, db, memberId.UserParam, &memberId.UserId) {
+		return
+	}
+
+	member := &models.GroupMember{GroupId: memberId.Id, UserId: memberId.UserId}
+	res, err := db.Model(member).WherePK().Where(inTenant).Delete()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account is not in the Group"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Retrieve the groups a user is in
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   nested      query	bool	false  "include the groups those groups are nested in"
+// @Success 200 {array} models.Group "The user's groups"
+// @Router /users/:id/groups [get]
+func RetrieveUserGroups(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+	var options models.GroupsOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if _, ok := retrieveContactUser(c, db, userId.Id); !ok {
+		return
+	}
+
+	groups := []models.Group{}
+	query := db.Model(&groups).Where(inTenant)
+	if options.Nested {
+		query = query.Where("id IN ("+userGroupIdsQuery+")", userId.Id)
+	} else {
+		query = query.Where("id IN (SELECT group_id FROM group_members WHERE user_id = ?)", userId.Id)
+	}
+	if err := query.Order("id").Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": groups})
+}
@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+// The models.UserIncoming fields that can be imported
+var importableUserFields = map[string]bool{
+	"user_name":            true,
+	"password":             true,
+	"first_name":           true,
+	"middle_name":          true,
+	"last_name":            true,
+	"email":                true,
+	"primary_phone_number": true,
+	"country":              true,
+	"phone_region":         true,
+}
+
+// How many rows are validated, hashed and written at a time
+const importChunkSize = 100
+
+func setImportDefaults() {
+	// How many row errors a report keeps
+	viper.SetDefault("import_max_errors", 1000)
+	// Files larger than this are imported in the background
+	viper.SetDefault("import_async_threshold", 1024*1024)
+}
+
+// A source of import rows, keyed by column name
+type importRecordReader interface {
+	Read() (map[string]string, error)
+}
+
+// A malformed row, which is reported without stopping the import
+type importRowError struct {
+	error
+}
+
+type csvRecordReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func (r *csvRecordReader) Read() (map[string]string, error) {
+	if r.header == nil {
+		header, err := r.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.header = header
+	}
+	values, err := r.reader.Read()
+	if _, ok := err.(*csv.ParseError); ok {
+		return nil, importRowError{err}
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := make(map[string]string)
+	for i, column := range r.header {
+		if i < len(values) {
+			record[strings.TrimSpace(column)] = values[i]
+		}
+	}
+	return record, nil
+}
+
+type ndjsonRecordReader struct {
+	scanner *bufio.Scanner
+}
+
+func (r *ndjsonRecordReader) Read() (map[string]string, error) {
+	for r.scanner.Scan() {
+		line := strings.TrimSpace(r.scanner.Text())
+		if line == "" {
+			continue
+		}
+		var values map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &values); err != nil {
+			return nil, importRowError{err}
+		}
+		record := make(map[string]string)
+		for column, value := range values {
+			if value != nil {
+				record[column] = fmt.Sprint(value)
+			}
+		}
+		return record, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func newImportRecordReader(reader io.Reader, format string) importRecordReader {
+	if format == "ndjson" {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		return &ndjsonRecordReader{scanner: scanner}
+	}
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.TrimLeadingSpace = true
+	return &csvRecordReader{reader: csvReader}
+}
+
+// Check every column in the mapping targets an importable field
+func validateImportMapping(mapping map[string]string) error {
+	for column, field := range mapping {
+		if !importableUserFields[field] {
+			return fmt.Errorf("column %s is mapped to unknown field %s", column, field)
+		}
+	}
+	return nil
+}
+
+// Map a row to an incoming user. Columns are mapped to fields by name, unless
+// the mapping says otherwise.
+func importRecordToUserIncoming(record map[string]string, mapping map[string]string) (models.UserIncoming, error) {
+	fields := make(map[string]string)
+	for column, value := range record {
+		field, ok := mapping[column]
+		if !ok {
+			field = column
+		}
+		if importableUserFields[field] {
+			fields[field] = value
+		}
+	}
+
+	var userIncoming models.UserIncoming
+	jsonData, _ := json.Marshal(fields)
+	err := json.Unmarshal(jsonData, &userIncoming)
+	return userIncoming, err
+}
+
+func addImportError(job *models.ImportJob, row int, err error) {
+	job.Failed++
+	if len(job.Errors) < viper.GetInt("import_max_errors") {
+		job.Errors = append(job.Errors, models.ImportError{Row: row, Message: err.Error()})
+	}
+}
+
+// Validate, normalize and (unless it's a dry run) create a chunk of users
+func importUserChunk(db *pg.DB, job *models.ImportJob, rows []int, usersIncoming []models.UserIncoming, seen map[string]bool) {
+	userAccounts, errs := normalizeIncomingUserAccounts(usersIncoming)
+
+	// In a dry run, users that already exist are reported rather than inserted
+	existing := make(map[string]bool)
+	if job.DryRun {
+		var userNames []string
+		for _, userIncoming := range usersIncoming {
+			userNames = append(userNames, userIncoming.UserName)
+		}
+		var existingUserNames []string
+		db.Model((*models.UserAccount)(nil)).
+			Column("user_name").
+			WhereIn("user_name IN (?)", userNames).
+			Where(inTenant).
+			Select(&existingUserNames)
+		for _, userName := range existingUserNames {
+			existing[userName] = true
+		}
+	}
+
+	for i, userAccount := range userAccounts {
+		job.Processed++
+		if errs[i] != nil {
+			addImportError(job, rows[i], errs[i])
+			continue
+		}
+		if seen[userAccount.UserName] || existing[userAccount.UserName] {
+			addImportError(job, rows[i], errors.New("user_name already exists"))
+			continue
+		}
+		seen[userAccount.UserName] = true
+
+		if job.DryRun {
+			job.Created++
+			continue
+		}
+		err := db.RunInTransaction(func(tx *pg.Tx) error {
+			if err := insertUserAccount(tx, userAccount); err != nil {
+				return err
+			}
+			return recordUserEvent(tx, models.UserEventCreated, userAccount)
+		})
+		if err != nil {
+			_, message := userWriteError(err)
+			addImportError(job, rows[i], errors.New(message))
+			continue
+		}
+		job.Created++
+	}
+}
+
+// ImportUsers streams users from CSV or NDJSON, creating the valid ones and
+// recording errors for the rest in the job. progress is called after every
+// chunk of rows.
+func ImportUsers(db *pg.DB, reader io.Reader, mapping map[string]string, job *models.ImportJob, progress func(*models.ImportJob)) error {
+	if err := validateImportMapping(mapping); err != nil {
+		return err
+	}
+	recordReader := newImportRecordReader(reader, job.Format)
+	seen := make(map[string]bool)
+
+	var rows []int
+	var usersIncoming []models.UserIncoming
+	for row := 1; ; row++ {
+		record, err := recordReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(importRowError); !ok {
+				return err
+			}
+			job.Processed++
+			addImportError(job, row, err)
+			continue
+		}
+
+		userIncoming, err := importRecordToUserIncoming(record, mapping)
+		if err != nil {
+			job.Processed++
+			addImportError(job, row, err)
+			continue
+		}
+		rows = append(rows, row)
+		usersIncoming = append(usersIncoming, userIncoming)
+
+		if len(usersIncoming) == importChunkSize {
+			importUserChunk(db, job, rows, usersIncoming, seen)
+			rows, usersIncoming = nil, nil
+			if progress != nil {
+				progress(job)
+			}
+		}
+	}
+	if len(usersIncoming) > 0 {
+		importUserChunk(db, job, rows, usersIncoming, seen)
+	}
+	if progress != nil {
+		progress(job)
+	}
+	return nil
+}
+
+// The import format from the query, or the content type
+func importFormat(c *gin.Context, options models.ImportOptions) string {
+	if options.Format != "" {
+		return options.Format
+	}
+	if strings.Contains(c.ContentType(), "json") {
+		return "ndjson"
+	}
+	return "csv"
+}
+
+// Run an import in the background, from a copy of the upload
+func startImportJob(db *pg.DB, upload *os.File, mapping map[string]string, job *models.ImportJob) {
+	saveProgress := func(job *models.ImportJob) {
+		db.Model(job).Column("status", "processed", "created", "failed", "errors", "message", "finished_at").WherePK().Update()
+	}
+
+	go func() {
+		defer os.Remove(upload.Name())
+		defer upload.Close()
+
+		err := ImportUsers(db, upload, mapping, job, saveProgress)
+		finishedAt := time.Now()
+		job.FinishedAt = &finishedAt
+		job.Status = models.ImportStatusCompleted
+		if err != nil {
+			job.Status = models.ImportStatusFailed
+			job.Message = err.Error()
+		}
+		saveProgress(job)
+	}()
+}
+
+// @Summary Import users from CSV or NDJSON
+// @Accept  text/csv
+// @Accept  application/x-ndjson
+// @Produce  json
+// @Param   format      query	string	false  "csv or ndjson (default: from the content type)"
+// @Param   dry_run     query	bool	false  "validate and report without creating users"
+// @Param   async       query	bool	false  "import as a background job (default: for large files)"
+// @Param   map[column] query	string	false  "map a column to a user field, e.g. map[Login]=user_name"
+// @Success 200 {object} models.ImportJob "The import report"
+// @Success 202 {object} models.ImportJob "The background import job"
+// @Router /users/import [post]
+func ImportUsersUpload(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var options models.ImportOptions
+	if err := c.ShouldBindQuery(&options); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	mapping := c.QueryMap("map")
+	if err := validateImportMapping(mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	job := &models.ImportJob{
+		Status:    models.ImportStatusRunning,
+		Format:    importFormat(c, options),
+		DryRun:    options.DryRun,
+		Errors:    []models.ImportError{},
+		CreatedAt: time.Now(),
+	}
+
+	async := options.Async ||
+		c.Request.ContentLength < 0 ||
+		c.Request.ContentLength > viper.GetInt64("import_async_threshold")
+	if !async {
+		if err := ImportUsers(db, c.Request.Body, mapping, job, nil); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		job.Status = models.ImportStatusCompleted
+		c.JSON(http.StatusOK, job)
+		return
+	}
+
+	// The request body is gone once we respond, so keep a copy for the job
+	upload, err := ioutil.TempFile("", "user-import-")
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if _, err := io.Copy(upload, c.Request.Body); err != nil {
+		upload.Close()
+		os.Remove(upload.Name())
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	upload.Seek(0, io.SeekStart)
+
+	if _, err := db.Model(job).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		upload.Close()
+		os.Remove(upload.Name())
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	startImportJob(db, upload, mapping, job)
+
+	c.Header("Location", fmt.Sprintf("/users/import/%d", job.Id))
+	c.JSON(http.StatusAccepted, job)
+}
+
+// @Summary Retrieve the progress of a background import
+// @Produce  json
+// @Param   id path int true "The id of the import job"
+// @Success 200 {object} models.ImportJob "The import job"
+// @Router /users/import/:id [get]
+func RetrieveImportJob(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var jobId models.ImportJobID
+	if err := c.ShouldBindUri(&jobId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	job := models.ImportJob{Id: jobId.Id}
+	if err := db.Model(&job).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Import job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
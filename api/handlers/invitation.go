@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setInvitationDefaults() {
+	// How long an invitation can be accepted for, from when it was last sent
+	viper.SetDefault("invitation_ttl", "168h")
+	// How soon an invitation can be sent again
+	viper.SetDefault("invitation_resend_interval", "1m")
+	// The link in the invitation, e.g.
+	// https://{tenant}.example.com/invitations/{token}. Without one, the
+	// invitation just has the token.
+	viper.SetDefault("invitation_url", "")
+	// The key invitation tokens are signed with
+	viper.SetDefault("invitation_signing_key", "")
+}
+
+var (
+	generatedSigningKey    []byte
+	generateSigningKeyOnce sync.Once
+)
+
+// The key invitation tokens are signed with. Without invitation_signing_key a
+// random one is used, so tokens don't outlive the process.
+func invitationSigningKey() []byte {
+	if key := viper.GetString("invitation_signing_key"); key != "" {
+		return []byte(key)
+	}
+	generateSigningKeyOnce.Do(func() {
+		generatedSigningKey = make([]byte, 32)
+		if _, err := rand.Read(generatedSigningKey); err != nil {
+			panic(err)
+		}
+		log.Print("invitation_signing_key is not set, so invitations won't survive a restart")
+	})
+	return generatedSigningKey
+}
+
+func signInvitation(payload string) string {
+	mac := hmac.New(sha256.New, invitationSigningKey())
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// A new token for the invitation, as its id, expiry and a random secret,
+// signed. Returns the token and the hash of the secret to store.
+func newInvitationToken(invitation *models.Invitation) (string, string, error) {
+	secret, secretHash, err := auth.NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	payload := fmt.Sprintf("%d.%d.%s", invitation.Id, invitation.ExpiresAt.Unix(), secret)
+	return payload + "." + signInvitation(payload), secretHash, nil
+}
+
+// The invitation id, expiry and secret hash of a token, if it's signed
+// correctly
+func parseInvitationToken(token string) (uint, time.Time, string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return 0, time.Time{}, "", false
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(signInvitation(payload))) {
+		return 0, time.Time{}, "", false
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, time.Time{}, "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, time.Time{}, "", false
+	}
+	return uint(id), time.Unix(expires, 0), auth.HashToken(parts[2]), true
+}
+
+// Give the invitation a new token and expiry, and mail it to the invitee
+func sendInvitation(db orm.DB, mailer mail.Mailer, organization *models.Organization, invitation *models.Invitation) error {
+	invitation.SentAt = time.Now()
+	invitation.ExpiresAt = invitation.SentAt.Add(viper.GetDuration("invitation_ttl"))
+	token, secretHash, err := newInvitationToken(invitation)
+	if err != nil {
+		return err
+	}
+	invitation.SecretHash = secretHash
+
+	_, err = db.Model(invitation).
+		Column("secret_hash", "sent_at", "expires_at").
+		WherePK().
+		Where(inTenant).
+		Update()
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("You have been invited to %s.\n\n", organization.Name)
+	if url := viper.GetString("invitation_url"); url != "" {
+		url = strings.NewReplacer("{token}", token, "{tenant}", organization.Slug).Replace(url)
+		body += fmt.Sprintf("Accept the invitation at %s before %s.\n", url, invitation.ExpiresAt.Format(time.RFC1123))
+	} else {
+		body += fmt.Sprintf("Accept the invitation before %s with the token:\n%s\n", invitation.ExpiresAt.Format(time.RFC1123), token)
+	}
+	return mailer.Send(invitation.Email, "You're invited to "+organization.Name, body)
+}
+
+// Get the invitation the URL names. Returns false, after writing the error
+// response, if there isn't one.
+func retrieveInvitation(c *gin.Context, db *pg.DB) (*models.Invitation, bool) {
+	// Get URL param
+	var invitationId models.InvitationID
+	if err := c.ShouldBindUri(&invitationId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return nil, false
+	}
+
+	var invitation models.Invitation
+	invitation.InvitationID = invitationId
+	if err := db.Model(&invitation).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invitation not found"})
+		return nil, false
+	}
+	return &invitation, true
+}
+
+// @Summary Retrieve the outstanding invitations
+// @Produce  json
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Success 200 {array} models.Invitation "The invitations, including expired ones"
+// @Router /invitations [get]
+func RetrieveAllInvitations(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get pagination
+	paginationIncoming, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+
+	invitations := []models.Invitation{}
+	err := db.Model(&invitations).
+		Where(inTenant).
+		Order("id").
+		Limit(paginationIncoming.PageSize).
+		Offset(offset).
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": invitations, "pagination": paginationIncoming})
+}
+
+// @Summary Invite someone by email, creating a pending user they complete by accepting
+// @Accept  json
+// @Produce  json
+// @Param   invitation body models.InvitationIncoming true "Who to invite"
+// @Success 201 {object} models.Invitation "The invitation, which was mailed with its token"
+// @Failure 409 {string} nil "The email already has a user, or an outstanding invitation"
+// @Router /invitations [post]
+func CreateInvitation(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	mailer := c.MustGet("Mailer").(mail.Mailer)
+	// Get the request body
+	var invitationIncoming models.InvitationIncoming
+	if err := c.BindJSON(&invitationIncoming); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// The user has no user_name or password until they accept
+	userAccount := &models.UserAccount{
+		UserBase: models.UserBase{
+			FirstName:  invitationIncoming.FirstName,
+			MiddleName: invitationIncoming.MiddleName,
+			LastName:   invitationIncoming.LastName,
+			Email:      invitationIncoming.Email,
+			Attributes: map[string]interface{}{},
+		},
+		Status: models.UserStatusPending,
+	}
+	invitation := &models.Invitation{Email: invitationIncoming.Email}
+	if caller := auth.Caller(c); caller != nil {
+		invitation.InvitedBy = &caller.Id
+	}
+
+	// Nothing is kept if the invitation can't be sent
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := insertUserAccount(tx, userAccount); err != nil {
+			return err
+		}
+		invitation.UserId = userAccount.Id
+		invitation.SentAt = time.Now()
+		invitation.ExpiresAt = invitation.SentAt
+		_, err := tx.Model(invitation).
+			Value("tenant_id", "?tenant_id").
+			Value("secret_hash", "''").
+			Insert()
+		if err != nil {
+			return err
+		}
+		if err := sendInvitation(tx, mailer, tenant.Current(c), invitation); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventCreated, userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
+			c.JSON(http.StatusConflict, gin.H{"message": "email already has a user or an outstanding invitation"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, invitation)
+}
+
+// @Summary Send an invitation again, with a new token and expiry
+// @Produce  json
+// @Param   id path int true "The id of the invitation"
+// @Success 200 {object} models.Invitation "The invitation, which was mailed with its new token"
+// @Failure 429 {string} nil "The invitation was sent too recently"
+// @Router /invitations/:id/resend [post]
+func ResendInvitation(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	mailer := c.MustGet("Mailer").(mail.Mailer)
+	invitation, ok := retrieveInvitation(c, db)
+	if !ok {
+		return
+	}
+
+	// Don't let clients flood the invitee with mail
+	resendAt := invitation.SentAt.Add(viper.GetDuration("invitation_resend_interval"))
+	if time.Now().Before(resendAt) {
+		c.Header("Retry-After", fmt.Sprintf("%.0f", time.Until(resendAt).Seconds()+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"message": "The invitation was sent too recently"})
+		return
+	}
+
+	// Earlier tokens stop working only if the new one is sent
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		return sendInvitation(tx, mailer, tenant.Current(c), invitation)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, invitation)
+}
+
+// @Summary Revoke an invitation, deleting its user if they're still pending
+// @Produce  json
+// @Param   id path int true "The id of the invitation"
+// @Success 204 {string} nil
+// @Router /invitations/:id [delete]
+func RevokeInvitation(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	invitation, ok := retrieveInvitation(c, db)
+	if !ok {
+		return
+	}
+
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		_, err := tx.Model(invitation).WherePK().Where(inTenant).Delete()
+		if err != nil {
+			return err
+		}
+		var userAccount models.UserAccount
+		res, err := tx.Model(&userAccount).
+			Where("id = ?", invitation.UserId).
+			Where(inTenant).
+			Where("status = ?", models.UserStatusPending).
+			Returning("*").
+			Delete()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventDeleted, &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Accept an invitation, choosing a user_name and password, which makes the user active
+// @Accept  json
+// @Produce  json
+// @Param   token path string true "The token from the invitation"
+// @Param   acceptance body models.InvitationAcceptance true "The user_name and password chosen"
+// @Success 200 {object} models.UserOutgoing "The now active user, who can sign in"
+// @Failure 404 {string} nil "The token is invalid, or the invitation was revoked or sent again"
+// @Failure 410 {string} nil "The invitation has expired"
+// @Router /invitations/:token/accept [post]
+func AcceptInvitation(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var invitationToken models.InvitationToken
+	if err := c.ShouldBindUri(&invitationToken); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Get the request body
+	var acceptance models.InvitationAcceptance
+	if err := c.BindJSON(&acceptance); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	id, expiresAt, secretHash, ok := parseInvitationToken(invitationToken.Token)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invitation not found"})
+		return
+	}
+	if time.Now().After(expiresAt) {
+		c.JSON(http.StatusGone, gin.H{"message": "Invitation has expired"})
+		return
+	}
+
+	var invitation models.Invitation
+	invitation.Id = id
+	err := db.Model(&invitation).WherePK().Where(inTenant).Select()
+	if err != nil || !hmac.Equal([]byte(invitation.SecretHash), []byte(secretHash)) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Invitation not found"})
+		return
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(acceptance.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "error hasing password"})
+		return
+	}
+
+	// The invitation is used up by activating the user, whose email is
+	// verified by their having the token
+	var userAccount models.UserAccount
+	userAccount.Id = invitation.UserId
+	var activated bool
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := checkUserNameAvailable(tx, userAccount.Id, acceptance.UserName); err != nil {
+			return err
+		}
+		res, err := tx.Model(&userAccount).
+			Set("user_name = ?, password_hash = ?, status = ?, status_reason = ?, status_changed_at = now(), email_verified_at = now(), version = version + 1",
+				acceptance.UserName, string(passwordHash), models.UserStatusActive, "invitation accepted").
+			WherePK().
+			Where(inTenant).
+			Where("status = ?", models.UserStatusPending).
+			Returning("*").
+			Update()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		activated = true
+		if _, err := tx.Model(&invitation).WherePK().Delete(); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+	if !activated {
+		c.JSON(http.StatusConflict, gin.H{"message": "User Account is no longer pending"})
+		return
+	}
+
+	c.Header("ETag", userETag(&userAccount))
+
+	userOutgoing := newUserOutgoing(&userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.JSON(http.StatusOK, userOutgoing)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/nyaruka/phonenumbers"
+)
+
+// Respond with the fields of the user asked for, or 304 if the client has it
+// cached
+func respondWithUser(c *gin.Context, db *pg.DB, projection *userProjection, userAccount *models.UserAccount) {
+	etag := userETag(userAccount)
+	c.Header("ETag", etag)
+	if notModified(c, etag) {
+		return
+	}
+
+	userOutgoing, err := projection.render(c, db, []models.UserAccount{*userAccount})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, userOutgoing[0])
+}
+
+// Respond with the one user whose field matches, which is a conflict if more
+// than one does
+func lookUpUser(c *gin.Context, db *pg.DB, field string, condition string, params ...interface{}) {
+	projection, ok := bindUserProjection(c)
+	if !ok {
+		return
+	}
+
+	var userAccounts []models.UserAccount
+	err := projection.selectColumns(db.Model(&userAccounts)).
+		Where(condition, params...).
+		Where(inTenant).
+		Order("id").
+		Limit(2).
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if len(userAccounts) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return
+	}
+	if len(userAccounts) > 1 {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("More than one User Account has that %s", field)})
+		return
+	}
+
+	respondWithUser(c, db, projection, &userAccounts[0])
+}
+
+// @Summary Retrieve a user by user_name, in any case. An old user_name redirects to the user who had it.
+// @Produce  json
+// @Param   user_name path string true "The user_name of the user to be retrieved"
+// @Param   fields query string false "only these fields of the user, comma separated"
+// @Param   If-None-Match header string false "ETag of a cached representation"
+// @Success 200 {object} models.UserOutgoing "The user with that user_name"
+// @Success 301 {string} nil "The user_name is an old one, and Location is its user"
+// @Failure 401 {string} nil "Lookups aren't open to anonymous callers"
+// @Router /users/by-username/:user_name [get]
+func RetrieveUserByUserName(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userName models.UserNameURI
+	if err := c.ShouldBindUri(&userName); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	projection, ok := bindUserProjection(c)
+	if !ok {
+		return
+	}
+
+	var userAccount models.UserAccount
+	err := projection.selectColumns(db.Model(&userAccount)).Where("lower(user_name) = lower(?)", userName.UserName).Where(inTenant).Select()
+	if err == pg.ErrNoRows {
+		// The latest user to have had the name
+		var publicId string
+		_, err = db.QueryOne(pg.Scan(&publicId), `
+			SELECT public_id FROM user_accounts
+			WHERE id = (
+				SELECT user_id FROM user_name_changes
+				WHERE lower(user_name) = lower(?) AND `+inTenant+`
+				ORDER BY changed_at DESC, id DESC
+				LIMIT 1
+			)`, userName.UserName)
+		if err == nil {
+			location := "/users/" + publicId
+			if c.Request.URL.RawQuery != "" {
+				location += "?" + c.Request.URL.RawQuery
+			}
+			c.Redirect(http.StatusMovedPermanently, location)
+			return
+		}
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return
+	}
+
+	respondWithUser(c, db, projection, &userAccount)
+}
+
+// @Summary Retrieve a user by primary email, which is matched case insensitively
+// @Produce  json
+// @Param   email path string true "The email of the user to be retrieved"
+// @Param   fields query string false "only these fields of the user, comma separated"
+// @Param   If-None-Match header string false "ETag of a cached representation"
+// @Success 200 {object} models.UserOutgoing "The user with that email"
+// @Failure 401 {string} nil "Lookups aren't open to anonymous callers"
+// @Router /users/by-email/:email [get]
+func RetrieveUserByEmail(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var email models.UserEmailURI
+	if err := c.ShouldBindUri(&email); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	condition, params := emailMatch(email.Email)
+	lookUpUser(c, db, "email", condition, params...)
+}
+
+// @Summary Retrieve a user by primary phone number, in any format that parses to it
+// @Produce  json
+// @Param   phone path string true "The phone number of the user to be retrieved"
+// @Param   region query string false "The region to parse the phone number in, if it has no country code"
+// @Param   fields query string false "only these fields of the user, comma separated"
+// @Param   If-None-Match header string false "ETag of a cached representation"
+// @Success 200 {object} models.UserOutgoing "The user with that phone number"
+// @Failure 401 {string} nil "Lookups aren't open to anonymous callers"
+// @Failure 409 {string} nil "More than one user has that phone number"
+// @Router /users/by-phone/:phone [get]
+func RetrieveUserByPhone(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var phone models.UserPhoneURI
+	if err := c.ShouldBindUri(&phone); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	var lookup models.UserPhoneLookup
+	if err := c.ShouldBindQuery(&lookup); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Numbers are stored canonically
+	phoneNumber, err := parsePhoneNumber("phone", phone.Phone, phoneRegion(lookup.Region))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	lookUpUser(c, db, "phone number", "? = ?", piiMatch("primary_phone_number", phonenumbers.Format(phoneNumber, phonenumbers.E164))...)
+}
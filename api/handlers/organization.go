@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+// Limits a query to the rows of the request's organization, which the tenant
+// middleware binds to ?tenant_id
+const inTenant = "tenant_id = ?tenant_id"
+
+// The ids of the users in the request's organization
+const tenantUserIdsQuery = "SELECT id FROM user_accounts WHERE " + inTenant
+
+// Slugs are used as subdomains
+var slugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// Get an organization from the request body, checking its slug
+func bindOrganization(c *gin.Context, organization *models.Organization) bool {
+	if err := c.BindJSON(&organization.OrganizationBase); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	if !slugPattern.MatchString(organization.Slug) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "slug must be lowercase letters, digits and hyphens"})
+		return false
+	}
+	return true
+}
+
+// The status and message for a failed write of an organization
+func organizationWriteError(err error) (int, string) {
+	if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
+		return http.StatusBadRequest, "slug already exists"
+	}
+	return http.StatusServiceUnavailable, err.Error()
+}
+
+// @Summary Retrieve all organizations
+// @Produce  json
+// @Success 200 {array} models.Organization "The organizations"
+// @Router /organizations [get]
+func RetrieveAllOrganizations(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	organizations := []models.Organization{}
+	if err := db.Model(&organizations).Order("id").Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": organizations})
+}
+
+// @Summary Create an organization
+// @Accept  json
+// @Produce  json
+// @Param   organization body models.OrganizationBase true "The organization to be created"
+// @Success 201 {object} models.Organization
+// @Router /organizations [post]
+func CreateOrganization(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var organization models.Organization
+	if !bindOrganization(c, &organization) {
+		return
+	}
+
+	if _, err := db.Model(&organization).Insert(); err != nil {
+		c.Error(err)
+		status, message := organizationWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, organization)
+}
+
+// @Summary Retrieve the organization the request is for
+// @Produce  json
+// @Param   X-Tenant header string false "The slug of the organization, if not signed in"
+// @Success 200 {object} models.Organization "The organization"
+// @Router /organizations/current [get]
+func RetrieveCurrentOrganization(c *gin.Context) {
+	c.JSON(http.StatusOK, tenant.Current(c))
+}
+
+// @Summary Retrieve an organization by id
+// @Produce  json
+// @Param   id path int true "The id of the organization to be retrieved"
+// @Success 200 {object} models.Organization "The organization for that id"
+// @Router /organizations/:id [get]
+func RetrieveOrganization(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var organizationId models.OrganizationID
+	if err := c.ShouldBindUri(&organizationId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var organization models.Organization
+	organization.OrganizationID = organizationId
+	if err := db.Model(&organization).WherePK().Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// @Summary Update an organization by id
+// @Accept  json
+// @Produce  json
+// @Param   id path int true "The id of the organization to be updated"
+// @Param   organization body models.OrganizationBase true "The organization data to be updated"
+// @Success 200 {object} models.Organization "The updated organization"
+// @Router /organizations/:id [put]
+func UpdateOrganization(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var organizationId models.OrganizationID
+	if err := c.ShouldBindUri(&organizationId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var organization models.Organization
+	if !bindOrganization(c, &organization) {
+		return
+	}
+	// The URL ID overrides any model ID
+	organization.OrganizationID = organizationId
+
+	res, err := db.Model(&organization).
+		Column("name", "slug", "updated_at").
+		Value("updated_at", "now()").
+		WherePK().
+		Returning("*").
+		Update()
+	if err != nil {
+		c.Error(err)
+		status, message := organizationWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, organization)
+}
+
+// @Summary Delete an organization by id, with its groups, roles and attributes
+// @Produce  json
+// @Param   id path int true "The id of the organization to be deleted"
+// @Success 204 {string} nil
+// @Failure 409 {string} nil "The organization has users, or is the default_tenant"
+// @Router /organizations/:id [delete]
+func DeleteOrganization(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var organizationId models.OrganizationID
+	if err := c.ShouldBindUri(&organizationId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var organization models.Organization
+	organization.OrganizationID = organizationId
+	if err := db.Model(&organization).WherePK().Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+		return
+	}
+	// Requests that don't name an organization would have nowhere to go
+	if organization.Slug == viper.GetString("default_tenant") {
+		c.JSON(http.StatusConflict, gin.H{"message": "Organization is the default_tenant"})
+		return
+	}
+
+	if _, err := db.Model(&organization).WherePK().Delete(); err != nil {
+		c.Error(err)
+		if strings.Contains(err.Error(), database.FK_ERROR_CODE) {
+			c.JSON(http.StatusConflict, gin.H{"message": "Organization has users"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+)
+
+// Get the pagination, with defaults, and the offset of the page. Returns
+// false, after writing the error response, if it's invalid.
+func bindPagination(c *gin.Context) (models.Pagination, int, bool) {
+	var pagination models.Pagination
+	if err := c.ShouldBindQuery(&pagination); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return pagination, 0, false
+	}
+
+	// Set default pagination and offset
+	if pagination.Page == 0 {
+		pagination.Page = 1
+	}
+	if pagination.PageSize == 0 {
+		pagination.PageSize = 20
+	}
+	offset := (pagination.Page - 1) * pagination.PageSize
+
+	return pagination, offset, true
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// The rows holding a user's personal data outside user_accounts, which are
+// deleted when it's erased
+var erasedUserData = []interface{}{
+	(*models.UserEmail)(nil),
+	(*models.UserPhone)(nil),
+	(*models.UserAddress)(nil),
+	(*models.UserNameChange)(nil),
+	(*models.PhoneVerification)(nil),
+	(*models.EmailVerification)(nil),
+	(*models.Invitation)(nil),
+	(*models.Session)(nil),
+}
+
+func newUserErasure(userAccount *models.UserAccount, reason string) *models.UserErasure {
+	return &models.UserErasure{
+		UserId:   userAccount.Id,
+		PublicId: userAccount.PublicId,
+		Reason:   reason,
+		ErasedAt: time.Now(),
+	}
+}
+
+// Erase the user's personal data and disable them, if they're still at the
+// version, and record the erasure. The row stays, so whatever refers to it
+// still does.
+func eraseUserAccount(db *pg.DB, userAccount *models.UserAccount, erasure *models.UserErasure) (orm.Result, error) {
+	var res orm.Result
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var err error
+		res, err = tx.Model(userAccount).
+			Set(`user_name = NULL, password_hash = NULL, first_name = NULL, middle_name = NULL, last_name = NULL,
+				email = NULL, email_verified_at = NULL, primary_phone_number = NULL, phone_verified_at = NULL,
+				country = NULL, first_name_index = NULL, middle_name_index = NULL, last_name_index = NULL,
+				email_index = NULL, primary_phone_number_index = NULL, last_login_at = NULL, last_seen_at = NULL,
+				retention_warned_at = NULL, attributes = '{}', status = ?, status_reason = ?, status_changed_at = now(),
+				version = version + 1`, models.UserStatusDisabled, erasure.Reason).
+			WherePK().
+			Where("version = ?", userAccount.Version).
+			Returning("*").
+			Update()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		for _, model := range erasedUserData {
+			if _, err := tx.Model(model).Where("user_id = ?", userAccount.Id).Delete(); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.Model(erasure).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+			return err
+		}
+		if err := scrubUserEvents(tx, userAccount.Id); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, userAccount)
+	})
+	return res, err
+}
+
+// @Summary Export everything stored about a user, to answer a data subject access request
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {object} models.UserDataExport "The user's data, as an attachment"
+// @Router /users/:id/data-export [get]
+func ExportUserData(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	userAccount, ok := retrieveContactUser(c, db, userId.Id)
+	if !ok {
+		return
+	}
+	userOutgoing := newUserOutgoing(userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	export := models.UserDataExport{
+		ExportedAt:  time.Now(),
+		User:        userOutgoing,
+		Emails:      []models.UserEmail{},
+		Phones:      []models.UserPhone{},
+		Addresses:   []models.UserAddress{},
+		NameChanges: []models.UserNameChange{},
+		Sessions:    []models.Session{},
+		Groups:      []models.Group{},
+		Roles:       []models.Role{},
+		Invitations: []models.Invitation{},
+		Erasures:    []models.UserErasure{},
+	}
+	queries := []*orm.Query{
+		db.Model(&export.Emails).Where("user_id = ?", userId.Id),
+		db.Model(&export.Phones).Where("user_id = ?", userId.Id),
+		db.Model(&export.Addresses).Where("user_id = ?", userId.Id),
+		db.Model(&export.NameChanges).Where("user_id = ?", userId.Id).Where(inTenant),
+		db.Model(&export.Sessions).Where("user_id = ?", userId.Id),
+		db.Model(&export.Groups).Where("id IN (SELECT group_id FROM group_members WHERE user_id = ?)", userId.Id).Where(inTenant),
+		db.Model(&export.Roles).Where("id IN (SELECT role_id FROM user_roles WHERE user_id = ?)", userId.Id).Where(inTenant),
+		db.Model(&export.Invitations).Where("user_id = ?", userId.Id).Where(inTenant),
+		db.Model(&export.Erasures).Where("user_id = ?", userId.Id).Where(inTenant),
+	}
+	for _, query := range queries {
+		if err := query.Order("id").Select(); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=user-%s.json", userAccount.PublicId))
+	c.JSON(http.StatusOK, export)
+}
+
+// @Summary Erase a user's personal data, keeping an anonymous, disabled user and a record of the erasure
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   erasure body models.UserErasureIncoming true "Why the user is erased, e.g. the request it answers"
+// @Param   If-Match header string false "ETag of the version being erased"
+// @Success 200 {object} models.UserOutgoing "What's left of the user"
+// @Failure 412 {string} nil "The user was modified since the ETag was retrieved"
+// @Router /users/:id/erase [post]
+func EraseUser(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the request body
+	var incoming models.UserErasureIncoming
+	if err := c.BindJSON(&incoming); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	userAccount, ok := retrieveContactUser(c, db, userId.Id)
+	if !ok {
+		return
+	}
+	if !checkIfMatch(c, userAccount) {
+		return
+	}
+
+	erasure := newUserErasure(userAccount, incoming.Reason)
+	if caller := auth.Caller(c); caller != nil {
+		erasure.ErasedBy = &caller.Id
+	}
+
+	// Only erase if nobody has changed the user since the check
+	res, err := eraseUserAccount(db, userAccount, erasure)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+		return
+	}
+
+	c.Header("ETag", userETag(userAccount))
+	c.JSON(http.StatusOK, newUserOutgoing(userAccount))
+}
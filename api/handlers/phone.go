@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+	"github.com/spf13/viper"
+)
+
+var phoneNumberTypeNames = map[phonenumbers.PhoneNumberType]string{
+	phonenumbers.FIXED_LINE:           "fixed_line",
+	phonenumbers.MOBILE:               "mobile",
+	phonenumbers.FIXED_LINE_OR_MOBILE: "fixed_line_or_mobile",
+	phonenumbers.TOLL_FREE:            "toll_free",
+	phonenumbers.PREMIUM_RATE:         "premium_rate",
+	phonenumbers.SHARED_COST:          "shared_cost",
+	phonenumbers.VOIP:                 "voip",
+	phonenumbers.PERSONAL_NUMBER:      "personal_number",
+	phonenumbers.PAGER:                "pager",
+	phonenumbers.UAN:                  "uan",
+	phonenumbers.VOICEMAIL:            "voicemail",
+	phonenumbers.UNKNOWN:              "unknown",
+}
+
+func setPhoneDefaults() {
+	// The region numbers without a country code are assumed to be in
+	viper.SetDefault("default_phone_region", "US")
+	// Numbers the metadata can't classify (e.g. 555 numbers) are unknown
+	viper.SetDefault("phone_allowed_types", "fixed_line,mobile,fixed_line_or_mobile,voip,personal_number,unknown")
+}
+
+// The first non-empty region, falling back to the configured default
+func phoneRegion(regions ...string) string {
+	for _, region := range regions {
+		if region != "" {
+			return strings.ToUpper(region)
+		}
+	}
+	return strings.ToUpper(viper.GetString("default_phone_region"))
+}
+
+// Parse a phone number, in the given region unless it has a country code, and
+// check it is a type of number we accept
+func parsePhoneNumber(field string, number string, region string) (*phonenumbers.PhoneNumber, error) {
+	phoneNumber, err := phonenumbers.Parse(number, region)
+	if err != nil || !phonenumbers.IsPossibleNumber(phoneNumber) {
+		return nil, errors.New(field + " must be a valid telephone number")
+	}
+
+	numberType := phoneNumberTypeNames[phonenumbers.GetNumberType(phoneNumber)]
+	for _, allowed := range strings.Split(viper.GetString("phone_allowed_types"), ",") {
+		if strings.TrimSpace(allowed) == numberType {
+			return phoneNumber, nil
+		}
+	}
+	return nil, errors.New(field + " must not be a " + numberType + " number")
+}
+
+// Format an E.164 phone number for display to someone in the region: in
+// national format if the number is from there, international otherwise.
+// Also returns the type of the number.
+func displayPhoneNumber(e164 string, region string) (string, string) {
+	phoneNumber, err := phonenumbers.Parse(e164, region)
+	if err != nil {
+		return e164, phoneNumberTypeNames[phonenumbers.UNKNOWN]
+	}
+
+	numberType := phoneNumberTypeNames[phonenumbers.GetNumberType(phoneNumber)]
+	if int(phoneNumber.GetCountryCode()) == phonenumbers.GetCountryCodeForRegion(region) {
+		return phonenumbers.Format(phoneNumber, phonenumbers.NATIONAL), numberType
+	}
+	return phonenumbers.Format(phoneNumber, phonenumbers.INTERNATIONAL), numberType
+}
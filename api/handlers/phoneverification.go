@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/sms"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+func setPhoneVerificationDefaults() {
+	// How long a code can be confirmed for
+	viper.SetDefault("phone_verification_ttl", "10m")
+	// Incorrect codes allowed before the code is discarded
+	viper.SetDefault("phone_verification_max_attempts", 5)
+	// How soon another code can be sent to the same user
+	viper.SetDefault("phone_verification_resend_interval", "30s")
+}
+
+// A random 6 digit code
+func newVerificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// Codes are only stored hashed, along with the number they were sent to
+func hashVerificationCode(phoneNumber string, code string) string {
+	sum := sha256.Sum256([]byte(phoneNumber + ":" + code))
+	return hex.EncodeToString(sum[:])
+}
+
+// @Summary Send a code to verify a user's primary phone number
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user whose phone number is to be verified"
+// @Success 202 {string} nil "The code was sent"
+// @Failure 409 {string} nil "The phone number is already verified"
+// @Failure 429 {string} nil "A code was sent too recently"
+// @Router /users/:id/phones/verify [post]
+func VerifyUserPhone(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	sender := c.MustGet("SMS").(sms.SMSSender)
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	userAccount, ok := retrieveContactUser(c, db, userId.Id)
+	if !ok {
+		return
+	}
+	if userAccount.PrimaryPhoneNumber == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "User Account has no primary_phone_number"})
+		return
+	}
+	if userAccount.PhoneVerifiedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"message": "primary_phone_number is already verified"})
+		return
+	}
+
+	// Don't let clients flood the user with codes
+	var pending models.PhoneVerification
+	pending.UserId = userAccount.Id
+	if err := db.Model(&pending).WherePK().Select(); err == nil {
+		resendAt := pending.CreatedAt.Add(viper.GetDuration("phone_verification_resend_interval"))
+		if time.Now().Before(resendAt) {
+			c.Header("Retry-After", fmt.Sprintf("%.0f", time.Until(resendAt).Seconds()+1))
+			c.JSON(http.StatusTooManyRequests, gin.H{"message": "A code was sent too recently"})
+			return
+		}
+	}
+
+	code, err := newVerificationCode()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	// A new code replaces any outstanding one
+	verification := &models.PhoneVerification{
+		UserId:      userAccount.Id,
+		PhoneNumber: userAccount.PrimaryPhoneNumber,
+		CodeHash:    hashVerificationCode(userAccount.PrimaryPhoneNumber, code),
+		ExpiresAt:   time.Now().Add(viper.GetDuration("phone_verification_ttl")),
+	}
+	_, err = db.Model(verification).
+		OnConflict("(user_id) DO UPDATE").
+		Set("phone_number = EXCLUDED.phone_number, phone_number_index = EXCLUDED.phone_number_index, code_hash = EXCLUDED.code_hash, attempts = 0, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at").
+		Insert()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	message := fmt.Sprintf("Your verification code is %s", code)
+	if err := sender.Send(userAccount.PrimaryPhoneNumber, message); err != nil {
+		c.Error(err)
+		db.Model(verification).WherePK().Delete()
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "The code could not be sent"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"expires_at": verification.ExpiresAt})
+}
+
+// @Summary Confirm a user's primary phone number with the code sent to it
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user whose phone number is being verified"
+// @Param   code body models.PhoneVerificationConfirm true "The code that was sent"
+// @Success 200 {object} models.UserOutgoing "The user entity, with the phone number verified"
+// @Failure 400 {string} nil "The code is incorrect"
+// @Failure 404 {string} nil "No code is outstanding"
+// @Failure 410 {string} nil "The code has expired, or the phone number has changed"
+// @Failure 429 {string} nil "Too many incorrect codes, the code has been discarded"
+// @Router /users/:id/phones/confirm [post]
+func ConfirmUserPhone(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the request body
+	var confirm models.PhoneVerificationConfirm
+	if err := c.BindJSON(&confirm); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	// Attempts are counted even when the code is wrong, so the outcome is
+	// decided inside the transaction but only database errors roll it back
+	var status int
+	var message string
+	var userAccount models.UserAccount
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var verification models.PhoneVerification
+		verification.UserId = userId.Id
+		err := tx.Model(&verification).
+			WherePK().
+			Where("user_id IN (" + tenantUserIdsQuery + ")").
+			For("UPDATE").
+			Select()
+		if err != nil {
+			if err == pg.ErrNoRows {
+				status, message = http.StatusNotFound, "No phone verification is pending"
+				return nil
+			}
+			return err
+		}
+
+		userAccount.UserID = userId
+		if err := tx.Model(&userAccount).WherePK().Select(); err != nil {
+			return err
+		}
+
+		if verification.PhoneNumber != userAccount.PrimaryPhoneNumber {
+			status, message = http.StatusGone, "primary_phone_number has changed since the code was sent"
+		} else if time.Now().After(verification.ExpiresAt) {
+			status, message = http.StatusGone, "The code has expired"
+		} else if subtle.ConstantTimeCompare(
+			[]byte(hashVerificationCode(verification.PhoneNumber, confirm.Code)),
+			[]byte(verification.CodeHash)) != 1 {
+			verification.Attempts++
+			if verification.Attempts < viper.GetInt("phone_verification_max_attempts") {
+				status, message = http.StatusBadRequest, "The code is incorrect"
+				_, err := tx.Model(&verification).Column("attempts").WherePK().Update()
+				return err
+			}
+			status, message = http.StatusTooManyRequests, "Too many incorrect codes, request a new one"
+		} else {
+			status = http.StatusOK
+			_, err := tx.Model(&userAccount).
+				Set("phone_verified_at = now(), version = version + 1").
+				WherePK().
+				Returning("*").
+				Update()
+			if err != nil {
+				return err
+			}
+		}
+
+		// The code can't be used again
+		if _, err := tx.Model(&verification).WherePK().Delete(); err != nil || status != http.StatusOK {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if status != http.StatusOK {
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	userOutgoing := newUserOutgoing(&userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.Header("ETag", userETag(&userAccount))
+	c.JSON(http.StatusOK, userOutgoing)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// A strong ETag for the current version of the user account
+func userETag(userAccount *models.UserAccount) string {
+	return fmt.Sprintf("\"%d-%d\"", userAccount.Id, userAccount.Version)
+}
+
+// Does any of the tags in a comma separated If-Match / If-None-Match header
+// match the ETag. Weak comparison is used when weak is true.
+func etagMatches(header string, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if weak {
+			tag = strings.TrimPrefix(tag, "W/")
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the If-None-Match header of a GET. Returns true, after writing the 304,
+// if the client already has the current representation.
+func notModified(c *gin.Context, etag string) bool {
+	ifNoneMatch := c.GetHeader("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag, true) {
+		return false
+	}
+	c.Status(http.StatusNotModified)
+	return true
+}
+
+func setPreconditionDefaults() {
+	// Whether modifying a user needs an If-Match header, so clients can't
+	// overwrite changes they haven't seen
+	viper.SetDefault("require_if_match", false)
+}
+
+// Check the If-Match header of a modifying request against the current user
+// account. Returns false, after writing the error response, if the request
+// should not proceed.
+func checkIfMatch(c *gin.Context, userAccount *models.UserAccount) bool {
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		if viper.GetBool("require_if_match") {
+			c.JSON(http.StatusPreconditionRequired, gin.H{"message": "If-Match header is required"})
+			return false
+		}
+		return true
+	}
+
+	// If-Match uses the strong comparison function
+	if !etagMatches(ifMatch, userETag(userAccount), false) {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+		return false
+	}
+	return true
+}
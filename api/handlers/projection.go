@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// The columns each field of a user response is made from
+var userFieldColumns = map[string][]string{
+	"id":                           {"id"},
+	"public_id":                    {"public_id"},
+	"user_name":                    {"user_name"},
+	"first_name":                   {"first_name"},
+	"middle_name":                  {"middle_name"},
+	"last_name":                    {"last_name"},
+	"email":                        {"email"},
+	"primary_phone_number":         {"primary_phone_number"},
+	"primary_phone_number_display": {"primary_phone_number", "country"},
+	"primary_phone_number_type":    {"primary_phone_number", "country"},
+	"country":                      {"country"},
+	"attributes":                   {"attributes"},
+	"email_verified_at":            {"email_verified_at"},
+	"phone_verified_at":            {"phone_verified_at"},
+	"status":                       {"status"},
+	"status_reason":                {"status_reason"},
+	"status_changed_at":            {"status_changed_at"},
+	"last_login_at":                {"last_login_at"},
+	"last_seen_at":                 {"last_seen_at"},
+}
+
+// The fields and expansions asked for in a request for users
+type userProjection struct {
+	// nil for every field
+	fields  []string
+	columns []string
+	groups  bool
+}
+
+// Get the fields and expansions asked for. Returns false, after writing the
+// error response, if there's a field users don't have.
+func bindUserProjection(c *gin.Context) (*userProjection, bool) {
+	var incoming models.UserProjection
+	if err := c.ShouldBindQuery(&incoming); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return nil, false
+	}
+
+	projection := &userProjection{groups: incoming.Expand == "groups"}
+	if incoming.Fields == "" {
+		return projection, true
+	}
+
+	// The ETag and expansions need these, whatever's asked for
+	projection.columns = []string{"id", "version"}
+	selected := map[string]bool{"id": true, "version": true}
+	for _, field := range strings.Split(incoming.Fields, ",") {
+		field = strings.TrimSpace(field)
+		columns, ok := userFieldColumns[field]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("unknown field %s", field)})
+			return nil, false
+		}
+		projection.fields = append(projection.fields, field)
+		for _, column := range columns {
+			if !selected[column] {
+				selected[column] = true
+				projection.columns = append(projection.columns, column)
+			}
+		}
+	}
+	return projection, true
+}
+
+// Select only the columns the fields are made from
+func (p *userProjection) selectColumns(query *orm.Query) *orm.Query {
+	if p.fields == nil {
+		return query
+	}
+	return query.Column(p.columns...)
+}
+
+// Make the response for each user, with only the fields asked for and
+// anything expanded
+func (p *userProjection) render(c *gin.Context, db orm.DB, userAccounts []models.UserAccount) ([]interface{}, error) {
+	usersOutgoing := make([]*models.UserOutgoing, len(userAccounts))
+	for i := range userAccounts {
+		usersOutgoing[i] = newUserOutgoing(&userAccounts[i])
+	}
+	redactPrivateAttributes(c, db, usersOutgoing...)
+
+	responses := make([]interface{}, len(usersOutgoing))
+	if p.fields == nil && !p.groups {
+		for i, userOutgoing := range usersOutgoing {
+			responses[i] = userOutgoing
+		}
+		return responses, nil
+	}
+
+	var groups map[uint][]models.Group
+	if p.groups {
+		var err error
+		if groups, err = groupsOfUsers(db, userAccounts); err != nil {
+			return nil, err
+		}
+	}
+	for i, userOutgoing := range usersOutgoing {
+		response, err := projectFields(userOutgoing, p.fields)
+		if err != nil {
+			return nil, err
+		}
+		if p.groups {
+			userGroups := groups[userAccounts[i].Id]
+			if userGroups == nil {
+				userGroups = []models.Group{}
+			}
+			response["groups"], err = json.Marshal(userGroups)
+			if err != nil {
+				return nil, err
+			}
+		}
+		responses[i] = response
+	}
+	return responses, nil
+}
+
+// The user as a JSON object with only the fields, or every field if nil
+func projectFields(userOutgoing *models.UserOutgoing, fields []string) (map[string]json.RawMessage, error) {
+	encoded, err := json.Marshal(userOutgoing)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &all); err != nil {
+		return nil, err
+	}
+	if fields == nil {
+		return all, nil
+	}
+
+	projected := map[string]json.RawMessage{}
+	for _, field := range fields {
+		if value, ok := all[field]; ok {
+			projected[field] = value
+		}
+	}
+	return projected, nil
+}
+
+// The groups each of the users is directly in, in one query
+func groupsOfUsers(db orm.DB, userAccounts []models.UserAccount) (map[uint][]models.Group, error) {
+	groups := map[uint][]models.Group{}
+	if len(userAccounts) == 0 {
+		return groups, nil
+	}
+	userIds := make([]uint, len(userAccounts))
+	for i := range userAccounts {
+		userIds[i] = userAccounts[i].Id
+	}
+
+	var memberships []struct {
+		UserId uint
+		models.Group
+	}
+	_, err := db.Query(&memberships, `
+		SELECT group_members.user_id, groups.*
+		FROM groups JOIN group_members ON group_members.group_id = groups.id
+		WHERE group_members.user_id IN (?) AND groups.`+inTenant+`
+		ORDER BY groups.id`, pg.In(userIds))
+	if err != nil {
+		return nil, err
+	}
+	for _, membership := range memberships {
+		groups[membership.UserId] = append(groups[membership.UserId], membership.Group)
+	}
+	return groups, nil
+}
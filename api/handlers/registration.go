@@ -0,0 +1,348 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/captcha"
+	"github.com/davidwarshaw/golang-user-crud/api/disposable"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+func setRegistrationDefaults() {
+	// open, closed, or allowlist to only let in addresses at
+	// registration_allowed_domains
+	viper.SetDefault("registration", "closed")
+	// Comma separated, e.g. example.com,example.org
+	viper.SetDefault("registration_allowed_domains", "")
+	// How long the mailed token can verify the address for
+	viper.SetDefault("email_verification_ttl", "24h")
+	// How soon another token can be sent to the same address
+	viper.SetDefault("email_verification_resend_interval", "1m")
+	// The link in the mail, e.g. https://{tenant}.example.com/verify/{token}.
+	// Without one, the mail just has the token.
+	viper.SetDefault("email_verification_url", "")
+}
+
+// Whether the email address can register. Returns false, after writing the
+// error response, if it can't.
+func checkRegistrationOpen(c *gin.Context, email string) bool {
+	domain := strings.ToLower(email[strings.LastIndex(email, "@")+1:])
+
+	switch viper.GetString("registration") {
+	case "open":
+	case "allowlist":
+		allowed := false
+		for _, allowedDomain := range strings.Split(viper.GetString("registration_allowed_domains"), ",") {
+			if strings.ToLower(strings.TrimSpace(allowedDomain)) == domain {
+				allowed = true
+			}
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"message": "Registration is not open to " + domain})
+			return false
+		}
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"message": "Registration is closed"})
+		return false
+	}
+
+	if disposable.IsDisposable(email) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Disposable email addresses can't be used"})
+		return false
+	}
+	return true
+}
+
+// Whether the captcha response is right. Returns false, after writing the
+// error response, if it isn't.
+func checkCaptcha(c *gin.Context, response string) bool {
+	verifier := c.MustGet("Captcha").(captcha.Verifier)
+
+	err := verifier.Verify(response, c.ClientIP())
+	if err == nil {
+		return true
+	}
+	c.Error(err)
+	if err == captcha.ErrMissing || err == captcha.ErrFailed {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{"message": "The captcha could not be checked"})
+	return false
+}
+
+// Mail a new token to verify the user's email, replacing any outstanding one
+func sendEmailVerification(db orm.DB, mailer mail.Mailer, organization *models.Organization, userAccount *models.UserAccount) error {
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	verification := &models.EmailVerification{
+		UserId:    userAccount.Id,
+		Email:     userAccount.Email,
+		TokenHash: tokenHash,
+		CreatedAt: time.Now(),
+	}
+	verification.ExpiresAt = verification.CreatedAt.Add(viper.GetDuration("email_verification_ttl"))
+	_, err = db.Model(verification).
+		OnConflict("(user_id) DO UPDATE").
+		Set("email = EXCLUDED.email, email_index = EXCLUDED.email_index, token_hash = EXCLUDED.token_hash, created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at").
+		Insert()
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Verify your email address to finish registering with %s.\n\n", organization.Name)
+	if url := viper.GetString("email_verification_url"); url != "" {
+		url = strings.NewReplacer("{token}", token, "{tenant}", organization.Slug).Replace(url)
+		body += fmt.Sprintf("Verify it at %s before %s.\n", url, verification.ExpiresAt.Format(time.RFC1123))
+	} else {
+		body += fmt.Sprintf("Verify it before %s with the token:\n%s\n", verification.ExpiresAt.Format(time.RFC1123), token)
+	}
+	return mailer.Send(verification.Email, "Verify your email address", body)
+}
+
+// @Summary Get a challenge to solve for the captcha, when it's proof of work
+// @Produce  json
+// @Success 200 {object} captcha.Challenge "The challenge, to respond to with <challenge>:<nonce>"
+// @Failure 404 {string} nil "The captcha doesn't use challenges"
+// @Router /register/challenge [get]
+func RetrieveRegistrationChallenge(c *gin.Context) {
+	challenger, ok := c.MustGet("Captcha").(captcha.Challenger)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"message": "The captcha doesn't use challenges"})
+		return
+	}
+
+	challenge, err := challenger.Challenge()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, challenge)
+}
+
+// @Summary Sign up, creating a pending user who becomes active once their email is verified
+// @Accept  json
+// @Produce  json
+// @Param   registration body models.Registration true "The user signing up, with the captcha response if one is needed"
+// @Success 201 {object} models.UserOutgoing "The pending user. A token to verify their email was mailed to them."
+// @Failure 403 {string} nil "Registration is closed, or not open to the email's domain"
+// @Failure 429 {string} nil "Too many registrations from the client's IP"
+// @Router /register [post]
+func Register(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	mailer := c.MustGet("Mailer").(mail.Mailer)
+	// Get the request body
+	var registration models.Registration
+	if err := c.BindJSON(&registration); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	if !checkRegistrationOpen(c, registration.Email) || !checkCaptcha(c, registration.Captcha) {
+		return
+	}
+
+	userAccount, err := normalizeIncomingUserAccount(registration.UserIncoming)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	userAccount.Status = models.UserStatusPending
+
+	// Nothing is kept if the token can't be sent
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		// Registrations that were never verified don't hold on to the user_name
+		// or email
+		var stale []models.UserAccount
+		emailCondition, emailParams := emailMatch(userAccount.Email)
+		_, err := tx.Model(&stale).
+			Where(inTenant).
+			WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+				return q.WhereOr("lower(user_name) = lower(?)", userAccount.UserName).
+					WhereOr(emailCondition, emailParams...), nil
+			}).
+			Where("status = ?", models.UserStatusPending).
+			Where("id IN (SELECT user_id FROM email_verifications WHERE expires_at < now())").
+			Returning("*").
+			Delete()
+		if err != nil {
+			return err
+		}
+		if err := restrictPrivateAttributes(tx, userAccount, policy.Allowed(c, policy.AttributesPrivate)); err != nil {
+			return err
+		}
+		if err := insertUserAccount(tx, userAccount); err != nil {
+			return err
+		}
+		if err := sendEmailVerification(tx, mailer, tenant.Current(c), userAccount); err != nil {
+			return err
+		}
+		for i := range stale {
+			if err := recordUserEvent(tx, models.UserEventDeleted, &stale[i]); err != nil {
+				return err
+			}
+		}
+		return recordUserEvent(tx, models.UserEventCreated, userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	userOutgoing := newUserOutgoing(userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.JSON(http.StatusCreated, userOutgoing)
+}
+
+// @Summary Verify the email of a registered user with the token mailed to it, making them active
+// @Accept  json
+// @Produce  json
+// @Param   token body models.EmailVerificationConfirm true "The token that was mailed"
+// @Success 200 {object} models.UserOutgoing "The now active user, who can sign in"
+// @Failure 404 {string} nil "The token is incorrect, or was replaced by a newer one"
+// @Failure 410 {string} nil "The token has expired"
+// @Router /register/verify [post]
+func VerifyEmail(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var confirm models.EmailVerificationConfirm
+	if err := c.BindJSON(&confirm); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var verification models.EmailVerification
+	err := db.Model(&verification).
+		Where("token_hash = ?", auth.HashToken(confirm.Token)).
+		Where("user_id IN (" + tenantUserIdsQuery + ")").
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Email verification not found"})
+		return
+	}
+	if time.Now().After(verification.ExpiresAt) {
+		c.JSON(http.StatusGone, gin.H{"message": "Email verification has expired"})
+		return
+	}
+
+	// Only the address the token was sent to is verified
+	var userAccount models.UserAccount
+	userAccount.Id = verification.UserId
+	var activated bool
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		res, err := tx.Model(&userAccount).
+			Set("status = ?, status_reason = ?, status_changed_at = now(), email_verified_at = now(), version = version + 1",
+				models.UserStatusActive, "email verified").
+			WherePK().
+			Where(inTenant).
+			Where("status = ?", models.UserStatusPending).
+			Where("? = ?", piiMatch("email", verification.Email)...).
+			Returning("*").
+			Update()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		activated = true
+		if _, err := tx.Model(&verification).WherePK().Delete(); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if !activated {
+		c.JSON(http.StatusConflict, gin.H{"message": "User Account is no longer pending, or its email has changed"})
+		return
+	}
+
+	c.Header("ETag", userETag(&userAccount))
+
+	userOutgoing := newUserOutgoing(&userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.JSON(http.StatusOK, userOutgoing)
+}
+
+// @Summary Mail a new token to verify a registration, if the email has one outstanding
+// @Accept  json
+// @Produce  json
+// @Param   email body models.EmailVerificationResend true "The email the user registered with"
+// @Success 202 {string} nil "Whether or not the email has a registration, so as not to reveal which do"
+// @Router /register/resend [post]
+func ResendEmailVerification(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+	mailer := c.MustGet("Mailer").(mail.Mailer)
+	// Get the request body
+	var resend models.EmailVerificationResend
+	if err := c.BindJSON(&resend); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	accepted := gin.H{"message": "If the email has a registration to verify, a new token was sent"}
+
+	var verification models.EmailVerification
+	condition, params := emailMatch(resend.Email)
+	err := db.Model(&verification).
+		Where(condition, params...).
+		Where("user_id IN (" + tenantUserIdsQuery + ")").
+		Order("created_at DESC").
+		Limit(1).
+		Select()
+	if err != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	// Don't let clients flood the address with mail
+	resendAt := verification.CreatedAt.Add(viper.GetDuration("email_verification_resend_interval"))
+	if time.Now().Before(resendAt) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	var userAccount models.UserAccount
+	userAccount.Id = verification.UserId
+	err = db.Model(&userAccount).WherePK().Where(inTenant).Where("status = ?", models.UserStatusPending).Select()
+	if err != nil {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		return sendEmailVerification(tx, mailer, tenant.Current(c), &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": "The token could not be sent"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, accepted)
+}
@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+// The advisory lock held while the retention rules are applied, so only one
+// process applies them at a time
+const retentionLockKey = 47047
+
+// The status_reason of users suspended for inactivity, which tells them apart
+// from users suspended by hand, who are never erased for it
+const retentionSuspendReason = "inactive"
+
+// How many users are loaded at a time while applying the rules
+const retentionBatchSize = 100
+
+// When an active user was last active: the last time they were seen or signed
+// in, or were created or reactivated
+const lastActiveAt = "greatest(last_seen_at, last_login_at, created_at, status_changed_at)"
+
+// In the order they're reported
+var retentionActions = []string{
+	models.RetentionActionErase,
+	models.RetentionActionSuspend,
+	models.RetentionActionWarn,
+}
+
+func setRetentionDefaults() {
+	// Days without being active after which active users are warned by mail,
+	// suspended, and erased. 0 turns a rule off. Users are only suspended once
+	// warned, and only erased once suspended, for as long as the gap between
+	// the rules.
+	viper.SetDefault("retention_warn_days", 0)
+	viper.SetDefault("retention_suspend_days", 0)
+	viper.SetDefault("retention_erase_days", 0)
+	// How often the rules are applied
+	viper.SetDefault("retention_interval", "1h")
+}
+
+// The retention rules from the config. The rules that are on must be in
+// order.
+func retentionRules() (models.RetentionRules, error) {
+	rules := models.RetentionRules{
+		WarnDays:    viper.GetInt("retention_warn_days"),
+		SuspendDays: viper.GetInt("retention_suspend_days"),
+		EraseDays:   viper.GetInt("retention_erase_days"),
+	}
+	previous := 0
+	for _, days := range []int{rules.WarnDays, rules.SuspendDays, rules.EraseDays} {
+		if days < 0 || (days != 0 && days <= previous) {
+			return rules, errors.New("retention days must increase from warn to suspend to erase")
+		}
+		if days != 0 {
+			previous = days
+		}
+	}
+	return rules, nil
+}
+
+func daysBefore(at time.Time, days int) time.Time {
+	return at.AddDate(0, 0, -days)
+}
+
+// Restrict a user account query to the users warned since they were last
+// active, at least the gap between the rules before the time, if warnings are
+// on
+func warnedBefore(query *orm.Query, rules models.RetentionRules, days int, at time.Time) *orm.Query {
+	if rules.WarnDays == 0 {
+		return query
+	}
+	return query.
+		Where("retention_warned_at >= "+lastActiveAt).
+		Where("retention_warned_at <= ?", daysBefore(at, days-rules.WarnDays))
+}
+
+// Restrict a user account query to the users a run of the rules at the time
+// would take the action on
+func retentionCandidates(query *orm.Query, rules models.RetentionRules, action string, at time.Time) *orm.Query {
+	switch {
+	case action == models.RetentionActionWarn && rules.WarnDays != 0:
+		return query.
+			Where("status = ?", models.UserStatusActive).
+			Where(lastActiveAt+" <= ?", daysBefore(at, rules.WarnDays)).
+			Where("retention_warned_at IS NULL OR retention_warned_at < " + lastActiveAt)
+	case action == models.RetentionActionSuspend && rules.SuspendDays != 0:
+		query = query.
+			Where("status = ?", models.UserStatusActive).
+			Where(lastActiveAt+" <= ?", daysBefore(at, rules.SuspendDays))
+		return warnedBefore(query, rules, rules.SuspendDays, at)
+	case action == models.RetentionActionErase && rules.EraseDays != 0 && rules.SuspendDays != 0:
+		return query.
+			Where("status = ?", models.UserStatusSuspended).
+			Where("status_reason = ?", retentionSuspendReason).
+			Where("status_changed_at <= ?", daysBefore(at, rules.EraseDays-rules.SuspendDays))
+	case action == models.RetentionActionErase && rules.EraseDays != 0:
+		query = query.
+			Where("status = ?", models.UserStatusActive).
+			Where(lastActiveAt+" <= ?", daysBefore(at, rules.EraseDays))
+		return warnedBefore(query, rules, rules.EraseDays, at)
+	}
+	return query.Where("FALSE")
+}
+
+// When the rules will next be applied: an interval after the last run, or now
+// if that's passed
+func nextRetentionRun(db orm.DB) (time.Time, error) {
+	var nextRunAt time.Time
+	_, err := db.QueryOne(pg.Scan(&nextRunAt),
+		"SELECT greatest(max(started_at) + make_interval(secs => ?), now()) FROM retention_runs",
+		viper.GetDuration("retention_interval").Seconds())
+	return nextRunAt, err
+}
+
+// The mail warning a user, which says what happens next and when
+func retentionWarning(rules models.RetentionRules, userAccount *models.UserAccount) (string, string) {
+	body := fmt.Sprintf("Your account %s hasn't been used for %d days.", userAccount.UserName, rules.WarnDays)
+	switch {
+	case rules.SuspendDays != 0:
+		body += fmt.Sprintf(" Sign in within %d days to keep it, or it will be suspended.", rules.SuspendDays-rules.WarnDays)
+	case rules.EraseDays != 0:
+		body += fmt.Sprintf(" Sign in within %d days to keep it, or it will be erased.", rules.EraseDays-rules.WarnDays)
+	}
+	return "Your account is inactive", body + "\n"
+}
+
+// Take the action on the user, returning whether it was. It isn't if the user
+// changed since they were loaded.
+func applyRetentionAction(db *pg.DB, mailer mail.Mailer, rules models.RetentionRules, action string, userAccount *models.UserAccount) (bool, error) {
+	// Erasures are recorded in the user's organization
+	db = db.WithParam("tenant_id", userAccount.TenantId)
+
+	switch action {
+	case models.RetentionActionWarn:
+		// Mailed first, so a failure to record it means mailing again rather
+		// than never. Users without an email can't be, but the rules go on.
+		if userAccount.Email != "" {
+			subject, body := retentionWarning(rules, userAccount)
+			if err := mailer.Send(userAccount.Email, subject, body); err != nil {
+				return false, err
+			}
+		}
+		res, err := db.Exec("UPDATE user_accounts SET retention_warned_at = now() WHERE id = ? AND version = ?",
+			userAccount.Id, userAccount.Version)
+		return err == nil && res.RowsAffected() > 0, err
+	case models.RetentionActionSuspend:
+		res, err := setUserStatus(db, userAccount, models.UserStatusSuspended, retentionSuspendReason)
+		return err == nil && res.RowsAffected() > 0, err
+	default:
+		erasure := newUserErasure(userAccount, fmt.Sprintf("inactive for %d days", rules.EraseDays))
+		res, err := eraseUserAccount(db, userAccount, erasure)
+		return err == nil && res.RowsAffected() > 0, err
+	}
+}
+
+// Take each action on every organization's users it applies to, counting
+// them in the run
+func applyRetentionActions(db *pg.DB, mailer mail.Mailer, rules models.RetentionRules, run *models.RetentionRun) error {
+	counts := map[string]*int{
+		models.RetentionActionWarn:    &run.Warned,
+		models.RetentionActionSuspend: &run.Suspended,
+		models.RetentionActionErase:   &run.Erased,
+	}
+	for _, action := range retentionActions {
+		var lastId uint
+		for {
+			var userAccounts []models.UserAccount
+			query := db.Model(&userAccounts).Where("id > ?", lastId).Order("id").Limit(retentionBatchSize)
+			if err := retentionCandidates(query, rules, action, run.StartedAt).Select(); err != nil {
+				return err
+			}
+			for i := range userAccounts {
+				applied, err := applyRetentionAction(db, mailer, rules, action, &userAccounts[i])
+				if err != nil {
+					return err
+				}
+				if applied {
+					*counts[action]++
+				}
+				lastId = userAccounts[i].Id
+			}
+			if len(userAccounts) < retentionBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// Apply the retention rules, unless they're off, another process is applying
+// them, or onlyIfDue and it isn't time. Returns the run, or nil if there
+// wasn't one.
+func applyRetention(db *pg.DB, mailer mail.Mailer, onlyIfDue bool) (*models.RetentionRun, error) {
+	rules, err := retentionRules()
+	if err != nil || rules == (models.RetentionRules{}) {
+		return nil, err
+	}
+
+	// The lock belongs to the connection that takes it, so it's taken and
+	// released on one set aside for the run
+	conn := db.Conn()
+	defer conn.Close()
+	var locked bool
+	if _, err := conn.QueryOne(pg.Scan(&locked), "SELECT pg_try_advisory_lock(?)", retentionLockKey); err != nil || !locked {
+		return nil, err
+	}
+	defer conn.Exec("SELECT pg_advisory_unlock(?)", retentionLockKey)
+
+	if onlyIfDue {
+		nextRunAt, err := nextRetentionRun(conn)
+		if err != nil || nextRunAt.After(time.Now()) {
+			return nil, err
+		}
+	}
+
+	run := &models.RetentionRun{StartedAt: time.Now()}
+	if _, err := conn.Model(run).Insert(); err != nil {
+		return nil, err
+	}
+	err = applyRetentionActions(db, mailer, rules, run)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+	if _, updateErr := conn.Model(run).WherePK().Update(); err == nil {
+		err = updateErr
+	}
+	return run, err
+}
+
+// Apply the retention rules now, unless another process is. Returns the run,
+// or nil if there wasn't one.
+func ApplyRetention(db *pg.DB, mailer mail.Mailer) (*models.RetentionRun, error) {
+	return applyRetention(db, mailer, false)
+}
+
+// Apply the retention rules whenever they're due, forever. Every API process
+// runs this, and whichever takes the lock applies them.
+func RunRetentionScheduler(db *pg.DB, mailer mail.Mailer) {
+	// Check often enough that runs aren't late by much
+	check := time.Minute
+	if interval := viper.GetDuration("retention_interval"); interval < check {
+		check = interval
+	}
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+
+	for {
+		run, err := applyRetention(db, mailer, true)
+		if err != nil {
+			log.Printf("Applying the retention rules failed: %s", err)
+		} else if run != nil {
+			log.Printf("Applied the retention rules: warned %d, suspended %d, erased %d", run.Warned, run.Suspended, run.Erased)
+		}
+		<-ticker.C
+	}
+}
+
+// @Summary Report the users the next run of the retention rules will warn, suspend or erase
+// @Produce  json
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Success 200 {object} models.RetentionReport "The users, erasures first, then suspensions, then warnings"
+// @Router /users/retention-report [get]
+func RetrieveRetentionReport(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get pagination
+	pagination, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+
+	rules, err := retentionRules()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	nextRunAt, err := nextRetentionRun(db)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	report := models.RetentionReport{
+		NextRunAt:  nextRunAt,
+		Rules:      rules,
+		Counts:     make(map[string]int),
+		Data:       []models.RetentionCandidate{},
+		Pagination: pagination,
+	}
+	// The page runs on from one action's users into the next
+	limit := pagination.PageSize
+	for _, action := range retentionActions {
+		count, err := retentionCandidates(db.Model((*models.UserAccount)(nil)).Where(inTenant), rules, action, nextRunAt).Count()
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		report.Counts[action] = count
+		if offset >= count {
+			offset -= count
+			continue
+		}
+		if limit == 0 {
+			continue
+		}
+
+		var userAccounts []models.UserAccount
+		query := db.Model(&userAccounts).Where(inTenant).Order("id").Offset(offset).Limit(limit)
+		if err := retentionCandidates(query, rules, action, nextRunAt).Select(); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		offset, limit = 0, limit-len(userAccounts)
+		for i := range userAccounts {
+			userOutgoing := newUserOutgoing(&userAccounts[i])
+			redactPrivateAttributes(c, db, userOutgoing)
+			report.Data = append(report.Data, models.RetentionCandidate{Action: action, User: userOutgoing})
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// RolePermissions finds the permissions of the roles a user holds, directly
+// or through any group they're in
+func RolePermissions(db orm.DB, userId uint) ([]string, error) {
+	var permissions []string
+	_, err := db.Query(&permissions, `
+		SELECT DISTINCT unnest(permissions) FROM roles
+		WHERE `+inTenant+`
+		AND (id IN (SELECT role_id FROM user_roles WHERE user_id = ?)
+		OR id IN (SELECT role_id FROM group_roles WHERE group_id IN (`+userGroupIdsQuery+`)))`,
+		userId, userId)
+	return permissions, err
+}
+
+// Get a role from the request body, checking its permissions are real
+func bindRole(c *gin.Context, role *models.Role) bool {
+	if err := c.BindJSON(&role.RoleBase); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	if role.Permissions == nil {
+		role.Permissions = []string{}
+	}
+	for _, permission := range role.Permissions {
+		if !policy.IsPermission(permission) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "unknown permission " + permission})
+			return false
+		}
+		if !policy.IsGrantable(permission) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "a role can't grant " + permission})
+			return false
+		}
+	}
+	return true
+}
+
+// The status and message for a failed write of a role
+func roleWriteError(err error) (int, string) {
+	if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
+		return http.StatusBadRequest, "name already exists"
+	}
+	return http.StatusServiceUnavailable, err.Error()
+}
+
+// @Summary Retrieve all roles
+// @Produce  json
+// @Success 200 {array} models.Role "The roles"
+// @Router /roles [get]
+func RetrieveAllRoles(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	roles := []models.Role{}
+	if err := db.Model(&roles).Where(inTenant).Order("id").Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": roles})
+}
+
+// @Summary Create a role
+// @Accept  json
+// @Produce  json
+// @Param   role body models.RoleBase true "The role to be created"
+// @Success 201 {object} models.Role
+// @Router /roles [post]
+func CreateRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var role models.Role
+	if !bindRole(c, &role) {
+		return
+	}
+
+	if _, err := db.Model(&role).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		c.Error(err)
+		status, message := roleWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+
+	c.JSON(http.StatusCreated, role)
+}
+
+// @Summary Retrieve a role by id
+// @Produce  json
+// @Param   id path int true "The id of the role to be retrieved"
+// @Success 200 {object} models.Role "The role for that id"
+// @Router /roles/:id [get]
+func RetrieveRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var roleId models.RoleID
+	if err := c.ShouldBindUri(&roleId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var role models.Role
+	role.RoleID = roleId
+	if err := db.Model(&role).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// @Summary Update a role by id
+// @Accept  json
+// @Produce  json
+// @Param   id path int true "The id of the role to be updated"
+// @Param   role body models.RoleBase true "The role data to be updated"
+// @Success 200 {object} models.Role "The updated role"
+// @Router /roles/:id [put]
+func UpdateRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var roleId models.RoleID
+	if err := c.ShouldBindUri(&roleId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var role models.Role
+	if !bindRole(c, &role) {
+		return
+	}
+	// The URL ID overrides any model ID
+	role.RoleID = roleId
+
+	res, err := db.Model(&role).
+		Column("name", "description", "permissions", "updated_at").
+		Value("updated_at", "now()").
+		WherePK().
+		Where(inTenant).
+		Returning("*").
+		Update()
+	if err != nil {
+		c.Error(err)
+		status, message := roleWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, role)
+}
+
+// @Summary Delete a role by id
+// @Produce  json
+// @Param   id path int true "The id of the role to be deleted"
+// @Success 204 {string} nil
+// @Router /roles/:id [delete]
+func DeleteRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var roleId models.RoleID
+	if err := c.ShouldBindUri(&roleId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	res, err := db.Model(&models.Role{RoleID: roleId}).WherePK().Where(inTenant).Delete()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Role not found"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// Give the role to a user or group, which changes nothing if they have it.
+// The foreign keys keep the role and the assignee in the organization.
+func assignRole(c *gin.Context, assignment interface{}) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	if _, err := db.Model(assignment).Value("tenant_id", "?tenant_id").OnConflict("DO NOTHING").Insert(); err != nil {
+		c.Error(err)
+		if strings.Contains(err.Error(), database.FK_ERROR_CODE) {
+			c.JSON(http.StatusNotFound, gin.H{"message": "Role or assignee not found"})
+			return
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+func unassignRole(c *gin.Context, assignment interface{}) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	res, err := db.Model(assignment).WherePK().Where(inTenant).Delete()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Role is not assigned"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Give a role to a user
+// @Produce  json
+// @Param   id path int true "The id of the role"
+// @Param   user_id path string true "The public_id (or, for now, id) of the user"
+// @Success 204 {string} nil
+// @Router /roles/:id/users/:user_id [put]
+func AssignUserRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var roleUserId models.RoleUserID
+	if err := c.ShouldBindUri(&roleUserId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !bindUserParam(c, db, roleUserId.UserParam, &roleUserId.UserId) {
+		return
+	}
+	assignRole(c, &models.UserRole{RoleId: roleUserId.Id, UserId: roleUserId.UserId})
+}
+
+// @Summary Take a role from a user
+// @Produce  json
+// @Param   id path int true "The id of the role"
+// @Param   user_id path string true "The public_id (or, for now, id) of the user"
+// @Success 204 {string} nil
+// @Router /roles/:id/users/:user_id [delete]
+func UnassignUserRole(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var roleUserId models.RoleUserID
+	if err := c.ShouldBindUri(&roleUserId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	if !bindUserParam(c, db, roleUserId.UserParam, &roleUserId.UserId) {
+		return
+	}
+	unassignRole(c, &models.UserRole{RoleId: roleUserId.Id, UserId: roleUserId.UserId})
+}
+
+// @Summary Give a role to every user in a group, and in groups nested in it
+// @Produce  json
+// @Param   id path int true "The id of the role"
+// @Param   group_id path int true "The id of the group"
+// @Success 204 {string} nil
+// @Router /roles/:id/groups/:group_id [put]
+func AssignGroupRole(c *gin.Context) {
+	var roleGroupId models.RoleGroupID
+	if err := c.ShouldBindUri(&roleGroupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	assignRole(c, &models.GroupRole{RoleId: roleGroupId.Id, GroupId: roleGroupId.GroupId})
+}
+
+// @Summary Take a role from a group
+// @Produce  json
+// @Param   id path int true "The id of the role"
+// @Param   group_id path int true "The id of the group"
+// @Success 204 {string} nil
+// @Router /roles/:id/groups/:group_id [delete]
+func UnassignGroupRole(c *gin.Context) {
+	var roleGroupId models.RoleGroupID
+	if err := c.ShouldBindUri(&roleGroupId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+	unassignRole(c, &models.GroupRole{RoleId: roleGroupId.Id, GroupId: roleGroupId.GroupId})
+}
+
+// @Summary Retrieve the permissions a user has when signed in
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {array} string "The user's effective permissions"
+// @Router /users/:id/permissions [get]
+func RetrieveUserPermissions(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+	if _, ok := retrieveContactUser(c, db, userId.Id); !ok {
+		return
+	}
+
+	permissions, err := policy.UserPermissions(db, userId.Id, RolePermissions)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": permissions.List()})
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func setSessionDefaults() {
+	// How long a session lasts
+	viper.SetDefault("session_ttl", "24h")
+}
+
+// @Summary Sign in, creating a session
+// @Accept  json
+// @Produce  json
+// @Param   credentials body models.SessionIncoming true "The user's user_name and password"
+// @Success 201 {object} models.Session "The session, with the bearer token for the Authorization header"
+// @Failure 401 {string} nil "The user_name or password is wrong"
+// @Failure 403 {string} nil "The user isn't active"
+// @Router /sessions [post]
+func CreateSession(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get the request body
+	var sessionIncoming models.SessionIncoming
+	if err := c.BindJSON(&sessionIncoming); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var userAccount models.UserAccount
+	err := db.Model(&userAccount).Where("user_name = ?", sessionIncoming.UserName).Where(inTenant).Select()
+	if err == nil {
+		err = bcrypt.CompareHashAndPassword([]byte(userAccount.PasswordHash), []byte(sessionIncoming.Password))
+	}
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid user_name or password"})
+		return
+	}
+	if userAccount.Status != models.UserStatusActive {
+		c.JSON(http.StatusForbidden, gin.H{"message": "User Account is " + userAccount.Status})
+		return
+	}
+
+	token, tokenHash, err := auth.NewToken()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	session := &models.Session{
+		UserId:    userAccount.Id,
+		Token:     token,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(viper.GetDuration("session_ttl")),
+	}
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		if _, err := tx.Model(session).Insert(); err != nil {
+			return err
+		}
+		_, err := tx.Exec("UPDATE user_accounts SET last_login_at = now(), last_seen_at = now() WHERE id = ?", userAccount.Id)
+		return err
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, session)
+}
+
+// @Summary Sign out, ending the session the request is from
+// @Produce  json
+// @Param   Authorization header string true "Bearer token of the session"
+// @Success 204 {string} nil
+// @Router /sessions/current [delete]
+func DeleteCurrentSession(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	session := auth.CurrentSession(c)
+	if session == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"message": "Authentication required"})
+		return
+	}
+
+	if _, err := db.Model(session).WherePK().Delete(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
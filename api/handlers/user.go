@@ -3,23 +3,155 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
-	"github.com/davidwarshaw/golang-user-crud/api/database"
 	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/davidwarshaw/golang-user-crud/api/publicid"
 	"github.com/gin-gonic/gin"
 	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
 	"github.com/nyaruka/phonenumbers"
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// The columns a client can change with an update. user_name is changed by
+// renameUserAccount, to keep the old one.
+var updatableUserColumns = []string{
+	"first_name",
+	"middle_name",
+	"last_name",
+	"email",
+	"primary_phone_number",
+	"country",
+	"password_hash",
+	"version",
+	"email_verified_at",
+	"phone_verified_at",
+	"attributes",
+	"first_name_index",
+	"middle_name_index",
+	"last_name_index",
+	"email_index",
+	"primary_phone_number_index",
+}
+
+func setPublicIdDefaults() {
+	// Whether user routes still take the numeric id as well as the public_id,
+	// for clients that haven't moved over yet
+	viper.SetDefault("numeric_user_ids", true)
+}
+
+// The id of the user given by public_id or, while numeric_user_ids is on,
+// id. Returns 0 if it isn't a user in the tenant.
+func resolveUserID(db orm.DB, param string) (uint, error) {
+	if id, err := strconv.ParseUint(param, 10, 32); err == nil {
+		if !viper.GetBool("numeric_user_ids") {
+			return 0, nil
+		}
+		return uint(id), nil
+	}
+	if !publicid.Valid(param) {
+		return 0, nil
+	}
+
+	var id uint
+	_, err := db.QueryOne(pg.Scan(&id), "SELECT id FROM user_accounts WHERE public_id = ? AND "+inTenant, param)
+	if err == pg.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+// Resolve a user given in the URL, by public_id or, while numeric_user_ids is
+// on, id. Returns false, after writing the error response, if it isn't a user
+// in the tenant.
+func bindUserParam(c *gin.Context, db orm.DB, param string, id *uint) bool {
+	var err error
+	if *id, err = resolveUserID(db, param); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return false
+	}
+	if *id == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return false
+	}
+	return true
+}
+
+// Bind the user in the URL, which may be given by public_id or, while
+// numeric_user_ids is on, id. Returns false, after writing the error response,
+// if it isn't a user in the tenant.
+func bindUserID(c *gin.Context, db orm.DB, userId *models.UserID) bool {
+	if err := c.ShouldBindUri(userId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	return bindUserParam(c, db, userId.Param, &userId.Id)
+}
+
+// Insert the user account and its primary contacts
+func insertUserAccount(db orm.DB, userAccount *models.UserAccount) error {
+	if err := validateAttributes(db, userAccount.Attributes); err != nil {
+		return err
+	}
+	// Invited users choose theirs later
+	if userAccount.UserName != "" {
+		if err := checkUserNameAvailable(db, 0, userAccount.UserName); err != nil {
+			return err
+		}
+	}
+	publicId, err := publicid.New()
+	if err != nil {
+		return err
+	}
+	userAccount.PublicId = publicId
+	if _, err := db.Model(userAccount).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		return err
+	}
+	return syncPrimaryContacts(db, userAccount)
+}
+
+// Update the user account and its primary contacts, bumping its version. If
+// version is non-zero, the update only happens if the user account is still
+// at that version. A changed email or phone number is no longer verified.
+func updateUserAccount(db orm.DB, userAccount *models.UserAccount, version int) (orm.Result, error) {
+	if err := validateAttributes(db, userAccount.Attributes); err != nil {
+		return nil, err
+	}
+	query := db.Model(userAccount).
+		Column(updatableUserColumns...).
+		Value("version", "version + 1").
+		Value("email_verified_at", "CASE WHEN ? = ? THEN email_verified_at END", piiMatch("email", userAccount.Email)...).
+		Value("phone_verified_at", "CASE WHEN ? = ? THEN phone_verified_at END", piiMatch("primary_phone_number", userAccount.PrimaryPhoneNumber)...).
+		WherePK().
+		Where(inTenant).
+		Returning("public_id, version, email_verified_at, phone_verified_at, status, status_reason, status_changed_at, last_login_at, last_seen_at")
+	if version != 0 {
+		query = query.Where("version = ?", version)
+	}
+	res, err := query.Update()
+	if err != nil || res.RowsAffected() == 0 {
+		return res, err
+	}
+	if err := renameUserAccount(db, userAccount.Id, userAccount.UserName); err != nil {
+		return res, err
+	}
+	return res, syncPrimaryContacts(db, userAccount)
+}
+
 func normalizeIncomingUserAccount(userIncoming models.UserIncoming) (*models.UserAccount, error) {
 	// Parse the phone number
-	primaryPhoneNumber, err := phonenumbers.Parse(userIncoming.PrimaryPhoneNumber, "US")
+	region := phoneRegion(userIncoming.PhoneRegion, userIncoming.Country)
+	primaryPhoneNumber, err := parsePhoneNumber("primary_phone_number", userIncoming.PrimaryPhoneNumber, region)
 	if err != nil {
-		return &models.UserAccount{}, errors.New("primary_phone_number must be a valid US telephone number")
+		return &models.UserAccount{}, err
 	}
-	primaryPhoneNumberString := phonenumbers.Format(primaryPhoneNumber, phonenumbers.NATIONAL)
+	primaryPhoneNumberString := phonenumbers.Format(primaryPhoneNumber, phonenumbers.E164)
 
 	// Hash the password
 	password := []byte(userIncoming.Password)
@@ -34,51 +166,86 @@ func normalizeIncomingUserAccount(userIncoming models.UserIncoming) (*models.Use
 		PasswordHash: string(passwordHash),
 	}
 
-	// Use the reformatted phone number
+	// Store the phone number canonically
 	userAccount.PrimaryPhoneNumber = primaryPhoneNumberString
+	userAccount.Country = strings.ToUpper(userAccount.Country)
+	if userAccount.Attributes == nil {
+		userAccount.Attributes = map[string]interface{}{}
+	}
 
 	return userAccount, nil
 }
 
+// The API model for a user account
+func newUserOutgoing(userAccount *models.UserAccount) *models.UserOutgoing {
+	userOutgoing := &models.UserOutgoing{
+		UserID:          userAccount.UserID,
+		PublicId:        userAccount.PublicId,
+		UserBase:        userAccount.UserBase,
+		EmailVerifiedAt: userAccount.EmailVerifiedAt,
+		PhoneVerifiedAt: userAccount.PhoneVerifiedAt,
+		Status:          userAccount.Status,
+		StatusReason:    userAccount.StatusReason,
+		StatusChangedAt: userAccount.StatusChangedAt,
+		LastLoginAt:     userAccount.LastLoginAt,
+		LastSeenAt:      userAccount.LastSeenAt,
+	}
+
+	// Display the phone number for the user's country
+	region := phoneRegion(userAccount.Country)
+	userOutgoing.PrimaryPhoneNumberDisplay, userOutgoing.PrimaryPhoneNumberType = displayPhoneNumber(userAccount.PrimaryPhoneNumber, region)
+
+	return userOutgoing
+}
+
 // @Summary Retrieve all users
 // @Accept  json
 // @Produce  json
 // @Param   page      	query	int	false  "default: 1"
 // @Param   page_size   query	int	false  "default: 20"
+// @Param   user_name   query	string	false  "only users with this user_name"
+// @Param   first_name  query	string	false  "only users with this first_name"
+// @Param   last_name   query	string	false  "only users with this last_name"
+// @Param   email       query	string	false  "only users with this email"
+// @Param   status      query	string	false  "only users with this status: pending, active, suspended or disabled"
+// @Param   attr[name]  query	string	false  "only users with this value of the attribute, e.g. attr[department]=sales"
+// @Param   group       query	int	false  "only users in this group, directly or through a nested group"
+// @Param   fields      query	string	false  "only these fields of each user, comma separated, e.g. id,user_name"
+// @Param   expand      query	string	false  "include related resources with each user: groups"
 // @Success 200 {array} models.UserOutgoing	"The user entities"
 // @Router /users [get]
 func RetrieveAllUsers(c *gin.Context) {
 	db := c.MustGet("DB").(*pg.DB)
 
 	// Get pagination
-	var paginationIncoming models.Pagination
-	if err := c.ShouldBindQuery(&paginationIncoming); err != nil {
-		c.Error(err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	paginationIncoming, offset, ok := bindPagination(c)
+	if !ok {
 		return
 	}
 
-	// Set default pagination and offset
-	if paginationIncoming.Page == 0 {
-		paginationIncoming.Page = 1
+	// Get filters
+	filter, ok := bindUserFilter(c, db)
+	if !ok {
+		return
 	}
-	if paginationIncoming.PageSize == 0 {
-		paginationIncoming.PageSize = 20
+
+	// Get the fields to respond with
+	projection, ok := bindUserProjection(c)
+	if !ok {
+		return
 	}
-	offset := (paginationIncoming.Page - 1) * paginationIncoming.PageSize
 
 	// Retrieve all the user accounts
 	var userAccounts []models.UserAccount
-	db.Model(&userAccounts).Limit(paginationIncoming.PageSize).Offset(offset).Select()
+	query := projection.selectColumns(db.Model(&userAccounts))
+	filterUserAccounts(query, filter).Limit(paginationIncoming.PageSize).Offset(offset).Select()
 
 	// Transform models
-	var usersOutgoing []models.UserOutgoing
-	for _, userAccount := range userAccounts {
-		userOutgoing := &models.UserOutgoing{
-			UserID:   userAccount.UserID,
-			UserBase: userAccount.UserBase,
-		}
-		usersOutgoing = append(usersOutgoing, *userOutgoing)
+	usersOutgoing, err := projection.render(c, db, userAccounts)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"data": usersOutgoing, "pagination": paginationIncoming})
@@ -88,7 +255,9 @@ func RetrieveAllUsers(c *gin.Context) {
 // @Accept  json
 // @Produce  json
 // @Param   user      	body	models.UserIncoming	true "The user data to be created"
+// @Param   Idempotency-Key header string false "Makes retries replay the original response"
 // @Success 201 {body} models.UserOutgoing
+// @Failure 422 {string} nil "The Idempotency-Key was used with a different request"
 // @Router /users [post]
 func CreateUser(c *gin.Context) {
 	db := c.MustGet("DB").(*pg.DB)
@@ -108,37 +277,50 @@ func CreateUser(c *gin.Context) {
 	}
 
 	// Save to the DB
-	if _, err = db.Model(userAccount).Insert(); err != nil {
-		c.Error(err)
-		if strings.Contains(err.Error(), database.PK_ERROR_CODE) {
-			c.JSON(http.StatusBadRequest, gin.H{"message": "user_name already exists"})
-			return
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := restrictPrivateAttributes(tx, userAccount, policy.Allowed(c, policy.AttributesPrivate)); err != nil {
+			return err
 		}
-		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		if err := insertUserAccount(tx, userAccount); err != nil {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventCreated, userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
 		return
 	}
 
-	userOutgoing := &models.UserOutgoing{
-		UserID:   userAccount.UserID,
-		UserBase: userAccount.UserBase,
-	}
+	userOutgoing := newUserOutgoing(userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
 
+	c.Header("ETag", userETag(userAccount))
 	c.JSON(http.StatusCreated, userOutgoing)
 }
 
 // @Summary Retrieve a user by id
 // @Produce  json
-// @Param   id path int true "The id of the user to be retrieved"
+// @Param   id path string true "The public_id (or, for now, id) of the user to be retrieved"
+// @Param   fields query string false "only these fields of the user, comma separated, e.g. id,user_name"
+// @Param   expand query string false "include related resources with the user: groups"
+// @Param   If-None-Match header string false "ETag of a cached representation"
 // @Success 200 {object} models.UserOutgoing "The user entity for that id"
+// @Success 304 {string} nil "The cached representation is current"
 // @Router /users/:id [get]
 func RetrieveUser(c *gin.Context) {
 	db := c.MustGet("DB").(*pg.DB)
 
 	// Get URL param
 	var userId models.UserID
-	if err := c.ShouldBindUri(&userId); err != nil {
-		c.Error(err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the fields to respond with
+	projection, ok := bindUserProjection(c)
+	if !ok {
 		return
 	}
 
@@ -146,35 +328,30 @@ func RetrieveUser(c *gin.Context) {
 	var userAccount models.UserAccount
 	userAccount.UserID = userId
 
-	if err := db.Model(&userAccount).WherePK().Select(); err != nil {
+	if err := projection.selectColumns(db.Model(&userAccount)).WherePK().Where(inTenant).Select(); err != nil {
 		c.Error(err)
 		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
 		return
 	}
 
-	userOutgoing := &models.UserOutgoing{
-		UserID:   userAccount.UserID,
-		UserBase: userAccount.UserBase,
-	}
-
-	c.JSON(http.StatusOK, userOutgoing)
+	respondWithUser(c, db, projection, &userAccount)
 }
 
 // @Summary Update a user by id
 // @Accept  json
 // @Produce  json
-// @Param   id path int true "The id of the user to be updated"
+// @Param   id path string true "The public_id (or, for now, id) of the user to be updated"
 // @Param   user      	body	models.UserIncoming	true "The user data to be updated"
+// @Param   If-Match header string false "ETag of the version being updated"
 // @Success 200 {object} models.UserOutgoing "The updated user entity for that id"
+// @Failure 412 {string} nil "The user was modified since the ETag was retrieved"
 // @Router /users/:id [put]
 func UpdateUser(c *gin.Context) {
 	db := c.MustGet("DB").(*pg.DB)
 
 	// Get URL param
 	var userId models.UserID
-	if err := c.ShouldBindUri(&userId); err != nil {
-		c.Error(err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	if !bindUserID(c, db, &userId) {
 		return
 	}
 
@@ -194,45 +371,96 @@ func UpdateUser(c *gin.Context) {
 	// The URL ID overrides any model ID
 	userAccount.UserID = userId
 
-	if _, err := db.Model(userAccount).WherePK().Update(); err != nil {
+	// Check the client is updating the version it thinks it is
+	var currentUserAccount models.UserAccount
+	currentUserAccount.UserID = userId
+	if err := db.Model(&currentUserAccount).WherePK().Where(inTenant).Select(); err != nil {
 		c.Error(err)
 		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
 		return
 	}
+	if !checkIfMatch(c, &currentUserAccount) {
+		return
+	}
 
-	userOutgoing := &models.UserOutgoing{
-		UserID:   userAccount.UserID,
-		UserBase: userAccount.UserBase,
+	// Only update if nobody else has since the check
+	var res orm.Result
+	err = db.RunInTransaction(func(tx *pg.Tx) error {
+		if err := restrictPrivateAttributes(tx, userAccount, policy.Allowed(c, policy.AttributesPrivate)); err != nil {
+			return err
+		}
+		res, err = updateUserAccount(tx, userAccount, currentUserAccount.Version)
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		status, message := userWriteError(err)
+		c.JSON(status, gin.H{"message": message})
+		return
 	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+		return
+	}
+
+	c.Header("ETag", userETag(userAccount))
+
+	userOutgoing := newUserOutgoing(userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
 
 	c.JSON(http.StatusOK, userOutgoing)
 }
 
 // @Summary Delete a user by id
 // @Produce  json
-// @Param   id path int true "The id of the user to be deleted"
+// @Param   id path string true "The public_id (or, for now, id) of the user to be deleted"
+// @Param   If-Match header string false "ETag of the version being deleted"
 // @Success 204 {string} nil
+// @Failure 412 {string} nil "The user was modified since the ETag was retrieved"
 // @Router /users/:id [delete]
 func DeleteUser(c *gin.Context) {
 	db := c.MustGet("DB").(*pg.DB)
 
 	// Get URL param
 	var userId models.UserID
-	if err := c.ShouldBindUri(&userId); err != nil {
-		c.Error(err)
-		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+	if !bindUserID(c, db, &userId) {
 		return
 	}
 
-	// Retrieve all the user accounts
+	// Retrieve the user account to check the version
 	var userAccount models.UserAccount
 	userAccount.UserID = userId
 
-	if _, err := db.Model(&userAccount).WherePK().Delete(); err != nil {
+	if err := db.Model(&userAccount).WherePK().Where(inTenant).Select(); err != nil {
 		c.Error(err)
 		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
 		return
 	}
+	if !checkIfMatch(c, &userAccount) {
+		return
+	}
+
+	var res orm.Result
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var err error
+		res, err = tx.Model(&userAccount).WherePK().Where("version = ?", userAccount.Version).Delete()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		return recordUserEvent(tx, models.UserEventDeleted, &userAccount)
+	})
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+		return
+	}
 
 	c.JSON(http.StatusNoContent, gin.H{})
 }
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+// Names nobody can have, as they'd pass for the service's own. More can be
+// added with reserved_user_names.
+var reservedUserNames = []string{
+	"abuse",
+	"admin",
+	"administrator",
+	"anonymous",
+	"api",
+	"help",
+	"hostmaster",
+	"info",
+	"mail",
+	"moderator",
+	"noc",
+	"noreply",
+	"null",
+	"official",
+	"postmaster",
+	"root",
+	"security",
+	"staff",
+	"support",
+	"system",
+	"undefined",
+	"webmaster",
+}
+
+func setUserNameDefaults() {
+	// How long an old user_name is kept for its user after they change it
+	viper.SetDefault("user_name_cooldown", "720h")
+	// Comma separated, added to the built in reserved names
+	viper.SetDefault("reserved_user_names", "")
+}
+
+// A user_name that can't be claimed
+type userNameError struct {
+	status  int
+	message string
+}
+
+func (e *userNameError) Error() string {
+	return e.message
+}
+
+func isReservedUserName(userName string) bool {
+	userName = strings.ToLower(userName)
+	for _, reserved := range reservedUserNames {
+		if userName == reserved {
+			return true
+		}
+	}
+	for _, reserved := range strings.Split(viper.GetString("reserved_user_names"), ",") {
+		if userName == strings.ToLower(strings.TrimSpace(reserved)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check the user can claim the user_name: it isn't reserved, and isn't
+// another user's old name, in any case, within its cooldown. A userId of 0 is
+// a user yet to be inserted.
+func checkUserNameAvailable(db orm.DB, userId uint, userName string) error {
+	if isReservedUserName(userName) {
+		return &userNameError{http.StatusBadRequest, "user_name is reserved"}
+	}
+	held, err := db.Model((*models.UserNameChange)(nil)).
+		Where(inTenant).
+		Where("lower(user_name) = lower(?)", userName).
+		Where("user_id != ?", userId).
+		Where("reserved_until > now()").
+		Exists()
+	if err != nil {
+		return err
+	}
+	if held {
+		return &userNameError{http.StatusBadRequest, "user_name was changed recently and is reserved"}
+	}
+	return nil
+}
+
+// Change the user's user_name if it's different, keeping the old one in its
+// history. Called as part of an update of the user account, so doesn't bump
+// its version.
+func renameUserAccount(db orm.DB, userId uint, userName string) error {
+	var current string
+	_, err := db.QueryOne(pg.Scan(&current),
+		"SELECT coalesce(user_name, '') FROM user_accounts WHERE id = ? AND "+inTenant+" FOR UPDATE", userId)
+	if err != nil || current == userName {
+		return err
+	}
+	if err := checkUserNameAvailable(db, userId, userName); err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("UPDATE user_accounts SET user_name = ? WHERE id = ?", userName, userId); err != nil {
+		return err
+	}
+	// An invited user has no name to keep
+	if current == "" {
+		return nil
+	}
+	change := &models.UserNameChange{
+		UserId:        userId,
+		UserName:      current,
+		ChangedAt:     time.Now(),
+		ReservedUntil: time.Now().Add(viper.GetDuration("user_name_cooldown")),
+	}
+	_, err = db.Model(change).Value("tenant_id", "?tenant_id").Insert()
+	return err
+}
+
+// @Summary Change a user's user_name, keeping the old one reserved for them for the user_name_cooldown
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   rename body models.UserRename true "The new user_name"
+// @Param   If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.UserOutgoing "The renamed user"
+// @Failure 400 {string} nil "The user_name is taken, reserved, or another user's recent name"
+// @Router /users/:id/rename [post]
+func RenameUser(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the request body
+	var rename models.UserRename
+	if err := c.BindJSON(&rename); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var userAccount models.UserAccount
+	userAccount.UserID = userId
+	if err := db.Model(&userAccount).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return
+	}
+	if !checkIfMatch(c, &userAccount) {
+		return
+	}
+
+	// Only rename if nobody else has changed the user since the check
+	var res orm.Result
+	if userAccount.UserName != rename.UserName {
+		err := db.RunInTransaction(func(tx *pg.Tx) error {
+			var err error
+			res, err = tx.Model(&userAccount).
+				Set("version = version + 1").
+				WherePK().
+				Where("version = ?", userAccount.Version).
+				Returning("version").
+				Update()
+			if err != nil || res.RowsAffected() == 0 {
+				return err
+			}
+			if err := renameUserAccount(tx, userAccount.Id, rename.UserName); err != nil {
+				return err
+			}
+			userAccount.UserName = rename.UserName
+			return recordUserEvent(tx, models.UserEventUpdated, &userAccount)
+		})
+		if err != nil {
+			c.Error(err)
+			status, message := userWriteError(err)
+			c.JSON(status, gin.H{"message": message})
+			return
+		}
+		if res.RowsAffected() == 0 {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+			return
+		}
+	}
+
+	c.Header("ETag", userETag(&userAccount))
+
+	userOutgoing := newUserOutgoing(&userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.JSON(http.StatusOK, userOutgoing)
+}
+
+// @Summary Retrieve the user_names a user had before their current one
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Success 200 {array} models.UserNameChange "The old user_names, most recent first"
+// @Router /users/:id/names [get]
+func RetrieveUserNameChanges(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	if _, ok := retrieveContactUser(c, db, userId.Id); !ok {
+		return
+	}
+
+	changes := []models.UserNameChange{}
+	err := db.Model(&changes).
+		Where("user_id = ?", userId.Id).
+		Where(inTenant).
+		Order("changed_at DESC", "id DESC").
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": changes})
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+)
+
+// Set the user's status, if they're still at the version, and sign them out
+// unless they're active
+func setUserStatus(db *pg.DB, userAccount *models.UserAccount, status string, reason string) (orm.Result, error) {
+	var res orm.Result
+	err := db.RunInTransaction(func(tx *pg.Tx) error {
+		var err error
+		res, err = tx.Model(userAccount).
+			Set("status = ?, status_reason = ?, status_changed_at = now(), version = version + 1", status, reason).
+			WherePK().
+			Where("version = ?", userAccount.Version).
+			Returning("*").
+			Update()
+		if err != nil || res.RowsAffected() == 0 {
+			return err
+		}
+		if status != models.UserStatusActive {
+			if _, err := tx.Model((*models.Session)(nil)).Where("user_id = ?", userAccount.Id).Delete(); err != nil {
+				return err
+			}
+		}
+		return recordUserEvent(tx, models.UserEventUpdated, userAccount)
+	})
+	return res, err
+}
+
+// Change the user's status, if their current status allows the action. Users
+// who can no longer sign in are signed out.
+func changeUserStatus(c *gin.Context, actionName string) {
+	db := c.MustGet("DB").(*pg.DB)
+	action := models.UserStatusActions[actionName]
+
+	// Get URL param
+	var userId models.UserID
+	if !bindUserID(c, db, &userId) {
+		return
+	}
+
+	// Get the request body
+	var change models.UserStatusChange
+	if err := c.BindJSON(&change); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var userAccount models.UserAccount
+	userAccount.UserID = userId
+	if err := db.Model(&userAccount).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "User Account not found"})
+		return
+	}
+	if !checkIfMatch(c, &userAccount) {
+		return
+	}
+	if !action.AllowedFrom(userAccount.Status) {
+		c.JSON(http.StatusConflict, gin.H{"message": fmt.Sprintf("can't %s a %s User Account", actionName, userAccount.Status)})
+		return
+	}
+
+	// Only change it if nobody else has since the check
+	res, err := setUserStatus(db, &userAccount, action.Status, change.Reason)
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"message": "User Account has been modified"})
+		return
+	}
+
+	c.Header("ETag", userETag(&userAccount))
+
+	userOutgoing := newUserOutgoing(&userAccount)
+	redactPrivateAttributes(c, db, userOutgoing)
+
+	c.JSON(http.StatusOK, userOutgoing)
+}
+
+// @Summary Suspend an active user, who can't sign in until reactivated
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   change body models.UserStatusChange true "Why the user is suspended"
+// @Param   If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.UserOutgoing "The suspended user"
+// @Failure 409 {string} nil "The user isn't active"
+// @Router /users/:id/suspend [post]
+func SuspendUser(c *gin.Context) {
+	changeUserStatus(c, "suspend")
+}
+
+// @Summary Reactivate a suspended user
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   change body models.UserStatusChange true "Why the user is reactivated"
+// @Param   If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.UserOutgoing "The reactivated user"
+// @Failure 409 {string} nil "The user isn't suspended"
+// @Router /users/:id/reactivate [post]
+func ReactivateUser(c *gin.Context) {
+	changeUserStatus(c, "reactivate")
+}
+
+// @Summary Disable a user for good
+// @Accept  json
+// @Produce  json
+// @Param   id path string true "The public_id (or, for now, id) of the user"
+// @Param   change body models.UserStatusChange true "Why the user is disabled"
+// @Param   If-Match header string false "ETag of the version being changed"
+// @Success 200 {object} models.UserOutgoing "The disabled user"
+// @Failure 409 {string} nil "The user is already disabled"
+// @Router /users/:id/disable [post]
+func DisableUser(c *gin.Context) {
+	changeUserStatus(c, "disable")
+}
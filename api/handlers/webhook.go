@@ -0,0 +1,478 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+func setWebhookDefaults() {
+	// How many times a delivery is attempted before it's dead
+	viper.SetDefault("webhook_max_attempts", 10)
+	// How long after the first failed attempt a delivery is retried. The wait
+	// doubles after each attempt after that.
+	viper.SetDefault("webhook_retry_interval", "30s")
+	// How often the queue is checked for deliveries that are due
+	viper.SetDefault("webhook_poll_interval", "5s")
+}
+
+func isWebhookEvent(event string) bool {
+	for _, known := range models.UserEventTypes {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Get a webhook from the request body, checking its URL is http(s) and its
+// events are real
+func bindWebhook(c *gin.Context, incoming *models.WebhookIncoming) bool {
+	if err := c.BindJSON(incoming); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	if u, err := url.Parse(incoming.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "url must be http or https"})
+		return false
+	}
+	if incoming.Events == nil {
+		incoming.Events = []string{}
+	}
+	for _, event := range incoming.Events {
+		if !isWebhookEvent(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"message": "unknown event " + event})
+			return false
+		}
+	}
+	return true
+}
+
+// Bind the webhook in the URL. Returns false, after writing the error
+// response, if it isn't a webhook in the tenant.
+func bindWebhookID(c *gin.Context, db orm.DB, webhookId *models.WebhookID) bool {
+	if err := c.ShouldBindUri(webhookId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return false
+	}
+	exists, err := db.Model((*models.Webhook)(nil)).Where("id = ?", webhookId.Id).Where(inTenant).Exists()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return false
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+		return false
+	}
+	return true
+}
+
+// Queue the event for each of the tenant's webhooks that wants it. It's
+// called by recordUserEvent, in the transaction that makes the change.
+func enqueueWebhookEvent(db orm.DB, event *models.UserEvent) error {
+	var webhookIds []uint
+	err := db.Model((*models.Webhook)(nil)).
+		Column("id").
+		Where(inTenant).
+		Where("NOT disabled").
+		Where("events = '{}' OR ? = ANY(events)", event.Type).
+		Select(&webhookIds)
+	if err != nil || len(webhookIds) == 0 {
+		return err
+	}
+
+	payload := &models.WebhookPayload{
+		Id:        event.Id,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Data,
+	}
+	deliveries := make([]models.WebhookDelivery, len(webhookIds))
+	for i, webhookId := range webhookIds {
+		deliveries[i] = models.WebhookDelivery{
+			WebhookId: webhookId,
+			EventId:   event.Id,
+			Event:     event.Type,
+			Payload:   payload,
+			Status:    models.WebhookDeliveryPending,
+		}
+	}
+	_, err = db.Model(&deliveries).Value("tenant_id", "?tenant_id").Value("next_attempt_at", "now()").Insert()
+	return err
+}
+
+// How long to wait before the next attempt at a delivery, after it's failed
+// the number of times
+func webhookRetryDelay(attempts int) time.Duration {
+	delay := viper.GetDuration("webhook_retry_interval")
+	for i := 1; i < attempts && delay < 24*time.Hour; i++ {
+		delay *= 2
+	}
+	return delay
+}
+
+// Claim the next delivery that's due and attempt it. Claiming pushes back its
+// next attempt by longer than an attempt can take, so other processes leave
+// it be, and it's retried if this one dies sending it. Returns whether there
+// was one.
+func deliverNextWebhook(db *pg.DB, sender *webhook.Sender) (bool, error) {
+	due := db.Model((*models.WebhookDelivery)(nil)).
+		Column("id").
+		Where("status = ?", models.WebhookDeliveryPending).
+		Where("next_attempt_at <= now()").
+		Order("next_attempt_at").
+		Limit(1).
+		For("UPDATE SKIP LOCKED")
+	lease := sender.Client.Timeout + time.Minute
+
+	var delivery models.WebhookDelivery
+	res, err := db.Model(&delivery).
+		Set("next_attempt_at = now() + make_interval(secs => ?)", lease.Seconds()).
+		Where("id = (?)", due).
+		Returning("*").
+		Update()
+	if err != nil || res.RowsAffected() == 0 {
+		return false, err
+	}
+
+	var hook models.Webhook
+	if err := db.Model(&hook).Where("id = ?", delivery.WebhookId).Select(); err != nil {
+		return true, err
+	}
+	payload, err := json.Marshal(delivery.Payload)
+	if err != nil {
+		return true, err
+	}
+	status, sendErr := sender.Send(webhook.Delivery{
+		Id:      delivery.Id,
+		Event:   delivery.Event,
+		URL:     hook.Url,
+		Secret:  hook.Secret,
+		Payload: payload,
+	})
+	// Not where a webhook that isn't allowed resolved to
+	if errors.Is(sendErr, webhook.ErrPrivateAddress) {
+		sendErr = webhook.ErrPrivateAddress
+	}
+
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = &now
+	delivery.ResponseStatus = status
+	delivery.LastError = ""
+	switch {
+	case sendErr == nil:
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.DeliveredAt = &now
+		delivery.NextAttemptAt = nil
+	case delivery.Attempts >= viper.GetInt("webhook_max_attempts"):
+		delivery.Status = models.WebhookDeliveryDead
+		delivery.LastError = sendErr.Error()
+		delivery.NextAttemptAt = nil
+	default:
+		delivery.LastError = sendErr.Error()
+		nextAttemptAt := now.Add(webhookRetryDelay(delivery.Attempts))
+		delivery.NextAttemptAt = &nextAttemptAt
+	}
+	_, err = db.Model(&delivery).
+		Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "last_error", "delivered_at").
+		WherePK().
+		Update()
+	return true, err
+}
+
+// Attempt every delivery that's due, returning how many were attempted
+func DeliverWebhooks(db *pg.DB, sender *webhook.Sender) (int, error) {
+	attempted := 0
+	for {
+		delivered, err := deliverNextWebhook(db, sender)
+		if err != nil || !delivered {
+			return attempted, err
+		}
+		attempted++
+	}
+}
+
+// Attempt deliveries as they come due, forever. Every API process runs this,
+// and each delivery is claimed by one of them.
+func RunWebhookDeliverer(db *pg.DB, sender *webhook.Sender) {
+	ticker := time.NewTicker(viper.GetDuration("webhook_poll_interval"))
+	defer ticker.Stop()
+
+	for {
+		if _, err := DeliverWebhooks(db, sender); err != nil {
+			log.Printf("Delivering webhooks failed: %s", err)
+		}
+		<-ticker.C
+	}
+}
+
+// @Summary Retrieve all webhooks
+// @Produce  json
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Success 200 {array} models.Webhook "The webhooks"
+// @Router /webhooks [get]
+func RetrieveAllWebhooks(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get pagination
+	paginationIncoming, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+
+	webhooks := []models.Webhook{}
+	err := db.Model(&webhooks).
+		Where(inTenant).
+		Order("id").
+		Limit(paginationIncoming.PageSize).
+		Offset(offset).
+		Select()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": webhooks, "pagination": paginationIncoming})
+}
+
+// @Summary Create a webhook, which user events are posted to
+// @Accept  json
+// @Produce  json
+// @Param   webhook body models.WebhookIncoming true "The webhook to be created"
+// @Success 201 {object} models.Webhook "The webhook, with the secret its payloads are signed with"
+// @Router /webhooks [post]
+func CreateWebhook(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	var incoming models.WebhookIncoming
+	if !bindWebhook(c, &incoming) {
+		return
+	}
+	hook := models.Webhook{WebhookBase: incoming.WebhookBase, Secret: incoming.Secret}
+	if hook.Secret == "" {
+		secret, err := webhook.NewSecret()
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		hook.Secret = secret
+	}
+
+	if _, err := db.Model(&hook).Value("tenant_id", "?tenant_id").Insert(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, hook)
+}
+
+// @Summary Retrieve a webhook by id
+// @Produce  json
+// @Param   id path int true "The id of the webhook to be retrieved"
+// @Success 200 {object} models.Webhook "The webhook for that id"
+// @Router /webhooks/:id [get]
+func RetrieveWebhook(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var webhookId models.WebhookID
+	if err := c.ShouldBindUri(&webhookId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var hook models.Webhook
+	hook.WebhookID = webhookId
+	if err := db.Model(&hook).WherePK().Where(inTenant).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+		return
+	}
+	hook.Secret = ""
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// @Summary Update a webhook by id
+// @Accept  json
+// @Produce  json
+// @Param   id path int true "The id of the webhook to be updated"
+// @Param   webhook body models.WebhookIncoming true "The webhook data to be updated. The secret is kept unless a new one is given."
+// @Success 200 {object} models.Webhook "The updated webhook"
+// @Router /webhooks/:id [put]
+func UpdateWebhook(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var webhookId models.WebhookID
+	if err := c.ShouldBindUri(&webhookId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var incoming models.WebhookIncoming
+	if !bindWebhook(c, &incoming) {
+		return
+	}
+	// The URL ID overrides any model ID
+	hook := models.Webhook{WebhookID: webhookId, WebhookBase: incoming.WebhookBase}
+
+	res, err := db.Model(&hook).
+		Column("url", "events", "description", "disabled", "secret", "updated_at").
+		Value("secret", "COALESCE(NULLIF(?, ''), secret)", incoming.Secret).
+		Value("updated_at", "now()").
+		WherePK().
+		Where(inTenant).
+		Returning("*").
+		Update()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+		return
+	}
+	hook.Secret = ""
+
+	c.JSON(http.StatusOK, hook)
+}
+
+// @Summary Delete a webhook by id, and its deliveries
+// @Produce  json
+// @Param   id path int true "The id of the webhook to be deleted"
+// @Success 204 {string} nil
+// @Router /webhooks/:id [delete]
+func DeleteWebhook(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var webhookId models.WebhookID
+	if err := c.ShouldBindUri(&webhookId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	res, err := db.Model(&models.Webhook{WebhookID: webhookId}).WherePK().Where(inTenant).Delete()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook not found"})
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// @Summary Retrieve the deliveries of events to a webhook, newest first
+// @Produce  json
+// @Param   id path int true "The id of the webhook"
+// @Param   page      	query	int	false  "default: 1"
+// @Param   page_size   query	int	false  "default: 20"
+// @Param   status      query	string	false  "only deliveries with this status: pending, delivered or dead"
+// @Success 200 {array} models.WebhookDelivery "The deliveries"
+// @Router /webhooks/:id/deliveries [get]
+func RetrieveWebhookDeliveries(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL param
+	var webhookId models.WebhookID
+	if !bindWebhookID(c, db, &webhookId) {
+		return
+	}
+
+	// Get pagination
+	pagination, offset, ok := bindPagination(c)
+	if !ok {
+		return
+	}
+
+	// Get filters
+	var filter models.WebhookDeliveryFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	query := db.Model(&deliveries).Where("webhook_id = ?", webhookId.Id).Where(inTenant)
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if err := query.Order("id DESC").Limit(pagination.PageSize).Offset(offset).Select(); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": deliveries, "pagination": pagination})
+}
+
+// @Summary Queue a delivery to be sent again now, with all its attempts, e.g. once it's dead
+// @Produce  json
+// @Param   id path int true "The id of the webhook"
+// @Param   delivery_id path int true "The id of the delivery"
+// @Success 200 {object} models.WebhookDelivery "The delivery, pending"
+// @Router /webhooks/:id/deliveries/:delivery_id/redeliver [post]
+func RedeliverWebhookDelivery(c *gin.Context) {
+	db := c.MustGet("DB").(*pg.DB)
+
+	// Get URL params
+	var deliveryId models.WebhookDeliveryID
+	if err := c.ShouldBindUri(&deliveryId); err != nil {
+		c.Error(err)
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	var delivery models.WebhookDelivery
+	res, err := db.Model(&delivery).
+		Set("status = ?", models.WebhookDeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = now()").
+		Where("id = ?", deliveryId.DeliveryId).
+		Where("webhook_id = ?", deliveryId.Id).
+		Where(inTenant).
+		Returning("*").
+		Update()
+	if err != nil {
+		c.Error(err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+		return
+	}
+	if res.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Webhook delivery not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}
@@ -0,0 +1,178 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+const HEADER = "Idempotency-Key"
+
+// Captures the response so it can be stored for replay
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func fingerprint(c *gin.Context, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "?" + c.Request.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Who a key belongs to, so one caller can't replay another's response.
+// Anonymous callers can't be told apart, so their keys aren't scoped to
+// anyone, and the second return is false.
+func callerScope(c *gin.Context) (string, bool) {
+	if caller := auth.Caller(c); caller != nil {
+		return fmt.Sprintf("user:%d", caller.Id), true
+	}
+	if auth.IsAdmin(c) {
+		return "admin", true
+	}
+	return "", false
+}
+
+func replay(c *gin.Context, idempotencyKey *models.IdempotencyKey) {
+	for name, values := range idempotencyKey.ResponseHeaders {
+		for _, value := range values {
+			c.Writer.Header().Add(name, value)
+		}
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(idempotencyKey.ResponseStatus)
+	c.Writer.Write(idempotencyKey.ResponseBody)
+	c.Abort()
+}
+
+// Register the defaults of the idempotency config, once at startup
+func SetDefaults() {
+	// How long a stored response is replayed for
+	viper.SetDefault("idempotency_ttl", "24h")
+}
+
+// Middleware makes POSTs carrying an Idempotency-Key header safe to retry. The
+// first response for a key is stored and replayed for later requests with the
+// same key and request. Reusing a key with a different request is rejected.
+// Keys are per organization and caller, and ignored for anonymous callers.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HEADER)
+		scope, scoped := callerScope(c)
+		if c.Request.Method != http.MethodPost || key == "" || !scoped {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		db := c.MustGet("DB").(*pg.DB)
+
+		// Read the body for the fingerprint, leaving it for the handler
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+			return
+		}
+		c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+		// Expired keys can be reused
+		if _, err := db.Model((*models.IdempotencyKey)(nil)).
+			Where("idempotency_key = ?", key).
+			Where("caller = ?", scope).
+			Where("tenant_id = ?tenant_id").
+			Where("expires_at < CURRENT_TIMESTAMP").
+			Delete(); err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+
+		// Claim the key. If someone else already has, replay their response.
+		idempotencyKey := &models.IdempotencyKey{
+			IdempotencyKey: key,
+			Caller:         scope,
+			Fingerprint:    fingerprint(c, body),
+			ExpiresAt:      time.Now().Add(viper.GetDuration("idempotency_ttl")),
+		}
+		res, err := db.Model(idempotencyKey).Value("tenant_id", "?tenant_id").OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		if res.RowsAffected() == 0 {
+			var existing models.IdempotencyKey
+			existing.IdempotencyKey = key
+			existing.Caller = idempotencyKey.Caller
+			if err := db.Model(&existing).WherePK().Where("tenant_id = ?tenant_id").Select(); err != nil {
+				c.Error(err)
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+				return
+			}
+			if existing.Fingerprint != idempotencyKey.Fingerprint {
+				c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"message": "Idempotency-Key has already been used with a different request"})
+				return
+			}
+			if existing.ResponseStatus == 0 {
+				c.AbortWithStatusJSON(http.StatusConflict, gin.H{"message": "a request with this Idempotency-Key is in progress"})
+				return
+			}
+			replay(c, &existing)
+			return
+		}
+
+		// The claim is released unless the response is stored, so a handler
+		// that panics doesn't leave the key in progress until it expires
+		stored := false
+		defer func() {
+			if !stored {
+				db.Model(idempotencyKey).WherePK().Where("tenant_id = ?tenant_id").Delete()
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+		c.Writer = recorder
+		c.Next()
+
+		// Server errors are not stored, so the client can retry them
+		if c.Writer.Status() >= http.StatusInternalServerError {
+			return
+		}
+		idempotencyKey.ResponseStatus = c.Writer.Status()
+		idempotencyKey.ResponseHeaders = c.Writer.Header()
+		idempotencyKey.ResponseBody = recorder.body.Bytes()
+		if _, err := db.Model(idempotencyKey).
+			Column("response_status", "response_headers", "response_body").
+			WherePK().
+			Where("tenant_id = ?tenant_id").
+			Update(); err != nil {
+			c.Error(err)
+			return
+		}
+		stored = true
+	}
+}
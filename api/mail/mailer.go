@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// Mailer delivers email messages to addresses
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+// LogMailer writes messages to the log instead of sending them, for dev
+type LogMailer struct{}
+
+func (LogMailer) Send(to string, subject string, body string) error {
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
+
+// A message as FileMailer writes it
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// FileMailer appends messages to a file instead of sending them, one JSON
+// Message per line. For tests.
+type FileMailer struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (m *FileMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return json.NewEncoder(file).Encode(Message{To: to, Subject: subject, Body: body})
+}
+
+// Register the defaults of the mail config, once at startup
+func SetDefaults() {
+	viper.SetDefault("mailer", "log")
+	viper.SetDefault("mail_file_path", "mail.log")
+}
+
+// The mailer chosen by the mailer config
+func NewMailer() Mailer {
+	switch viper.GetString("mailer") {
+	case "file":
+		return &FileMailer{Path: viper.GetString("mail_file_path")}
+	default:
+		return LogMailer{}
+	}
+}
+
+func Middleware() gin.HandlerFunc {
+	mailer := NewMailer()
+
+	return func(c *gin.Context) {
+		c.Set("Mailer", mailer)
+	}
+}
@@ -1,11 +1,182 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/broker"
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
 	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/davidwarshaw/golang-user-crud/api/webhook"
+	"github.com/go-pg/pg"
 	"github.com/spf13/viper"
 )
 
+// The organization with the slug
+func findOrganization(db *pg.DB, slug string) (*models.Organization, error) {
+	var organization models.Organization
+	if err := db.Model(&organization).Where("slug = ?", slug).Select(); err != nil {
+		if err == pg.ErrNoRows {
+			return nil, fmt.Errorf("organization %s not found", slug)
+		}
+		return nil, err
+	}
+	return &organization, nil
+}
+
+// Import users into an organization from a CSV or NDJSON file, printing the
+// report, with:
+// go run main.go import [-tenant slug] [-format csv|ndjson] [-dry-run] [-map column=field,...] file
+func importUsers(args []string) int {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	slug := flags.String("tenant", "default", "the slug of the organization to import into")
+	format := flags.String("format", "", "csv or ndjson (default: from the file extension)")
+	dryRun := flags.Bool("dry-run", false, "validate and report without creating users")
+	mappingFlag := flags.String("map", "", "map columns to user fields, e.g. Login=user_name,Mail=email")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: import [-tenant slug] [-format csv|ndjson] [-dry-run] [-map column=field,...] file")
+		return 2
+	}
+	path := flags.Arg(0)
+
+	if *format == "" {
+		*format = "csv"
+		if strings.HasSuffix(path, ".ndjson") || strings.HasSuffix(path, ".jsonl") {
+			*format = "ndjson"
+		}
+	}
+	mapping := make(map[string]string)
+	for _, pair := range strings.Split(*mappingFlag, ",") {
+		if column := strings.SplitN(pair, "=", 2); len(column) == 2 {
+			mapping[column[0]] = column[1]
+		}
+	}
+
+	db := database.Connect()
+	organization, err := findOrganization(db, *slug)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer file.Close()
+
+	job := &models.ImportJob{
+		Status: models.ImportStatusRunning,
+		Format: *format,
+		DryRun: *dryRun,
+		Errors: []models.ImportError{},
+	}
+	progress := func(job *models.ImportJob) {
+		fmt.Fprintf(os.Stderr, "processed %d rows\n", job.Processed)
+	}
+	if err := handlers.ImportUsers(db.WithParam("tenant_id", organization.Id), file, mapping, job, progress); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	job.Status = models.ImportStatusCompleted
+
+	report, _ := json.MarshalIndent(job, "", "  ")
+	fmt.Println(string(report))
+	if job.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// Rotate the keys personal data is encrypted with, printing the report, with:
+// go run main.go rotate-keys [-tenant slug] [-batch-size n]
+// Every organization's data is rewritten unless one is named.
+func rotateKeys(args []string) int {
+	flags := flag.NewFlagSet("rotate-keys", flag.ExitOnError)
+	slug := flags.String("tenant", "", "the slug of the only organization to rewrite (default: every organization)")
+	batchSize := flags.Int("batch-size", 500, "rows to rewrite per transaction")
+	flags.Parse(args)
+	if flags.NArg() != 0 || *batchSize < 1 {
+		fmt.Fprintln(os.Stderr, "usage: rotate-keys [-tenant slug] [-batch-size n]")
+		return 2
+	}
+
+	db := database.Connect()
+	var organizations []models.Organization
+	if *slug != "" {
+		organization, err := findOrganization(db, *slug)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		organizations = append(organizations, *organization)
+	} else if err := db.Model(&organizations).Order("id").Select(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	progress := func(organization string, table string, rows int) {
+		fmt.Fprintf(os.Stderr, "rewrote %d rows of %s in %s\n", rows, table, organization)
+	}
+	dataKeys, rewritten, err := handlers.RotateKeys(db, organizations, *batchSize, progress)
+
+	report, _ := json.MarshalIndent(map[string]interface{}{
+		"data_keys_reencrypted": dataKeys,
+		"rows_rewritten":        rewritten,
+	}, "", "  ")
+	fmt.Println(string(report))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// Apply the retention rules now, unless an API process is, printing the run:
+// go run main.go apply-retention
+func applyRetention() int {
+	run, err := handlers.ApplyRetention(database.Connect(), mail.NewMailer())
+	if run == nil && err == nil {
+		fmt.Fprintln(os.Stderr, "the retention rules are off, or being applied already")
+		return 1
+	}
+	if run != nil {
+		report, _ := json.MarshalIndent(run, "", "  ")
+		fmt.Println(string(report))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	viper.SetDefault("port", "8080")
+	server.Configure()
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		os.Exit(importUsers(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		os.Exit(rotateKeys(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "apply-retention" {
+		os.Exit(applyRetention())
+	}
+
+	go handlers.RunRetentionScheduler(database.Connect(), mail.NewMailer())
+	go handlers.RunWebhookDeliverer(database.Connect(), webhook.NewSender())
+	go handlers.RunUserEventPruner(database.Connect())
+	go handlers.RunUserEventRelay(database.Connect(), broker.NewPublisher())
+
 	server.Setup().Run(":" + viper.GetString("port"))
 }
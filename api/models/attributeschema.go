@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type AttributeName struct {
+	Name string `uri:"name" json:"name" binding:"max=255" sql:",pk"`
+}
+
+// The JSON Schema a user attribute's value must match, and whether it is only
+// visible to admins
+type AttributeSchema struct {
+	TenantId uint `json:"-"`
+	AttributeName
+	Schema    map[string]interface{} `json:"schema" binding:"required"`
+	Private   bool                   `json:"private" sql:",notnull"`
+	CreatedAt time.Time              `json:"created_at"`
+	UpdatedAt time.Time              `json:"updated_at"`
+}
@@ -0,0 +1,50 @@
+package models
+
+const (
+	// Every item is written in a single transaction, or none are
+	BatchModeAtomic = "atomic"
+	// Each item is written independently of the others
+	BatchModeBestEffort = "best_effort"
+)
+
+type UserBatchCreate struct {
+	Mode  string         `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Items []UserIncoming `json:"items" binding:"required,min=1"`
+}
+
+type UserBatchUpdateItem struct {
+	// The user's id, only while numeric_user_ids is on
+	UserID
+	PublicId string `json:"public_id"`
+	// The version in the user's ETag. If set, the user is only updated if
+	// it's still at that version.
+	Version uint `json:"version"`
+	UserIncoming
+}
+
+type UserBatchUpdate struct {
+	Mode  string                `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	Items []UserBatchUpdateItem `json:"items" binding:"required,min=1"`
+}
+
+type UserBatchDelete struct {
+	Mode      string   `json:"mode" binding:"omitempty,oneof=atomic best_effort"`
+	PublicIds []string `json:"public_ids" binding:"required_without=Ids"`
+	// Only while numeric_user_ids is on
+	Ids []uint `json:"ids" binding:"required_without=PublicIds"`
+	// The version in each user's ETag, in the same order. If given, a user
+	// is only deleted if it's still at that version.
+	Versions []uint `json:"versions"`
+}
+
+type BatchItemResult struct {
+	Index   int           `json:"index"`
+	Status  int           `json:"status"`
+	Message string        `json:"message,omitempty"`
+	User    *UserOutgoing `json:"user,omitempty"`
+}
+
+type BatchResult struct {
+	Mode    string            `json:"mode"`
+	Results []BatchItemResult `json:"results"`
+}
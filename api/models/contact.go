@@ -0,0 +1,47 @@
+package models
+
+// The fields common to every kind of contact method
+type Contact struct {
+	Id        uint   `json:"id"`
+	UserId    uint   `json:"user_id"`
+	Label     string `json:"label" binding:"max=64"`
+	IsPrimary bool   `json:"is_primary" sql:",notnull"`
+}
+
+func (contact *Contact) ContactBase() *Contact {
+	return contact
+}
+
+// Implemented by each kind of contact method through the embedded Contact
+type ContactMethod interface {
+	ContactBase() *Contact
+}
+
+type UserEmail struct {
+	Contact
+	Email      string `json:"email" binding:"required,email,max=1024"`
+	EmailIndex string `json:"-"`
+}
+
+type UserPhone struct {
+	Contact
+	PhoneNumber string `json:"phone_number" binding:"required"`
+	// The region to parse phone_number in, if it has no country code
+	PhoneRegion      string `json:"phone_region,omitempty" binding:"omitempty,len=2,alpha" sql:"-"`
+	PhoneNumberIndex string `json:"-"`
+}
+
+type UserAddress struct {
+	Contact
+	Line1      string `json:"line1" binding:"required,max=1024"`
+	Line2      string `json:"line2" binding:"max=1024"`
+	City       string `json:"city" binding:"max=1024"`
+	Region     string `json:"region" binding:"max=1024"`
+	PostalCode string `json:"postal_code" binding:"max=32"`
+	Country    string `json:"country" binding:"required,len=2,alpha"`
+}
+
+type ContactID struct {
+	UserID
+	ContactId uint `uri:"contact_id" json:"contact_id"`
+}
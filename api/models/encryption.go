@@ -0,0 +1,201 @@
+package models
+
+import (
+	"context"
+
+	"github.com/davidwarshaw/golang-user-crud/api/pii"
+	"github.com/go-pg/pg/orm"
+)
+
+// A field that may be stored encrypted, with its blind index
+type encryptedField struct {
+	name  string
+	value *string
+	index *string
+}
+
+// Encrypt the fields in place before they're written, and index them
+func encryptFields(db orm.DB, fields []encryptedField) error {
+	for _, field := range fields {
+		if !pii.Encrypted(field.name) {
+			*field.index = ""
+			continue
+		}
+		*field.index = pii.BlindIndex(field.name, *field.value)
+		encrypted, err := pii.Encrypt(db, *field.value)
+		if err != nil {
+			return err
+		}
+		*field.value = encrypted
+	}
+	return nil
+}
+
+// Decrypt the fields in place after they're read, or written back
+func decryptFields(c context.Context, db orm.DB, fields []encryptedField) error {
+	for _, field := range fields {
+		if !pii.Decrypted(c, field.name) {
+			continue
+		}
+		decrypted, err := pii.Decrypt(db, *field.value)
+		if err != nil {
+			return err
+		}
+		*field.value = decrypted
+	}
+	return nil
+}
+
+func (userAccount *UserAccount) encryptedFields() []encryptedField {
+	return []encryptedField{
+		{"first_name", &userAccount.FirstName, &userAccount.FirstNameIndex},
+		{"middle_name", &userAccount.MiddleName, &userAccount.MiddleNameIndex},
+		{"last_name", &userAccount.LastName, &userAccount.LastNameIndex},
+		{"email", &userAccount.Email, &userAccount.EmailIndex},
+		{"primary_phone_number", &userAccount.PrimaryPhoneNumber, &userAccount.PrimaryPhoneNumberIndex},
+	}
+}
+
+func (userAccount *UserAccount) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, userAccount.encryptedFields())
+}
+
+func (userAccount *UserAccount) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, userAccount.encryptedFields())
+}
+
+func (userAccount *UserAccount) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userAccount.encryptedFields())
+}
+
+func (userAccount *UserAccount) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userAccount.encryptedFields())
+}
+
+func (userAccount *UserAccount) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userAccount.encryptedFields())
+}
+
+// Contacts are indexed like the user fields they're copied to, so the
+// indexes can be copied with them
+func (userEmail *UserEmail) encryptedFields() []encryptedField {
+	return []encryptedField{{"email", &userEmail.Email, &userEmail.EmailIndex}}
+}
+
+func (userEmail *UserEmail) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, userEmail.encryptedFields())
+}
+
+func (userEmail *UserEmail) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, userEmail.encryptedFields())
+}
+
+func (userEmail *UserEmail) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userEmail.encryptedFields())
+}
+
+func (userEmail *UserEmail) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userEmail.encryptedFields())
+}
+
+func (userEmail *UserEmail) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userEmail.encryptedFields())
+}
+
+func (userPhone *UserPhone) encryptedFields() []encryptedField {
+	return []encryptedField{{"primary_phone_number", &userPhone.PhoneNumber, &userPhone.PhoneNumberIndex}}
+}
+
+func (userPhone *UserPhone) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, userPhone.encryptedFields())
+}
+
+func (userPhone *UserPhone) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, userPhone.encryptedFields())
+}
+
+func (userPhone *UserPhone) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userPhone.encryptedFields())
+}
+
+func (userPhone *UserPhone) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userPhone.encryptedFields())
+}
+
+func (userPhone *UserPhone) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, userPhone.encryptedFields())
+}
+
+// The addresses and numbers outstanding verifications and invitations were
+// sent to are encrypted like the user fields they're checked against
+func (verification *PhoneVerification) encryptedFields() []encryptedField {
+	return []encryptedField{{"primary_phone_number", &verification.PhoneNumber, &verification.PhoneNumberIndex}}
+}
+
+func (verification *PhoneVerification) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, verification.encryptedFields())
+}
+
+func (verification *PhoneVerification) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, verification.encryptedFields())
+}
+
+func (verification *PhoneVerification) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (verification *PhoneVerification) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (verification *PhoneVerification) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (verification *EmailVerification) encryptedFields() []encryptedField {
+	return []encryptedField{{"email", &verification.Email, &verification.EmailIndex}}
+}
+
+func (verification *EmailVerification) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, verification.encryptedFields())
+}
+
+func (verification *EmailVerification) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, verification.encryptedFields())
+}
+
+func (verification *EmailVerification) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (verification *EmailVerification) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (verification *EmailVerification) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, verification.encryptedFields())
+}
+
+func (invitation *Invitation) encryptedFields() []encryptedField {
+	return []encryptedField{{"email", &invitation.Email, &invitation.EmailIndex}}
+}
+
+func (invitation *Invitation) BeforeInsert(c context.Context, db orm.DB) error {
+	return encryptFields(db, invitation.encryptedFields())
+}
+
+func (invitation *Invitation) BeforeUpdate(c context.Context, db orm.DB) error {
+	return encryptFields(db, invitation.encryptedFields())
+}
+
+func (invitation *Invitation) AfterQuery(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, invitation.encryptedFields())
+}
+
+func (invitation *Invitation) AfterInsert(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, invitation.encryptedFields())
+}
+
+func (invitation *Invitation) AfterUpdate(c context.Context, db orm.DB) error {
+	return decryptFields(c, db, invitation.encryptedFields())
+}
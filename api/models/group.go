@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+type GroupID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type GroupBase struct {
+	Name        string `json:"name" binding:"required,max=255"`
+	Description string `json:"description" binding:"max=1024"`
+	// The group this group is nested in, if any
+	ParentId *uint `json:"parent_id"`
+}
+
+type Group struct {
+	GroupID
+	TenantId uint `json:"-"`
+	GroupBase
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type GroupMember struct {
+	TenantId  uint
+	GroupId   uint `sql:",pk"`
+	UserId    uint `sql:",pk"`
+	CreatedAt time.Time
+}
+
+type GroupMemberID struct {
+	Id     uint `uri:"id" json:"id"`
+	UserId uint `uri:"-" json:"user_id"`
+	// The :user_id the route was called with, which is the user's public_id
+	// or, while numeric_user_ids is on, their id
+	UserParam string `uri:"user_id" json:"-" binding:"required,uuid|number"`
+}
+
+type GroupsOptions struct {
+	// Include the groups the user is in through nesting
+	Nested bool `form:"nested"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import (
+	"net/http"
+	"time"
+)
+
+type IdempotencyKey struct {
+	TenantId        uint
+	IdempotencyKey  string `sql:",pk"`
+	Caller          string `sql:",pk"` // The user, the admin or anonymous
+	Fingerprint     string `sql:",notnull"`
+	ResponseStatus  int    // Zero while the original request is in flight
+	ResponseHeaders http.Header
+	ResponseBody    []byte
+	ExpiresAt       time.Time
+}
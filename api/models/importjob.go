@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+const (
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+type ImportOptions struct {
+	Format string `form:"format" binding:"omitempty,oneof=csv ndjson"`
+	DryRun bool   `form:"dry_run"`
+	Async  bool   `form:"async"`
+}
+
+type ImportJobID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type ImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type ImportJob struct {
+	Id         uint          `json:"id,omitempty"`
+	TenantId   uint          `json:"-"`
+	Status     string        `json:"status"`
+	Format     string        `json:"format"`
+	DryRun     bool          `json:"dry_run" sql:",notnull"`
+	Processed  int           `json:"processed" sql:",notnull"`
+	Created    int           `json:"created" sql:",notnull"`
+	Failed     int           `json:"failed" sql:",notnull"`
+	Errors     []ImportError `json:"errors"`
+	Message    string        `json:"message,omitempty"`
+	CreatedAt  time.Time     `json:"created_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+}
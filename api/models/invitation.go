@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+type InvitationID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type InvitationIncoming struct {
+	Email      string `json:"email" binding:"required,email"`
+	FirstName  string `json:"first_name" binding:"max=1024,startsnotwith=enc1:"`
+	MiddleName string `json:"middle_name" binding:"max=1024,startsnotwith=enc1:"`
+	LastName   string `json:"last_name" binding:"max=1024,startsnotwith=enc1:"`
+}
+
+// An outstanding invitation of a pending user. Only a hash of the secret in
+// the latest token sent is stored, so resending makes earlier tokens invalid.
+type Invitation struct {
+	InvitationID
+	TenantId   uint      `json:"-"`
+	UserId     uint      `json:"user_id"`
+	Email      string    `json:"email"`
+	EmailIndex string    `json:"-"`
+	SecretHash string    `json:"-"`
+	InvitedBy  *uint     `json:"invited_by"`
+	CreatedAt  time.Time `json:"created_at"`
+	SentAt     time.Time `json:"sent_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// The token sent in an invitation, which shares the :id segment with the
+// other invitation routes
+type InvitationToken struct {
+	Token string `uri:"id" binding:"required"`
+}
+
+// What the invitee chooses when accepting
+type InvitationAcceptance struct {
+	UserName string `json:"user_name" binding:"required,alphanum,min=4,max=255"`
+	Password string `json:"password" binding:"required,min=8,max=255"`
+}
@@ -0,0 +1,18 @@
+package models
+
+type UserNameURI struct {
+	UserName string `uri:"user_name" binding:"required"`
+}
+
+type UserEmailURI struct {
+	Email string `uri:"email" binding:"required,email"`
+}
+
+type UserPhoneURI struct {
+	Phone string `uri:"phone" binding:"required"`
+}
+
+type UserPhoneLookup struct {
+	// The region to parse the phone number in, if it has no country code
+	Region string `form:"region" binding:"omitempty,len=2,alpha"`
+}
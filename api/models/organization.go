@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+type OrganizationID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type OrganizationBase struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// Names the organization in the X-Tenant header, and as a subdomain
+	Slug string `json:"slug" binding:"required,max=63"`
+}
+
+// A tenant: every user belongs to one organization, and is only visible
+// within it
+type Organization struct {
+	OrganizationID
+	OrganizationBase
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Everything stored about a user, for a data subject access request
+type UserDataExport struct {
+	ExportedAt  time.Time        `json:"exported_at"`
+	User        *UserOutgoing    `json:"user"`
+	Emails      []UserEmail      `json:"emails"`
+	Phones      []UserPhone      `json:"phones"`
+	Addresses   []UserAddress    `json:"addresses"`
+	NameChanges []UserNameChange `json:"name_changes"`
+	Sessions    []Session        `json:"sessions"`
+	Groups      []Group          `json:"groups"`
+	Roles       []Role           `json:"roles"`
+	Invitations []Invitation     `json:"invitations"`
+	Erasures    []UserErasure    `json:"erasures"`
+}
+
+type UserErasureIncoming struct {
+	Reason string `json:"reason" binding:"required,max=1024"`
+}
+
+// The record kept of a user's personal data being erased. It outlives the
+// user, and only has their ids.
+type UserErasure struct {
+	Id       uint   `json:"id"`
+	TenantId uint   `json:"-"`
+	UserId   uint   `json:"user_id"`
+	PublicId string `json:"public_id"`
+	// The user who erased them, or nil for the admin
+	ErasedBy *uint     `json:"erased_by"`
+	Reason   string    `json:"reason"`
+	ErasedAt time.Time `json:"erased_at"`
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+type PhoneVerification struct {
+	UserId           uint   `sql:",pk"`
+	PhoneNumber      string `sql:",notnull"`
+	PhoneNumberIndex string
+	CodeHash         string `sql:",notnull"`
+	Attempts         int    `sql:",notnull"`
+	ExpiresAt        time.Time
+	CreatedAt        time.Time
+}
+
+type PhoneVerificationConfirm struct {
+	Code string `json:"code" binding:"required,len=6,numeric"`
+}
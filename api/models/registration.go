@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// A user signing themselves up
+type Registration struct {
+	UserIncoming
+	// The captcha response, or the proof of work, if the captcha config is set
+	Captcha string `json:"captcha"`
+}
+
+type EmailVerification struct {
+	UserId     uint   `sql:",pk"`
+	Email      string `sql:",notnull"`
+	EmailIndex string
+	TokenHash  string `sql:",notnull"`
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
+}
+
+type EmailVerificationConfirm struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type EmailVerificationResend struct {
+	Email string `json:"email" binding:"required,email"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+const (
+	RetentionActionWarn    = "warn"
+	RetentionActionSuspend = "suspend"
+	RetentionActionErase   = "erase"
+)
+
+// Days of inactivity after which users are warned, suspended and erased. 0
+// turns a rule off.
+type RetentionRules struct {
+	WarnDays    int `json:"warn_days"`
+	SuspendDays int `json:"suspend_days"`
+	EraseDays   int `json:"erase_days"`
+}
+
+// A run of the retention rules, over every organization
+type RetentionRun struct {
+	Id         uint       `json:"id"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	Warned     int        `json:"warned" sql:",notnull"`
+	Suspended  int        `json:"suspended" sql:",notnull"`
+	Erased     int        `json:"erased" sql:",notnull"`
+	// Why the run stopped early, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// What the next run will do to a user
+type RetentionCandidate struct {
+	Action string        `json:"action"`
+	User   *UserOutgoing `json:"user"`
+}
+
+type RetentionReport struct {
+	NextRunAt time.Time      `json:"next_run_at"`
+	Rules     RetentionRules `json:"rules"`
+	// The number of users each action will be taken on
+	Counts     map[string]int       `json:"counts"`
+	Data       []RetentionCandidate `json:"data"`
+	Pagination Pagination           `json:"pagination"`
+}
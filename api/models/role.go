@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+type RoleID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type RoleBase struct {
+	Name        string   `json:"name" binding:"required,max=255"`
+	Description string   `json:"description" binding:"max=1024"`
+	Permissions []string `json:"permissions" sql:",array"`
+}
+
+type Role struct {
+	RoleID
+	TenantId uint `json:"-"`
+	RoleBase
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type UserRole struct {
+	TenantId  uint
+	RoleId    uint `sql:",pk"`
+	UserId    uint `sql:",pk"`
+	CreatedAt time.Time
+}
+
+type GroupRole struct {
+	TenantId  uint
+	RoleId    uint `sql:",pk"`
+	GroupId   uint `sql:",pk"`
+	CreatedAt time.Time
+}
+
+type RoleUserID struct {
+	Id     uint `uri:"id" json:"id"`
+	UserId uint `uri:"-" json:"user_id"`
+	// The :user_id the route was called with, which is the user's public_id
+	// or, while numeric_user_ids is on, their id
+	UserParam string `uri:"user_id" json:"-" binding:"required,uuid|number"`
+}
+
+type RoleGroupID struct {
+	Id      uint `uri:"id" json:"id"`
+	GroupId uint `uri:"group_id" json:"group_id"`
+}
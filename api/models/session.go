@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+type SessionIncoming struct {
+	UserName string `json:"user_name" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// A session only stores a hash of its token, which is given to the client
+// once, when the session is created
+type Session struct {
+	Id        uint      `json:"-"`
+	UserId    uint      `json:"user_id"`
+	Token     string    `json:"token,omitempty" sql:"-"`
+	TokenHash string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
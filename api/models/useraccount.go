@@ -1,30 +1,72 @@
 package models
 
+import "time"
+
 type UserID struct {
-	Id uint `uri:"id" json:"id"`
+	Id uint `uri:"-" json:"id"`
+	// The :id a user route was called with, which is their public_id or,
+	// while numeric_user_ids is on, their id
+	Param string `uri:"id" json:"-" sql:"-" binding:"omitempty,uuid|number"`
 }
 
 type UserBase struct {
 	UserName           string `json:"user_name" binding:"required,alphanum,min=4,max=255"`
-	FirstName          string `json:"first_name" binding:"max=1024"`
-	MiddleName         string `json:"middle_name" binding:"max=1024"`
-	LastName           string `json:"last_name" binding:"max=1024"`
+	FirstName          string `json:"first_name" binding:"max=1024,startsnotwith=enc1:"`
+	MiddleName         string `json:"middle_name" binding:"max=1024,startsnotwith=enc1:"`
+	LastName           string `json:"last_name" binding:"max=1024,startsnotwith=enc1:"`
 	Email              string `json:"email" binding:"email"`
 	PrimaryPhoneNumber string `json:"primary_phone_number"`
+	Country            string `json:"country" binding:"omitempty,len=2,alpha"`
+	// Custom attributes, each validated by its registered schema
+	Attributes map[string]interface{} `json:"attributes"`
 }
 
 type UserIncoming struct {
 	UserBase
 	Password string `json:"password" binding:"required,min=8,max=255"`
+	// The region to parse primary_phone_number in, if it has no country code
+	PhoneRegion string `json:"phone_region" binding:"omitempty,len=2,alpha"`
 }
 
 type UserOutgoing struct {
 	UserID
+	// The id to use in URLs, which doesn't give away how many users there are
+	PublicId string `json:"public_id"`
 	UserBase
+	PrimaryPhoneNumberDisplay string     `json:"primary_phone_number_display"`
+	PrimaryPhoneNumberType    string     `json:"primary_phone_number_type"`
+	EmailVerifiedAt           *time.Time `json:"email_verified_at"`
+	PhoneVerifiedAt           *time.Time `json:"phone_verified_at"`
+	Status                    string     `json:"status"`
+	StatusReason              string     `json:"status_reason,omitempty"`
+	StatusChangedAt           *time.Time `json:"status_changed_at,omitempty"`
+	// When the user last signed in, and last made a request. last_seen_at is
+	// only updated every few minutes, and neither changes the ETag.
+	LastLoginAt *time.Time `json:"last_login_at"`
+	LastSeenAt  *time.Time `json:"last_seen_at"`
 }
 
 type UserAccount struct {
 	UserID
+	PublicId string `json:"public_id"`
+	TenantId uint   `json:"-"`
 	UserBase
-	PasswordHash string `json:"password_hash"`
+	PasswordHash    string     `json:"password_hash"`
+	Version         int        `json:"-"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at"`
+	// Only active users can sign in
+	Status          string     `json:"status"`
+	StatusReason    string     `json:"status_reason"`
+	StatusChangedAt *time.Time `json:"status_changed_at"`
+	LastLoginAt     *time.Time `json:"last_login_at"`
+	LastSeenAt      *time.Time `json:"last_seen_at"`
+	// When the user was warned they'd be suspended or erased for inactivity
+	RetentionWarnedAt *time.Time `json:"retention_warned_at"`
+	// Blind indexes of the encrypted fields, to match them exactly
+	FirstNameIndex          string `json:"-"`
+	MiddleNameIndex         string `json:"-"`
+	LastNameIndex           string `json:"-"`
+	EmailIndex              string `json:"-"`
+	PrimaryPhoneNumberIndex string `json:"-"`
 }
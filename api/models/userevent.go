@@ -0,0 +1,54 @@
+package models
+
+import "time"
+
+// The kinds of change to a user that events are recorded for
+const (
+	UserEventCreated = "user.created"
+	UserEventUpdated = "user.updated"
+	UserEventDeleted = "user.deleted"
+)
+
+var UserEventTypes = []string{
+	UserEventCreated,
+	UserEventUpdated,
+	UserEventDeleted,
+}
+
+// A change to a user, as recorded in the outbox in the transaction that made
+// it
+type UserEvent struct {
+	// Orders the organization's events. Consumers resume after the last one
+	// they handled.
+	Sequence int64 `json:"sequence" sql:",pk"`
+	TenantId uint  `json:"-"`
+	// The same wherever the event is delivered, to tell repeats apart
+	Id     string `json:"id"`
+	Type   string `json:"type"`
+	UserId uint   `json:"user_id"`
+	// The user after the change, or before it if they were deleted
+	Data      *UserOutgoing `json:"data"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type UserEventsOptions struct {
+	// Only events after this sequence
+	After int64 `form:"after" binding:"omitempty,min=0"`
+	Limit int   `form:"limit" binding:"omitempty,min=1,max=1000"`
+	// Seconds to wait for an event when there are none yet
+	Wait int `form:"wait" binding:"omitempty,min=0,max=60"`
+}
+
+type UserEventPage struct {
+	Data []UserEvent `json:"data"`
+	// The sequence to ask for events after next time
+	Next int64 `json:"next"`
+}
+
+// How far an organization's events have been published to the broker
+type UserEventCheckpoint struct {
+	TenantId uint `json:"-" sql:",pk"`
+	// The sequence of the last event published
+	Sequence  int64     `json:"sequence"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
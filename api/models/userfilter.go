@@ -0,0 +1,14 @@
+package models
+
+// Filters shared by the endpoints that list users
+type UserFilter struct {
+	UserName  string `form:"user_name"`
+	FirstName string `form:"first_name"`
+	LastName  string `form:"last_name"`
+	Email     string `form:"email"`
+	Status    string `form:"status" binding:"omitempty,oneof=pending active suspended disabled"`
+	// Members of the group, or of any group nested in it
+	Group uint `form:"group"`
+	// Attribute values, from attr[name]=value
+	Attributes map[string]string `form:"-"`
+}
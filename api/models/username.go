@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type UserRename struct {
+	UserName string `json:"user_name" binding:"required,alphanum,min=4,max=255"`
+}
+
+// A user_name a user had before changing it. Nobody else can claim it until
+// reserved_until.
+type UserNameChange struct {
+	Id            uint      `json:"-"`
+	TenantId      uint      `json:"-"`
+	UserId        uint      `json:"user_id"`
+	UserName      string    `json:"user_name"`
+	ChangedAt     time.Time `json:"changed_at"`
+	ReservedUntil time.Time `json:"reserved_until"`
+}
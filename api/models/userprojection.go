@@ -0,0 +1,9 @@
+package models
+
+// Which fields of users to respond with, and what to include with them
+type UserProjection struct {
+	// Comma separated, e.g. id,user_name. Every field if empty.
+	Fields string `form:"fields"`
+	// Related resources to include with each user
+	Expand string `form:"expand" binding:"omitempty,oneof=groups"`
+}
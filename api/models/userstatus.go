@@ -0,0 +1,37 @@
+package models
+
+const (
+	// Not yet usable, e.g. invited but not signed up
+	UserStatusPending   = "pending"
+	UserStatusActive    = "active"
+	UserStatusSuspended = "suspended"
+	// For good
+	UserStatusDisabled = "disabled"
+)
+
+// A change of status a client can make: the status it changes to, and the
+// statuses it can be made from
+type UserStatusAction struct {
+	Status string
+	From   []string
+}
+
+var UserStatusActions = map[string]UserStatusAction{
+	"suspend":    {UserStatusSuspended, []string{UserStatusActive}},
+	"reactivate": {UserStatusActive, []string{UserStatusSuspended}},
+	"disable":    {UserStatusDisabled, []string{UserStatusPending, UserStatusActive, UserStatusSuspended}},
+}
+
+// Whether the action can be made from the status
+func (action UserStatusAction) AllowedFrom(status string) bool {
+	for _, from := range action.From {
+		if status == from {
+			return true
+		}
+	}
+	return false
+}
+
+type UserStatusChange struct {
+	Reason string `json:"reason" binding:"required,max=1024"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import "time"
+
+const (
+	// Waiting for its next attempt
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	// Out of attempts. It stays in the log, and can be redelivered.
+	WebhookDeliveryDead = "dead"
+)
+
+type WebhookID struct {
+	Id uint `uri:"id" json:"id"`
+}
+
+type WebhookBase struct {
+	Url string `json:"url" binding:"required,url,max=2048"`
+	// The events sent to the webhook, e.g. user.created. Every event if empty.
+	Events      []string `json:"events" sql:",array,notnull"`
+	Description string   `json:"description" binding:"max=1024"`
+	// Stops new events being sent. Deliveries already queued still are.
+	Disabled bool `json:"disabled" sql:",notnull"`
+}
+
+type WebhookIncoming struct {
+	WebhookBase
+	// The key payloads are signed with. One is generated when a webhook is
+	// created without one, and it's kept when a webhook is updated without one.
+	Secret string `json:"secret" binding:"omitempty,min=16,max=255"`
+}
+
+type Webhook struct {
+	WebhookID
+	TenantId uint `json:"-"`
+	WebhookBase
+	// Only shown when the webhook is created
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// What's posted to a webhook
+type WebhookPayload struct {
+	// The event's id, the same in each delivery of it
+	Id        string        `json:"id"`
+	Type      string        `json:"type"`
+	CreatedAt time.Time     `json:"created_at"`
+	Data      *UserOutgoing `json:"data"`
+}
+
+// An event queued for a webhook, and the outcome of sending it
+type WebhookDelivery struct {
+	Id            uint            `json:"id"`
+	TenantId      uint            `json:"-"`
+	WebhookId     uint            `json:"webhook_id"`
+	EventId       string          `json:"event_id"`
+	Event         string          `json:"event"`
+	Payload       *WebhookPayload `json:"payload"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts" sql:",notnull"`
+	NextAttemptAt *time.Time      `json:"next_attempt_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at"`
+	// Of the last attempt, if the webhook responded
+	ResponseStatus int        `json:"response_status,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
+}
+
+type WebhookDeliveryID struct {
+	Id         uint `uri:"id" json:"id"`
+	DeliveryId uint `uri:"delivery_id" json:"delivery_id"`
+}
+
+type WebhookDeliveryFilter struct {
+	Status string `form:"status" binding:"omitempty,oneof=pending delivered dead"`
+}
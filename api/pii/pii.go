@@ -0,0 +1,408 @@
+package pii
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+// The user fields that can be encrypted
+var Fields = []string{
+	"first_name",
+	"middle_name",
+	"last_name",
+	"email",
+	"primary_phone_number",
+}
+
+// Encrypted values start with this, then the data key id
+const prefix = "enc1:"
+
+// How long the current data key is used before checking for a newer one,
+// e.g. after a rotation
+const currentKeyTTL = time.Minute
+
+// Register the defaults of the encryption config, once at startup
+func SetDefaults() {
+	// Comma separated id=key pairs, each key 32 random bytes, base64 encoded.
+	// The first encrypts new data keys, the rest only decrypt older ones.
+	// Nothing is encrypted without a master key.
+	viper.SetDefault("pii_master_keys", "")
+	// A file of id=key lines, read instead of pii_master_keys if set
+	viper.SetDefault("pii_master_key_file", "")
+	// 32 random bytes, base64 encoded, for the blind indexes
+	viper.SetDefault("pii_index_key", "")
+	// Comma separated, from the fields that can be encrypted
+	viper.SetDefault("pii_fields", strings.Join(Fields, ","))
+}
+
+// The keys from the config
+type keyring struct {
+	masterKeys    map[string][]byte
+	currentMaster string
+	indexKey      []byte
+	fields        map[string]bool
+}
+
+var (
+	mu sync.Mutex
+	// The config the keyring was loaded from, so it's reloaded if that changes
+	loadedFrom string
+	loaded     *keyring
+	loadErr    error
+	// Data keys by id, decrypted
+	dataKeys = map[uint][]byte{}
+	current  struct {
+		id       uint
+		loadedAt time.Time
+	}
+)
+
+// Parse id=key pairs into master keys. The first is current.
+func parseMasterKeys(pairs []string) (map[string][]byte, string, error) {
+	keys := map[string][]byte{}
+	var first string
+	for _, pair := range pairs {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idAndKey := strings.SplitN(pair, "=", 2)
+		if len(idAndKey) != 2 || idAndKey[0] == "" {
+			return nil, "", errors.New("master keys must be id=key")
+		}
+		key, err := base64.StdEncoding.DecodeString(idAndKey[1])
+		if err != nil || len(key) != 32 {
+			return nil, "", fmt.Errorf("master key %s isn't 32 base64 encoded bytes", idAndKey[0])
+		}
+		keys[idAndKey[0]] = key
+		if first == "" {
+			first = idAndKey[0]
+		}
+	}
+	return keys, first, nil
+}
+
+func loadKeyring() (*keyring, error) {
+	masterKeys := viper.GetString("pii_master_keys")
+	if path := viper.GetString("pii_master_key_file"); path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		masterKeys = strings.Replace(string(contents), "\n", ",", -1)
+	}
+
+	ring := &keyring{fields: map[string]bool{}}
+	var err error
+	if ring.masterKeys, ring.currentMaster, err = parseMasterKeys(strings.Split(masterKeys, ",")); err != nil {
+		return nil, err
+	}
+	if ring.currentMaster == "" {
+		return ring, nil
+	}
+
+	if ring.indexKey, err = base64.StdEncoding.DecodeString(viper.GetString("pii_index_key")); err != nil || len(ring.indexKey) != 32 {
+		return nil, errors.New("pii_index_key must be 32 base64 encoded bytes")
+	}
+	for _, field := range strings.Split(viper.GetString("pii_fields"), ",") {
+		field = strings.TrimSpace(field)
+		found := false
+		for _, known := range Fields {
+			found = found || field == known
+		}
+		if !found {
+			return nil, fmt.Errorf("%s can't be encrypted", field)
+		}
+		ring.fields[field] = true
+	}
+	return ring, nil
+}
+
+// The keyring for the current config
+func currentKeyring() (*keyring, error) {
+	config := strings.Join([]string{
+		viper.GetString("pii_master_keys"),
+		viper.GetString("pii_master_key_file"),
+		viper.GetString("pii_index_key"),
+		viper.GetString("pii_fields"),
+	}, "\x00")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if loaded == nil || config != loadedFrom {
+		loaded, loadErr = loadKeyring()
+		loadedFrom = config
+	}
+	return loaded, loadErr
+}
+
+// Whether the user field is encrypted. A field is if there's a master key and
+// it's in pii_fields, even if the config is otherwise broken, so that nothing
+// is written in plaintext by mistake.
+func Encrypted(field string) bool {
+	if viper.GetString("pii_master_keys") == "" && viper.GetString("pii_master_key_file") == "" {
+		return false
+	}
+	for _, encrypted := range strings.Split(viper.GetString("pii_fields"), ",") {
+		if strings.TrimSpace(encrypted) == field {
+			return true
+		}
+	}
+	return false
+}
+
+type rotationKey struct{}
+
+// A context for the queries of a key rotation, which decrypt fields taken
+// out of pii_fields too, so they're written back in plaintext
+func ForRotation(c context.Context) context.Context {
+	return context.WithValue(c, rotationKey{}, true)
+}
+
+// Whether the stored values of a user field are decrypted as they're read.
+// Only an encrypted field's are, outside a rotation, so that a value that
+// just looks like ciphertext is never taken for it.
+func Decrypted(c context.Context, field string) bool {
+	return Encrypted(field) || c != nil && c.Value(rotationKey{}) != nil
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+}
+
+// Load a data key, decrypting it with its master key
+func dataKey(db orm.DB, ring *keyring, id uint) ([]byte, error) {
+	mu.Lock()
+	key, ok := dataKeys[id]
+	mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	var masterKeyId string
+	var encryptedKey []byte
+	_, err := db.QueryOne(pg.Scan(&masterKeyId, &encryptedKey),
+		"SELECT master_key_id, encrypted_key FROM data_keys WHERE id = ?", id)
+	if err != nil {
+		return nil, fmt.Errorf("data key %d: %s", id, err)
+	}
+	masterKey, ok := ring.masterKeys[masterKeyId]
+	if !ok {
+		return nil, fmt.Errorf("data key %d is encrypted with master key %s, which isn't configured", id, masterKeyId)
+	}
+	if key, err = open(masterKey, encryptedKey); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	dataKeys[id] = key
+	mu.Unlock()
+	return key, nil
+}
+
+// Make a new data key, encrypted with the current master key, which becomes
+// the current data key. It's inserted on a connection of its own, so it's
+// committed before anything is encrypted with it, even if the transaction
+// that needed it rolls back.
+func newDataKey(ring *keyring) (uint, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return 0, err
+	}
+	encryptedKey, err := seal(ring.masterKeys[ring.currentMaster], key)
+	if err != nil {
+		return 0, err
+	}
+	db := database.Connect()
+	defer db.Close()
+	var id uint
+	_, err = db.QueryOne(pg.Scan(&id),
+		"INSERT INTO data_keys (master_key_id, encrypted_key) VALUES (?, ?) RETURNING id", ring.currentMaster, encryptedKey)
+	if err != nil {
+		return 0, err
+	}
+
+	mu.Lock()
+	dataKeys[id] = key
+	current.id, current.loadedAt = id, time.Now()
+	mu.Unlock()
+	return id, nil
+}
+
+// The id of the newest data key, made if there isn't one
+func currentDataKey(db orm.DB, ring *keyring) (uint, error) {
+	mu.Lock()
+	id, loadedAt := current.id, current.loadedAt
+	mu.Unlock()
+	if id != 0 && time.Since(loadedAt) < currentKeyTTL {
+		return id, nil
+	}
+
+	_, err := db.QueryOne(pg.Scan(&id), "SELECT id FROM data_keys ORDER BY id DESC LIMIT 1")
+	if err == pg.ErrNoRows {
+		return newDataKey(ring)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	mu.Lock()
+	current.id, current.loadedAt = id, time.Now()
+	mu.Unlock()
+	return id, nil
+}
+
+// Encrypt a value with the current data key. Empty values stay empty, so
+// they're still stored as NULL.
+func Encrypt(db orm.DB, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	ring, err := currentKeyring()
+	if err != nil {
+		return "", err
+	}
+	if ring.currentMaster == "" {
+		return "", errors.New("there's no master key to encrypt with")
+	}
+	id, err := currentDataKey(db, ring)
+	if err != nil {
+		return "", err
+	}
+	key, err := dataKey(db, ring, id)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(key, []byte(value))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s%d:%s", prefix, id, base64.RawStdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt a value of a field that's Decrypted. One that isn't encrypted,
+// e.g. written before its field was, is returned as it is.
+func Decrypt(db orm.DB, value string) (string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return value, nil
+	}
+	idAndSealed := strings.SplitN(strings.TrimPrefix(value, prefix), ":", 2)
+	id, err := strconv.ParseUint(idAndSealed[0], 10, 32)
+	if err != nil || len(idAndSealed) != 2 {
+		return value, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(idAndSealed[1])
+	if err != nil {
+		return value, nil
+	}
+
+	ring, err := currentKeyring()
+	if err != nil {
+		return "", err
+	}
+	key, err := dataKey(db, ring, uint(id))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(key, sealed)
+	if err != nil {
+		return "", fmt.Errorf("decrypting with data key %d: %s", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// The blind index of a field's value, which matches the same value of the
+// field without revealing it. Emails match whatever their case. Empty values,
+// and any value when the config is broken, have no index.
+func BlindIndex(field string, value string) string {
+	ring, err := currentKeyring()
+	if value == "" || err != nil || ring.indexKey == nil {
+		return ""
+	}
+	if field == "email" {
+		value = strings.ToLower(strings.TrimSpace(value))
+	}
+	mac := hmac.New(sha256.New, ring.indexKey)
+	mac.Write([]byte(field + "\x00" + value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypt every data key with the current master key, so the others can be
+// retired, then make a new data key for values to be encrypted with from
+// now on. Returns the number of data keys re-encrypted.
+func RotateDataKeys(db orm.DB) (int, error) {
+	ring, err := currentKeyring()
+	if err != nil {
+		return 0, err
+	}
+	if ring.currentMaster == "" {
+		return 0, errors.New("there's no master key to encrypt with")
+	}
+
+	var ids []uint
+	if _, err := db.Query(&ids, "SELECT id FROM data_keys WHERE master_key_id != ? ORDER BY id", ring.currentMaster); err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		key, err := dataKey(db, ring, id)
+		if err != nil {
+			return 0, err
+		}
+		encryptedKey, err := seal(ring.masterKeys[ring.currentMaster], key)
+		if err != nil {
+			return 0, err
+		}
+		_, err = db.Exec("UPDATE data_keys SET master_key_id = ?, encrypted_key = ? WHERE id = ?", ring.currentMaster, encryptedKey, id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	_, err = newDataKey(ring)
+	return len(ids), err
+}
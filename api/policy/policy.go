@@ -0,0 +1,300 @@
+package policy
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
+	"github.com/spf13/viper"
+)
+
+const (
+	UsersRead       = "users:read"
+	UsersReadSelf   = "users:read:self"
+	UsersWrite      = "users:write"
+	UsersWriteSelf  = "users:write:self"
+	UsersDelete     = "users:delete"
+	UsersDeleteSelf = "users:delete:self"
+	// Finding users by user_name, email or phone number, which tells whether
+	// someone has an account
+	UsersLookup = "users:lookup"
+	// Suspending, reactivating and disabling users
+	UsersStatus = "users:status"
+	// Erasing users' personal data, for data subject erasure requests
+	UsersErase        = "users:erase"
+	GroupsRead        = "groups:read"
+	GroupsManage      = "groups:manage"
+	RolesManage       = "roles:manage"
+	AttributesManage  = "attributes:manage"
+	AttributesPrivate = "attributes:private"
+	// Managing webhooks, which are sent every user's details
+	WebhooksManage = "webhooks:manage"
+	// Managing every organization, which roles, being an organization's,
+	// can't grant
+	OrganizationsManage = "organizations:manage"
+)
+
+// Every permission
+var AllPermissions = []string{
+	UsersRead,
+	UsersReadSelf,
+	UsersWrite,
+	UsersWriteSelf,
+	UsersDelete,
+	UsersDeleteSelf,
+	UsersLookup,
+	UsersStatus,
+	UsersErase,
+	GroupsRead,
+	GroupsManage,
+	RolesManage,
+	AttributesManage,
+	AttributesPrivate,
+	WebhooksManage,
+	OrganizationsManage,
+}
+
+// What a route needs: the permission, or the self permission if the route's
+// :id is the caller's own
+type rule struct {
+	permission     string
+	selfPermission string
+}
+
+var (
+	readUsers   = rule{UsersRead, UsersReadSelf}
+	writeUsers  = rule{UsersWrite, UsersWriteSelf}
+	deleteUsers = rule{UsersDelete, UsersDeleteSelf}
+	readGroups  = rule{GroupsRead, ""}
+	manageGroup = rule{GroupsManage, ""}
+	manageRoles = rule{RolesManage, ""}
+	manageHooks = rule{WebhooksManage, ""}
+	manageOrgs  = rule{OrganizationsManage, ""}
+)
+
+// The rule for each route, by method and path. Routes without a rule, like
+// logging in, are open to everyone.
+var rules = map[string]rule{
+	"GET /users":                                           {UsersRead, ""},
+	"POST /users":                                          {UsersWrite, ""},
+	"POST /users:action":                                   {UsersWrite, ""},
+	"PUT /users:action":                                    {UsersWrite, ""},
+	"DELETE /users:action":                                 {UsersDelete, ""},
+	"GET /users/export":                                    {UsersRead, ""},
+	"POST /users/import":                                   {UsersWrite, ""},
+	"GET /users/import/:id":                                {UsersWrite, ""},
+	"GET /users/retention-report":                          {UsersStatus, ""},
+	"GET /users/by-username/:user_name":                    {UsersLookup, ""},
+	"GET /users/by-email/:email":                           {UsersLookup, ""},
+	"GET /users/by-phone/:phone":                           {UsersLookup, ""},
+	"GET /users/:id":                                       readUsers,
+	"PUT /users/:id":                                       writeUsers,
+	"DELETE /users/:id":                                    deleteUsers,
+	"POST /users/:id/suspend":                              {UsersStatus, ""},
+	"POST /users/:id/reactivate":                           {UsersStatus, ""},
+	"POST /users/:id/disable":                              {UsersStatus, ""},
+	"POST /users/:id/rename":                               writeUsers,
+	"GET /users/:id/names":                                 readUsers,
+	"GET /users/:id/emails":                                readUsers,
+	"POST /users/:id/emails":                               writeUsers,
+	"GET /users/:id/emails/:contact_id":                    readUsers,
+	"PUT /users/:id/emails/:contact_id":                    writeUsers,
+	"DELETE /users/:id/emails/:contact_id":                 writeUsers,
+	"GET /users/:id/phones":                                readUsers,
+	"POST /users/:id/phones":                               writeUsers,
+	"POST /users/:id/phones/verify":                        writeUsers,
+	"POST /users/:id/phones/confirm":                       writeUsers,
+	"GET /users/:id/phones/:contact_id":                    readUsers,
+	"PUT /users/:id/phones/:contact_id":                    writeUsers,
+	"DELETE /users/:id/phones/:contact_id":                 writeUsers,
+	"GET /users/:id/addresses":                             readUsers,
+	"POST /users/:id/addresses":                            writeUsers,
+	"GET /users/:id/addresses/:contact_id":                 readUsers,
+	"PUT /users/:id/addresses/:contact_id":                 writeUsers,
+	"DELETE /users/:id/addresses/:contact_id":              writeUsers,
+	"GET /users/:id/groups":                                readUsers,
+	"GET /users/:id/permissions":                           readUsers,
+	"GET /users/:id/data-export":                           readUsers,
+	"POST /users/:id/erase":                                {UsersErase, ""},
+	"PUT /attributes/:name":                                {AttributesManage, ""},
+	"DELETE /attributes/:name":                             {AttributesManage, ""},
+	"GET /groups":                                          readGroups,
+	"POST /groups":                                         manageGroup,
+	"GET /groups/:id":                                      readGroups,
+	"PUT /groups/:id":                                      manageGroup,
+	"DELETE /groups/:id":                                   manageGroup,
+	"GET /groups/:id/members":                              readGroups,
+	"PUT /groups/:id/members/:user_id":                     manageGroup,
+	"DELETE /groups/:id/members/:user_id":                  manageGroup,
+	"GET /roles":                                           manageRoles,
+	"POST /roles":                                          manageRoles,
+	"GET /roles/:id":                                       manageRoles,
+	"PUT /roles/:id":                                       manageRoles,
+	"DELETE /roles/:id":                                    manageRoles,
+	"PUT /roles/:id/users/:user_id":                        manageRoles,
+	"DELETE /roles/:id/users/:user_id":                     manageRoles,
+	"PUT /roles/:id/groups/:group_id":                      manageRoles,
+	"DELETE /roles/:id/groups/:group_id":                   manageRoles,
+	"GET /invitations":                                     {UsersRead, ""},
+	"POST /invitations":                                    {UsersWrite, ""},
+	"DELETE /invitations/:id":                              {UsersWrite, ""},
+	"POST /invitations/:id/resend":                         {UsersWrite, ""},
+	"GET /webhooks":                                        manageHooks,
+	"POST /webhooks":                                       manageHooks,
+	"GET /webhooks/:id":                                    manageHooks,
+	"PUT /webhooks/:id":                                    manageHooks,
+	"DELETE /webhooks/:id":                                 manageHooks,
+	"GET /webhooks/:id/deliveries":                         manageHooks,
+	"POST /webhooks/:id/deliveries/:delivery_id/redeliver": manageHooks,
+	"GET /events":                                          {UsersRead, ""},
+	"GET /events/stream":                                   {UsersRead, ""},
+	"GET /organizations":                                   manageOrgs,
+	"POST /organizations":                                  manageOrgs,
+	"GET /organizations/:id":                               manageOrgs,
+	"PUT /organizations/:id":                               manageOrgs,
+	"DELETE /organizations/:id":                            manageOrgs,
+}
+
+// Routes that tell whether someone has an account, so are only for signed in
+// callers, whatever the anonymous permissions are
+var signedInRoutes = map[string]bool{
+	"GET /users/by-username/:user_name": true,
+	"GET /users/by-email/:email":        true,
+	"GET /users/by-phone/:phone":        true,
+}
+
+// A set of permissions
+type Permissions map[string]bool
+
+func NewPermissions(permissions ...string) Permissions {
+	set := make(Permissions)
+	set.Add(permissions...)
+	return set
+}
+
+func (set Permissions) Add(permissions ...string) {
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if permission != "" {
+			set[permission] = true
+		}
+	}
+}
+
+// The permissions in the set, sorted
+func (set Permissions) List() []string {
+	list := make([]string, 0, len(set))
+	for permission := range set {
+		list = append(list, permission)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func IsPermission(permission string) bool {
+	for _, known := range AllPermissions {
+		if permission == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a role can grant the permission
+func IsGrantable(permission string) bool {
+	return IsPermission(permission) && permission != OrganizationsManage
+}
+
+// Register the defaults of the permissions config, once at startup
+func SetDefaults() {
+	// Everyone, signed in or not. By default, nothing.
+	viper.SetDefault("anonymous_permissions", "")
+	// Every signed in user, as well as the anonymous permissions
+	viper.SetDefault("authenticated_permissions", strings.Join([]string{UsersReadSelf, UsersWriteSelf}, ","))
+}
+
+// Finds the permissions granted to a user by their roles
+type RoleResolver func(db orm.DB, userId uint) ([]string, error)
+
+// The permissions a user has when signed in: everyone's, every signed in
+// user's, and their roles'
+func UserPermissions(db orm.DB, userId uint, resolve RoleResolver) (Permissions, error) {
+	permissions := NewPermissions(strings.Split(viper.GetString("anonymous_permissions"), ",")...)
+	permissions.Add(strings.Split(viper.GetString("authenticated_permissions"), ",")...)
+
+	rolePermissions, err := resolve(db, userId)
+	if err != nil {
+		return nil, err
+	}
+	permissions.Add(rolePermissions...)
+	return permissions, nil
+}
+
+// The permissions of whoever the request is from
+func effectivePermissions(c *gin.Context, db orm.DB, resolve RoleResolver) (Permissions, error) {
+	if auth.IsAdmin(c) {
+		return NewPermissions(AllPermissions...), nil
+	}
+	if caller := auth.Caller(c); caller != nil {
+		return UserPermissions(db, caller.Id, resolve)
+	}
+	return NewPermissions(strings.Split(viper.GetString("anonymous_permissions"), ",")...), nil
+}
+
+// Middleware works out what the request is allowed to do, then checks the
+// route's rule before the handler runs
+func Middleware(resolve RoleResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("DB").(*pg.DB)
+
+		permissions, err := effectivePermissions(c, db, resolve)
+		if err != nil {
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		c.Set("Permissions", permissions)
+
+		route := c.Request.Method + " " + c.FullPath()
+		if signedInRoutes[route] && auth.Caller(c) == nil && !auth.IsAdmin(c) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication required"})
+			return
+		}
+
+		rule, ok := rules[route]
+		if !ok || permissions[rule.permission] {
+			return
+		}
+		if rule.selfPermission != "" && permissions[rule.selfPermission] && isSelf(c) {
+			return
+		}
+
+		if auth.Caller(c) == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication required"})
+			return
+		}
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Missing permission " + rule.permission})
+	}
+}
+
+// Whether the route is about the caller's own user
+func isSelf(c *gin.Context) bool {
+	caller := auth.Caller(c)
+	if caller == nil {
+		return false
+	}
+	id := c.Param("id")
+	return strings.EqualFold(id, caller.PublicId) || id == strconv.FormatUint(uint64(caller.Id), 10)
+}
+
+// Whether the request has the permission
+func Allowed(c *gin.Context, permission string) bool {
+	permissions, _ := c.Get("Permissions")
+	set, _ := permissions.(Permissions)
+	return set[permission]
+}
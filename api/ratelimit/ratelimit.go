@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// Limiter allows a number of events per key in each window of time. Counts
+// are kept in memory, so each instance of the service limits separately.
+type Limiter struct {
+	Limit   int
+	Window  time.Duration
+	mu      sync.Mutex
+	windows map[string]*window
+	pruned  time.Time
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+func NewLimiter(limit int, length time.Duration) *Limiter {
+	return &Limiter{Limit: limit, Window: length, windows: map[string]*window{}}
+}
+
+// Count an event for the key. Returns whether it's within the limit, and if
+// not, how long until the next one will be.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	current := l.windows[key]
+	if current == nil || now.Sub(current.start) >= l.Window {
+		l.forgetEnded(now)
+		current = &window{start: now}
+		l.windows[key] = current
+	}
+	if current.count >= l.Limit {
+		return false, current.start.Add(l.Window).Sub(now)
+	}
+	current.count++
+	return true, 0
+}
+
+// Drop the windows that have ended, at most once a window, so keys seen once
+// don't pile up
+func (l *Limiter) forgetEnded(now time.Time) {
+	if now.Sub(l.pruned) < l.Window {
+		return
+	}
+	l.pruned = now
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+}
+
+// Register the defaults of the named limit's config, once at startup
+func SetDefaults(name string) {
+	viper.SetDefault(name+"_rate_limit", 10)
+	viper.SetDefault(name+"_rate_window", "1h")
+}
+
+// Middleware limits the requests from each client IP to the <name>_rate_limit
+// config per <name>_rate_window. A limit of 0 turns it off.
+func Middleware(name string) gin.HandlerFunc {
+	limiter := NewLimiter(viper.GetInt(name+"_rate_limit"), viper.GetDuration(name+"_rate_window"))
+
+	return func(c *gin.Context) {
+		if limiter.Limit <= 0 {
+			return
+		}
+		if ok, retryAfter := limiter.Allow(c.ClientIP()); !ok {
+			c.Header("Retry-After", fmt.Sprintf("%.0f", retryAfter.Seconds()+1))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"message": "Too many requests, try again later"})
+		}
+	}
+}
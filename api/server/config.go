@@ -0,0 +1,51 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/broker"
+	"github.com/davidwarshaw/golang-user-crud/api/captcha"
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/disposable"
+	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/idempotency"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/pii"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/davidwarshaw/golang-user-crud/api/ratelimit"
+	"github.com/davidwarshaw/golang-user-crud/api/sms"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
+	"github.com/davidwarshaw/golang-user-crud/api/webhook"
+	"github.com/spf13/viper"
+)
+
+var configureOnce sync.Once
+
+// Read the config from the environment, and register its defaults. This
+// happens once, before anything reads the config, as viper's defaults can't
+// be set while requests or background jobs are reading them.
+func Configure() {
+	configureOnce.Do(func() {
+		viper.AutomaticEnv()
+		// Comma separated IPs or CIDRs of the proxies in front of the
+		// service, whose X-Forwarded-For is believed. By default none are,
+		// so clients can't pick the IP they're rate limited by.
+		viper.SetDefault("trusted_proxies", "")
+
+		auth.SetDefaults()
+		broker.SetDefaults()
+		captcha.SetDefaults()
+		database.SetDefaults()
+		disposable.SetDefaults()
+		handlers.SetDefaults()
+		idempotency.SetDefaults()
+		mail.SetDefaults()
+		pii.SetDefaults()
+		policy.SetDefaults()
+		ratelimit.SetDefaults("registration")
+		sms.SetDefaults()
+		tenant.SetDefaults()
+		webhook.SetDefaults()
+	})
+}
@@ -2,9 +2,18 @@ package server
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/captcha"
 	"github.com/davidwarshaw/golang-user-crud/api/database"
 	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/idempotency"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/policy"
+	"github.com/davidwarshaw/golang-user-crud/api/ratelimit"
+	"github.com/davidwarshaw/golang-user-crud/api/sms"
+	"github.com/davidwarshaw/golang-user-crud/api/tenant"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/viper"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -24,21 +33,125 @@ func Setup() *gin.Engine {
 	r := gin.Default()
 
 	// Config
-	viper.AutomaticEnv()
+	Configure()
+
+	var trustedProxies []string
+	for _, proxy := range strings.Split(viper.GetString("trusted_proxies"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		panic(fmt.Sprintf("trusted_proxies: %s", err))
+	}
 
 	// The URL for the swagger docs
 	swaggerUrl := ginSwagger.URL(fmt.Sprintf("http://localhost:%s/swagger/doc.json", viper.GetString("port")))
 
 	// Middleware
 	r.Use(database.Middleware())
+	r.Use(auth.Middleware())
+	r.Use(tenant.Middleware())
+	r.Use(policy.Middleware(handlers.RolePermissions))
+	r.Use(idempotency.Middleware())
+	r.Use(sms.Middleware())
+	r.Use(mail.Middleware())
+	r.Use(captcha.Middleware())
+
+	// Limits on what anonymous clients can do, per IP
+	registrationLimit := ratelimit.Middleware("registration")
 
 	// Routes
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler, swaggerUrl))
 	r.GET("/users", handlers.RetrieveAllUsers)
 	r.POST("/users", handlers.CreateUser)
+	r.POST("/users:action", handlers.CreateUsersBatch)
+	r.PUT("/users:action", handlers.UpdateUsersBatch)
+	r.DELETE("/users:action", handlers.DeleteUsersBatch)
+	r.GET("/users/export", handlers.ExportUsers)
+	r.POST("/users/import", handlers.ImportUsersUpload)
+	r.GET("/users/import/:id", handlers.RetrieveImportJob)
+	r.GET("/users/retention-report", handlers.RetrieveRetentionReport)
+	r.GET("/users/by-username/:user_name", handlers.RetrieveUserByUserName)
+	r.GET("/users/by-email/:email", handlers.RetrieveUserByEmail)
+	r.GET("/users/by-phone/:phone", handlers.RetrieveUserByPhone)
 	r.GET("/users/:id", handlers.RetrieveUser)
 	r.PUT("/users/:id", handlers.UpdateUser)
 	r.DELETE("/users/:id", handlers.DeleteUser)
+	r.POST("/users/:id/suspend", handlers.SuspendUser)
+	r.POST("/users/:id/reactivate", handlers.ReactivateUser)
+	r.POST("/users/:id/disable", handlers.DisableUser)
+	r.POST("/users/:id/rename", handlers.RenameUser)
+	r.GET("/users/:id/names", handlers.RetrieveUserNameChanges)
+	r.GET("/users/:id/emails", handlers.RetrieveUserEmails)
+	r.POST("/users/:id/emails", handlers.CreateUserEmail)
+	r.GET("/users/:id/emails/:contact_id", handlers.RetrieveUserEmail)
+	r.PUT("/users/:id/emails/:contact_id", handlers.UpdateUserEmail)
+	r.DELETE("/users/:id/emails/:contact_id", handlers.DeleteUserEmail)
+	r.GET("/users/:id/phones", handlers.RetrieveUserPhones)
+	r.POST("/users/:id/phones", handlers.CreateUserPhone)
+	r.POST("/users/:id/phones/verify", handlers.VerifyUserPhone)
+	r.POST("/users/:id/phones/confirm", handlers.ConfirmUserPhone)
+	r.GET("/users/:id/phones/:contact_id", handlers.RetrieveUserPhone)
+	r.PUT("/users/:id/phones/:contact_id", handlers.UpdateUserPhone)
+	r.DELETE("/users/:id/phones/:contact_id", handlers.DeleteUserPhone)
+	r.GET("/users/:id/addresses", handlers.RetrieveUserAddresses)
+	r.POST("/users/:id/addresses", handlers.CreateUserAddress)
+	r.GET("/users/:id/addresses/:contact_id", handlers.RetrieveUserAddress)
+	r.PUT("/users/:id/addresses/:contact_id", handlers.UpdateUserAddress)
+	r.DELETE("/users/:id/addresses/:contact_id", handlers.DeleteUserAddress)
+	r.GET("/users/:id/groups", handlers.RetrieveUserGroups)
+	r.GET("/users/:id/permissions", handlers.RetrieveUserPermissions)
+	r.GET("/users/:id/data-export", handlers.ExportUserData)
+	r.POST("/users/:id/erase", handlers.EraseUser)
+	r.GET("/attributes", handlers.RetrieveAttributeSchemas)
+	r.GET("/attributes/:name", handlers.RetrieveAttributeSchema)
+	r.PUT("/attributes/:name", handlers.UpdateAttributeSchema)
+	r.DELETE("/attributes/:name", handlers.DeleteAttributeSchema)
+	r.GET("/groups", handlers.RetrieveAllGroups)
+	r.POST("/groups", handlers.CreateGroup)
+	r.GET("/groups/:id", handlers.RetrieveGroup)
+	r.PUT("/groups/:id", handlers.UpdateGroup)
+	r.DELETE("/groups/:id", handlers.DeleteGroup)
+	r.GET("/groups/:id/members", handlers.RetrieveGroupMembers)
+	r.PUT("/groups/:id/members/:user_id", handlers.AddGroupMember)
+	r.DELETE("/groups/:id/members/:user_id", handlers.RemoveGroupMember)
+	r.GET("/roles", handlers.RetrieveAllRoles)
+	r.POST("/roles", handlers.CreateRole)
+	r.GET("/roles/:id", handlers.RetrieveRole)
+	r.PUT("/roles/:id", handlers.UpdateRole)
+	r.DELETE("/roles/:id", handlers.DeleteRole)
+	r.PUT("/roles/:id/users/:user_id", handlers.AssignUserRole)
+	r.DELETE("/roles/:id/users/:user_id", handlers.UnassignUserRole)
+	r.PUT("/roles/:id/groups/:group_id", handlers.AssignGroupRole)
+	r.DELETE("/roles/:id/groups/:group_id", handlers.UnassignGroupRole)
+	r.POST("/sessions", handlers.CreateSession)
+	r.DELETE("/sessions/current", handlers.DeleteCurrentSession)
+	r.GET("/register/challenge", handlers.RetrieveRegistrationChallenge)
+	r.POST("/register", registrationLimit, handlers.Register)
+	r.POST("/register/verify", registrationLimit, handlers.VerifyEmail)
+	r.POST("/register/resend", registrationLimit, handlers.ResendEmailVerification)
+	r.GET("/invitations", handlers.RetrieveAllInvitations)
+	r.POST("/invitations", handlers.CreateInvitation)
+	r.DELETE("/invitations/:id", handlers.RevokeInvitation)
+	r.POST("/invitations/:id/resend", handlers.ResendInvitation)
+	// :id is the token from the invitation
+	r.POST("/invitations/:id/accept", handlers.AcceptInvitation)
+	r.GET("/webhooks", handlers.RetrieveAllWebhooks)
+	r.POST("/webhooks", handlers.CreateWebhook)
+	r.GET("/webhooks/:id", handlers.RetrieveWebhook)
+	r.PUT("/webhooks/:id", handlers.UpdateWebhook)
+	r.DELETE("/webhooks/:id", handlers.DeleteWebhook)
+	r.GET("/webhooks/:id/deliveries", handlers.RetrieveWebhookDeliveries)
+	r.POST("/webhooks/:id/deliveries/:delivery_id/redeliver", handlers.RedeliverWebhookDelivery)
+	r.GET("/events", handlers.RetrieveUserEvents)
+	r.GET("/events/stream", handlers.StreamUserEvents)
+	r.GET("/organizations", handlers.RetrieveAllOrganizations)
+	r.POST("/organizations", handlers.CreateOrganization)
+	r.GET("/organizations/current", handlers.RetrieveCurrentOrganization)
+	r.GET("/organizations/:id", handlers.RetrieveOrganization)
+	r.PUT("/organizations/:id", handlers.UpdateOrganization)
+	r.DELETE("/organizations/:id", handlers.DeleteOrganization)
 
 	return r
 }
@@ -0,0 +1,69 @@
+package sms
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+)
+
+// SMSSender delivers text messages to phone numbers
+type SMSSender interface {
+	Send(to string, message string) error
+}
+
+// LogSender writes messages to the log instead of sending them, for dev
+type LogSender struct{}
+
+func (LogSender) Send(to string, message string) error {
+	log.Printf("SMS to %s: %s", to, message)
+	return nil
+}
+
+// FileSender appends messages to a file instead of sending them, one per
+// line as the number, a tab, then the message. For tests.
+type FileSender struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (s *FileSender) Send(to string, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "%s\t%s\n", to, message)
+	return err
+}
+
+// Register the defaults of the SMS config, once at startup
+func SetDefaults() {
+	viper.SetDefault("sms_sender", "log")
+	viper.SetDefault("sms_file_path", "sms.log")
+}
+
+// The sender chosen by the sms_sender config
+func NewSender() SMSSender {
+	switch viper.GetString("sms_sender") {
+	case "file":
+		return &FileSender{Path: viper.GetString("sms_file_path")}
+	default:
+		return LogSender{}
+	}
+}
+
+func Middleware() gin.HandlerFunc {
+	sender := NewSender()
+
+	return func(c *gin.Context) {
+		c.Set("SMS", sender)
+	}
+}
@@ -0,0 +1,95 @@
+package tenant
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/davidwarshaw/golang-user-crud/api/auth"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/gin-gonic/gin"
+	"github.com/go-pg/pg"
+	"github.com/spf13/viper"
+)
+
+const HEADER = "X-Tenant"
+
+// The organization slug the request names, by header or else by subdomain
+func requestedSlug(c *gin.Context) string {
+	if slug := c.GetHeader(HEADER); slug != "" {
+		return strings.ToLower(slug)
+	}
+
+	domain := strings.ToLower(viper.GetString("tenant_domain"))
+	if domain == "" {
+		return ""
+	}
+	host := c.Request.Host
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	host = strings.ToLower(host)
+	if subdomain := strings.TrimSuffix(host, "."+domain); subdomain != host && !strings.Contains(subdomain, ".") {
+		return subdomain
+	}
+	return ""
+}
+
+// Register the defaults of the tenancy config, once at startup
+func SetDefaults() {
+	// The slug of the organization for requests that don't name one. If it's
+	// empty they must.
+	viper.SetDefault("default_tenant", "default")
+	// e.g. users.example.com, to make acme.users.example.com the acme
+	// organization's
+	viper.SetDefault("tenant_domain", "")
+}
+
+// Middleware works out which organization the request is for, and scopes the
+// request's DB to it by binding ?tenant_id. A signed in user is in the
+// organization their session was made in. Anyone else names one with the
+// X-Tenant header, or the subdomain of tenant_domain the request is made to,
+// or is in default_tenant.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		db := c.MustGet("DB").(*pg.DB)
+
+		slug := requestedSlug(c)
+		var organization models.Organization
+		var err error
+		if caller := auth.Caller(c); caller != nil {
+			organization.Id = caller.TenantId
+			err = db.Model(&organization).WherePK().Select()
+		} else {
+			if slug == "" {
+				slug = viper.GetString("default_tenant")
+			}
+			if slug == "" {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": HEADER + " is required"})
+				return
+			}
+			err = db.Model(&organization).Where("slug = ?", slug).Select()
+		}
+		if err != nil {
+			if err == pg.ErrNoRows {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"message": "Organization not found"})
+				return
+			}
+			c.Error(err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": err.Error()})
+			return
+		}
+		if slug != "" && slug != organization.Slug {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "The token is for another organization"})
+			return
+		}
+
+		c.Set("Tenant", &organization)
+		c.Set("DB", db.WithParam("tenant_id", organization.Id))
+	}
+}
+
+// The organization the request is for
+func Current(c *gin.Context) *models.Organization {
+	return c.MustGet("Tenant").(*models.Organization)
+}
@@ -0,0 +1,133 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "test-admin-token"
+
+var adminHeaders = map[string]string{"Authorization": "Bearer " + testAdminToken}
+
+func putAttributeSchema(ts *httptest.Server, t *testing.T, name string, schema string, private bool, headers map[string]string, expectedStatus int) {
+	jsonData := []byte(fmt.Sprintf(`{"schema": %s, "private": %t}`, schema, private))
+	response := doRequest(t, "PUT", fmt.Sprintf("%s/attributes/%s", ts.URL, name), jsonData, headers)
+	response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+}
+
+func deleteAttributeSchema(ts *httptest.Server, t *testing.T, name string, expectedStatus int) {
+	response := doRequest(t, "DELETE", fmt.Sprintf("%s/attributes/%s", ts.URL, name), nil, adminHeaders)
+	response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+}
+
+func TestUserAttributes(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	// Only admins can register attributes
+	putAttributeSchema(ts, t, "department", `{"type": "string", "enum": ["sales", "engineering"]}`, false, nil, 401)
+	putAttributeSchema(ts, t, "department", `{"type": "string", "enum": ["sales", "engineering"]}`, false, adminHeaders, 200)
+	putAttributeSchema(ts, t, "level", `{"type": "integer", "minimum": 1}`, false, adminHeaders, 200)
+	putAttributeSchema(ts, t, "salary_band", `{"type": "string"}`, true, adminHeaders, 200)
+	putAttributeSchema(ts, t, "broken", `{"type": 12}`, false, adminHeaders, 400)
+
+	// A bad token is rejected outright
+	response := doRequest(t, "GET", ts.URL+"/attributes", nil, map[string]string{"Authorization": "Bearer wrong"})
+	response.Body.Close()
+	assert.Equal(t, 401, response.StatusCode, "Response should be UNAUTHORIZED")
+
+	// Private attributes aren't listed for non-admins
+	response = doRequest(t, "GET", ts.URL+"/attributes/salary_band", nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 404, response.StatusCode, "Response should be NOT FOUND")
+	response = doRequest(t, "GET", ts.URL+"/attributes/salary_band", nil, adminHeaders)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	var user1 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user1)
+
+	// Unregistered and invalid attributes are rejected
+	user1["attributes"] = map[string]interface{}{"shoe_size": 9}
+	jsonData, _ := json.Marshal(user1)
+	createUser(ts, t, jsonData, 400, "Response should be BAD REQUEST")
+	user1["attributes"] = map[string]interface{}{"department": "marketing"}
+	jsonData, _ = json.Marshal(user1)
+	createUser(ts, t, jsonData, 400, "Response should be BAD REQUEST")
+
+	// Only admins can set private attributes
+	user1["attributes"] = map[string]interface{}{"department": "sales", "level": 2, "salary_band": "B"}
+	jsonData, _ = json.Marshal(user1)
+	response = doRequest(t, "POST", ts.URL+"/users", jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 403, response.StatusCode, "Response should be FORBIDDEN")
+
+	response = doRequest(t, "POST", ts.URL+"/users", jsonData, adminHeaders)
+	var newUser1 models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&newUser1)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, "B", newUser1.Attributes["salary_band"])
+
+	// Non-admins don't see private attributes
+	user := retrieveUser(ts, t, newUser1.Id)
+	assert.Equal(t, "sales", user.Attributes["department"])
+	assert.Equal(t, float64(2), user.Attributes["level"])
+	assert.NotContains(t, user.Attributes, "salary_band")
+
+	// A non-admin update keeps the private attributes it couldn't see
+	user1["attributes"] = map[string]interface{}{"department": "engineering", "level": 3}
+	jsonData, _ = json.Marshal(user1)
+	updateUser(ts, t, newUser1.Id, jsonData)
+	response = doRequest(t, "GET", fmt.Sprintf("%s/users/%d", ts.URL, newUser1.Id), nil, adminHeaders)
+	var adminUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&adminUser)
+	response.Body.Close()
+	assert.Equal(t, "engineering", adminUser.Attributes["department"])
+	assert.Equal(t, "B", adminUser.Attributes["salary_band"])
+
+	// Filter by attribute values
+	users := retrieveAllUsers(ts, t, "?attr[department]=engineering")
+	assert.Equal(t, 1, len(users.Data))
+	users = retrieveAllUsers(ts, t, "?attr[level]=3")
+	assert.Equal(t, 1, len(users.Data))
+	users = retrieveAllUsers(ts, t, "?attr[department]=sales")
+	assert.Equal(t, 0, len(users.Data))
+
+	// Only admins can filter by private attributes
+	response = doRequest(t, "GET", ts.URL+"/users?attr[salary_band]=B", nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 403, response.StatusCode, "Response should be FORBIDDEN")
+	response = doRequest(t, "GET", ts.URL+"/users?attr[salary_band]=B", nil, adminHeaders)
+	var adminUsers UserAccounts
+	json.NewDecoder(response.Body).Decode(&adminUsers)
+	response.Body.Close()
+	assert.Equal(t, 1, len(adminUsers.Data))
+
+	// Attributes in use can't be deleted
+	deleteAttributeSchema(ts, t, "department", 409)
+
+	deleteUser(ts, t, newUser1.Id)
+
+	deleteAttributeSchema(ts, t, "department", 204)
+	deleteAttributeSchema(ts, t, "level", 204)
+	deleteAttributeSchema(ts, t, "salary_band", 204)
+}
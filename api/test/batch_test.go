@@ -0,0 +1,123 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func batchRequest(ts *httptest.Server, t *testing.T, method string, batch interface{}, expectedStatus int) models.BatchResult {
+	jsonData, _ := json.Marshal(batch)
+	response := doRequest(t, method, fmt.Sprintf("%s/users:batch", ts.URL), jsonData, nil)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var result models.BatchResult
+	json.NewDecoder(response.Body).Decode(&result)
+
+	return result
+}
+
+func TestUserBatch(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	var goodUser1, goodUser2, badUser3 models.UserIncoming
+	for fixture, user := range map[string]*models.UserIncoming{
+		"fixtures/goodUser1.json": &goodUser1,
+		"fixtures/goodUser2.json": &goodUser2,
+		"fixtures/badUser3.json":  &badUser3,
+	} {
+		userJson, err := ioutil.ReadFile(fixture)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		json.Unmarshal(userJson, user)
+	}
+	badUser3.Password = "a" // Password too short
+
+	// An atomic batch with a bad item creates nothing
+	result := batchRequest(ts, t, "POST", models.UserBatchCreate{
+		Items: []models.UserIncoming{goodUser1, badUser3},
+	}, 400)
+	assert.Equal(t, 424, result.Results[0].Status, "Good item should not be written")
+	assert.Equal(t, 400, result.Results[1].Status, "Bad item should be rejected")
+	assert.Equal(t, 0, len(retrieveAllUsers(ts, t, "").Data), "There should be no users")
+
+	// A best effort batch creates the good items
+	result = batchRequest(ts, t, "POST", models.UserBatchCreate{
+		Mode:  models.BatchModeBestEffort,
+		Items: []models.UserIncoming{goodUser1, badUser3, goodUser2},
+	}, 200)
+	assert.Equal(t, 201, result.Results[0].Status)
+	assert.Equal(t, 400, result.Results[1].Status)
+	assert.Equal(t, 201, result.Results[2].Status)
+	user1Id := result.Results[0].User.Id
+	user2Id := result.Results[2].User.Id
+
+	// Update both atomically
+	goodUser1.FirstName = "Janet"
+	goodUser2.FirstName = "Jack"
+	result = batchRequest(ts, t, "PUT", models.UserBatchUpdate{
+		Items: []models.UserBatchUpdateItem{
+			{UserID: models.UserID{Id: user1Id}, UserIncoming: goodUser1},
+			{UserID: models.UserID{Id: user2Id}, UserIncoming: goodUser2},
+		},
+	}, 200)
+	assert.Equal(t, "Janet", retrieveUser(ts, t, user1Id).FirstName)
+	assert.Equal(t, "Jack", retrieveUser(ts, t, user2Id).FirstName)
+
+	// An item at a stale version isn't written
+	goodUser1.FirstName = "Jane"
+	result = batchRequest(ts, t, "PUT", models.UserBatchUpdate{
+		Items: []models.UserBatchUpdateItem{
+			{UserID: models.UserID{Id: user1Id}, Version: 1, UserIncoming: goodUser1},
+		},
+	}, 412)
+	assert.Equal(t, 412, result.Results[0].Status)
+	assert.Equal(t, "Janet", retrieveUser(ts, t, user1Id).FirstName)
+	result = batchRequest(ts, t, "PUT", models.UserBatchUpdate{
+		Items: []models.UserBatchUpdateItem{
+			{UserID: models.UserID{Id: user1Id}, Version: 2, UserIncoming: goodUser1},
+		},
+	}, 200)
+	assert.Equal(t, "Jane", retrieveUser(ts, t, user1Id).FirstName)
+
+	// Bodies over the limit aren't read
+	os.Setenv("BATCH_MAX_BYTES", "1024")
+	items := make([]models.UserIncoming, 10)
+	for i := range items {
+		items[i] = goodUser1
+	}
+	batchRequest(ts, t, "POST", models.UserBatchCreate{Items: items}, 413)
+	os.Unsetenv("BATCH_MAX_BYTES")
+
+	// A user at a stale version isn't deleted either
+	result = batchRequest(ts, t, "DELETE", models.UserBatchDelete{
+		Ids:      []uint{user1Id},
+		Versions: []uint{1},
+	}, 412)
+	assert.Equal(t, 412, result.Results[0].Status)
+	batchRequest(ts, t, "DELETE", models.UserBatchDelete{
+		Ids:      []uint{user1Id, user2Id},
+		Versions: []uint{1},
+	}, 400)
+
+	// Delete both, and a missing one best effort
+	result = batchRequest(ts, t, "DELETE", models.UserBatchDelete{
+		Mode: models.BatchModeBestEffort,
+		Ids:  []uint{user1Id, user2Id, user2Id + 1000},
+	}, 200)
+	assert.Equal(t, 204, result.Results[0].Status)
+	assert.Equal(t, 204, result.Results[1].Status)
+	assert.Equal(t, 404, result.Results[2].Status)
+	assert.Equal(t, 0, len(retrieveAllUsers(ts, t, "").Data), "There should be no users")
+}
@@ -0,0 +1,167 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/broker"
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/go-pg/pg"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// Records what's published, failing while fail is set
+type recordingPublisher struct {
+	fail     bool
+	messages []broker.Message
+}
+
+func (p *recordingPublisher) Publish(messages ...broker.Message) error {
+	if p.fail {
+		return errors.New("broker unavailable")
+	}
+	p.messages = append(p.messages, messages...)
+	return nil
+}
+
+func (p *recordingPublisher) Close() error {
+	return nil
+}
+
+// Create then delete a user, returning the ids of the events
+func createAndDeleteUser(ts *httptest.Server, t *testing.T, db *pg.DB, userJson []byte) []string {
+	newUser := createUser(ts, t, userJson, 201, "Response should be CREATED")
+	deleteUser(ts, t, newUser.Id)
+
+	var eventIds []string
+	err := db.Model((*models.UserEvent)(nil)).Column("id").Where("user_id = ?", newUser.Id).Order("sequence").Select(&eventIds)
+	assert.Nil(t, err)
+	return eventIds
+}
+
+func TestUserEventPublishing(t *testing.T) {
+	os.Setenv("EVENTS_TOPIC", "users.{organization}")
+	defer os.Unsetenv("EVENTS_TOPIC")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+	db := database.Connect()
+	defer db.Close()
+
+	// Skip the events other tests recorded
+	_, err := handlers.RelayUserEvents(db, broker.NoopPublisher{})
+	assert.Nil(t, err)
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	var user1 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user1)
+	user1["last_name"] = "Smith"
+	jsonData, _ := json.Marshal(user1)
+	updateUser(ts, t, newUser1.Id, jsonData)
+	deleteUser(ts, t, newUser1.Id)
+
+	// Nothing is checkpointed until the broker has the events
+	publisher := &recordingPublisher{fail: true}
+	published, err := handlers.RelayUserEvents(db, publisher)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, published)
+	publisher.fail = false
+	published, err = handlers.RelayUserEvents(db, publisher)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, published)
+
+	// Each is a CloudEvent, in order, keyed by the user
+	types := []string{models.UserEventCreated, models.UserEventUpdated, models.UserEventDeleted}
+	if assert.Len(t, publisher.messages, 3) {
+		for i, message := range publisher.messages {
+			assert.Equal(t, "users.default", message.Topic)
+			assert.Equal(t, fmt.Sprint(newUser1.Id), message.Key)
+
+			var cloudEvent broker.CloudEvent
+			assert.Nil(t, json.Unmarshal(message.Value, &cloudEvent))
+			assert.Equal(t, "1.0", cloudEvent.SpecVersion)
+			assert.Equal(t, message.Id, cloudEvent.Id)
+			assert.Equal(t, "/golang-user-crud/organizations/default", cloudEvent.Source)
+			assert.Equal(t, types[i], cloudEvent.Type)
+			assert.Equal(t, newUser1.PublicId, cloudEvent.Subject)
+			assert.Equal(t, "application/json", cloudEvent.DataContentType)
+			assert.Equal(t, message.Key, cloudEvent.PartitionKey)
+			assert.False(t, cloudEvent.Time.IsZero())
+			assert.Equal(t, "user1", cloudEvent.Data.(map[string]interface{})["user_name"])
+		}
+	}
+
+	// Only once
+	published, err = handlers.RelayUserEvents(db, publisher)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, published)
+
+	// Against real brokers, where they're running
+	if url := os.Getenv("TEST_NATS_URL"); url != "" {
+		conn, err := nats.Connect(url)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		defer conn.Close()
+		subscription, err := conn.SubscribeSync("users.default")
+		assert.Nil(t, err)
+		assert.Nil(t, conn.Flush())
+
+		eventIds := createAndDeleteUser(ts, t, db, goodUser1Json)
+		natsPublisher := broker.NewNATSPublisher(url, 5*time.Second)
+		defer natsPublisher.Close()
+		_, err = handlers.RelayUserEvents(db, natsPublisher)
+		assert.Nil(t, err)
+		for _, eventId := range eventIds {
+			msg, err := subscription.NextMsg(5 * time.Second)
+			if assert.Nil(t, err) {
+				assert.Equal(t, eventId, msg.Header.Get(nats.MsgIdHdr))
+				assert.Equal(t, broker.CloudEventContentType, msg.Header.Get("Content-Type"))
+			}
+		}
+	}
+	if brokers := os.Getenv("TEST_KAFKA_BROKERS"); brokers != "" {
+		eventIds := createAndDeleteUser(ts, t, db, goodUser1Json)
+		kafkaPublisher := broker.NewKafkaPublisher(strings.Split(brokers, ","), 30*time.Second)
+		defer kafkaPublisher.Close()
+		_, err = handlers.RelayUserEvents(db, kafkaPublisher)
+		assert.Nil(t, err)
+
+		// The topic has one partition
+		reader := kafka.NewReader(kafka.ReaderConfig{Brokers: strings.Split(brokers, ","), Topic: "users.default"})
+		defer reader.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var readIds []string
+		for len(readIds) < len(eventIds) {
+			message, err := reader.ReadMessage(ctx)
+			if err != nil {
+				t.Fatalf("Error: %s", err)
+			}
+			var cloudEvent broker.CloudEvent
+			json.Unmarshal(message.Value, &cloudEvent)
+			if cloudEvent.Id == eventIds[0] || len(readIds) > 0 {
+				readIds = append(readIds, cloudEvent.Id)
+			}
+		}
+		assert.Equal(t, eventIds, readIds)
+	}
+}
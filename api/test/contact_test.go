@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type UserEmails struct {
+	Data []models.UserEmail `json:"data"`
+}
+
+func retrieveUserEmails(ts *httptest.Server, t *testing.T, id uint) UserEmails {
+	response := doRequest(t, "GET", fmt.Sprintf("%s/users/%d/emails", ts.URL, id), nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var userEmails UserEmails
+	json.NewDecoder(response.Body).Decode(&userEmails)
+
+	return userEmails
+}
+
+func TestUserContacts(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+
+	// The flat email is the primary email
+	userEmails := retrieveUserEmails(ts, t, newUser1.Id)
+	assert.Equal(t, 1, len(userEmails.Data))
+	assert.Equal(t, "user1@test.com", userEmails.Data[0].Email)
+	assert.True(t, userEmails.Data[0].IsPrimary)
+	firstEmailId := userEmails.Data[0].Id
+
+	// Emails are checked like the user's
+	jsonData, _ := json.Marshal(models.UserEmail{Contact: models.Contact{Label: "work"}, Email: "not-an-email"})
+	assert.Equal(t, 400, statusOf(ts, t, "POST", fmt.Sprintf("/users/%d/emails", newUser1.Id), jsonData, nil))
+
+	// Add a work email, and make it primary
+	jsonData, _ = json.Marshal(models.UserEmail{Contact: models.Contact{Label: "work"}, Email: "user1@work.com"})
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users/%d/emails", ts.URL, newUser1.Id), jsonData, nil)
+	var workEmail models.UserEmail
+	json.NewDecoder(response.Body).Decode(&workEmail)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.False(t, workEmail.IsPrimary, "Only the first email should be primary by default")
+
+	workEmail.IsPrimary = true
+	jsonData, _ = json.Marshal(workEmail)
+	response = doRequest(t, "PUT", fmt.Sprintf("%s/users/%d/emails/%d", ts.URL, newUser1.Id, workEmail.Id), jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, "user1@work.com", retrieveUser(ts, t, newUser1.Id).Email, "Primary email should be projected onto the user")
+
+	// Deleting the primary promotes the other
+	response = doRequest(t, "DELETE", fmt.Sprintf("%s/users/%d/emails/%d", ts.URL, newUser1.Id, workEmail.Id), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 204, response.StatusCode, "Response should be NO_CONTENT")
+	userEmails = retrieveUserEmails(ts, t, newUser1.Id)
+	assert.Equal(t, firstEmailId, userEmails.Data[0].Id)
+	assert.True(t, userEmails.Data[0].IsPrimary)
+	assert.Equal(t, "user1@test.com", retrieveUser(ts, t, newUser1.Id).Email, "Primary email should be projected onto the user")
+
+	// Phones are normalized like the primary phone number
+	jsonData, _ = json.Marshal(models.UserPhone{Contact: models.Contact{Label: "mobile"}, PhoneNumber: "07911 123456", PhoneRegion: "GB"})
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users/%d/phones", ts.URL, newUser1.Id), jsonData, nil)
+	var userPhone models.UserPhone
+	json.NewDecoder(response.Body).Decode(&userPhone)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, "+447911123456", userPhone.PhoneNumber)
+
+	// Addresses have no flat field, the first is primary
+	jsonData, _ = json.Marshal(models.UserAddress{Line1: "1 Main St", City: "Springfield", Country: "us"})
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users/%d/addresses", ts.URL, newUser1.Id), jsonData, nil)
+	var userAddress models.UserAddress
+	json.NewDecoder(response.Body).Decode(&userAddress)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.True(t, userAddress.IsPrimary)
+	assert.Equal(t, "US", userAddress.Country)
+
+	// Clean up
+	deleteUser(ts, t, newUser1.Id)
+}
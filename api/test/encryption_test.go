@@ -0,0 +1,105 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+)
+
+const testMasterKeys = "test2=AgICAgICAgICAgICAgICAgICAgICAgICAgICAgICAgI=,test1=AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE="
+const testIndexKey = "AwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwMDAwM="
+
+func TestUserEncryption(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Setenv("PII_MASTER_KEYS", testMasterKeys)
+	os.Setenv("PII_INDEX_KEY", testIndexKey)
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Unsetenv("PII_MASTER_KEYS")
+	defer os.Unsetenv("PII_INDEX_KEY")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	assert.Equal(t, "Jane", newUser1.FirstName)
+	assert.Equal(t, "user1@test.com", newUser1.Email)
+
+	// Only ciphertext is stored
+	db := database.Connect()
+	defer db.Close()
+	var email, emailIndex, contactEmail, lastName string
+	_, err = db.QueryOne(pg.Scan(&email, &emailIndex, &lastName),
+		"SELECT email, email_index, last_name FROM user_accounts WHERE id = ?", newUser1.Id)
+	assert.Nil(t, err)
+	assert.NotContains(t, email, "user1")
+	assert.NotContains(t, lastName, "Doe")
+	assert.Len(t, emailIndex, 64)
+	_, err = db.QueryOne(pg.Scan(&contactEmail), "SELECT email FROM user_emails WHERE user_id = ?", newUser1.Id)
+	assert.Nil(t, err)
+	assert.NotContains(t, contactEmail, "user1")
+
+	// But it's decrypted when read
+	assert.Equal(t, "Doe", retrieveUser(ts, t, newUser1.Id).LastName)
+	assert.Equal(t, "user1@test.com", retrieveUserEmails(ts, t, newUser1.Id).Data[0].Email)
+
+	// And matched exactly on the blind indexes
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-email/User1@Test.com", 200).Id)
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-phone/"+url.PathEscape("+1 (555) 555-1234"), 200).Id)
+	lookUpUser(ts, t, "/users/by-email/nobody@test.com", 404)
+	found := retrieveAllUsers(ts, t, "?last_name=Doe&email=user1@test.com")
+	if assert.Len(t, found.Data, 1) {
+		assert.Equal(t, newUser1.Id, found.Data[0].Id)
+	}
+	assert.Len(t, retrieveAllUsers(ts, t, "?last_name=Do").Data, 0)
+
+	// Updates are encrypted too
+	var user1 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user1)
+	user1["email"] = "jane@test.com"
+	jsonData, _ := json.Marshal(user1)
+	assert.Equal(t, "jane@test.com", updateUser(ts, t, newUser1.Id, jsonData).Email)
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-email/jane@test.com", 200).Id)
+	lookUpUser(ts, t, "/users/by-email/user1@test.com", 404)
+
+	// So are the addresses invitations are sent to, which stay unique
+	response := doRequest(t, "POST", ts.URL+"/invitations", []byte(`{"email": "invitee@test.com"}`), adminHeaders)
+	var invitation models.Invitation
+	json.NewDecoder(response.Body).Decode(&invitation)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, "invitee@test.com", invitation.Email)
+	var invitationEmail string
+	_, err = db.QueryOne(pg.Scan(&invitationEmail), "SELECT email FROM invitations WHERE id = ?", invitation.Id)
+	assert.Nil(t, err)
+	assert.NotContains(t, invitationEmail, "invitee")
+	assert.Equal(t, 409, statusOf(ts, t, "POST", "/invitations", []byte(`{"email": "Invitee@test.com"}`), adminHeaders))
+	deleteUser(ts, t, invitation.UserId)
+
+	// Values that look like ciphertext aren't accepted
+	user1["last_name"] = "enc1:1:AAAA"
+	jsonData, _ = json.Marshal(user1)
+	assert.Equal(t, 400, statusOf(ts, t, "PUT", fmt.Sprintf("/users/%d", newUser1.Id), jsonData, adminHeaders))
+
+	// Nothing is encrypted without a master key, or decrypted, so what was
+	// is read as it's stored until it's rotated out
+	os.Unsetenv("PII_MASTER_KEYS")
+	assert.True(t, strings.HasPrefix(retrieveUser(ts, t, newUser1.Id).Email, "enc1:"))
+
+	deleteUser(ts, t, newUser1.Id)
+}
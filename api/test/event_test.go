@@ -0,0 +1,154 @@
+package test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+)
+
+func retrieveUserEvents(ts *httptest.Server, t *testing.T, query string) models.UserEventPage {
+	response := doRequest(t, "GET", ts.URL+"/events"+query, nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var page models.UserEventPage
+	json.NewDecoder(response.Body).Decode(&page)
+	return page
+}
+
+// An event read from a stream
+type streamedEvent struct {
+	id    string
+	event string
+	data  models.UserEvent
+}
+
+// Read the next event from the stream, skipping comments
+func readStreamedEvent(t *testing.T, reader *bufio.Reader) streamedEvent {
+	var streamed streamedEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "" && streamed.id != "":
+			return streamed
+		case strings.HasPrefix(line, "id: "):
+			streamed.id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "event: "):
+			streamed.event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &streamed.data)
+		}
+	}
+}
+
+func TestUserEvents(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+	db := database.Connect()
+	defer db.Close()
+
+	// Start after whatever's already recorded
+	var start int64
+	_, err := db.QueryOne(pg.Scan(&start), "SELECT coalesce(max(sequence), 0) FROM user_events")
+	assert.Nil(t, err)
+	after := fmt.Sprintf("?after=%d", start)
+	assert.Equal(t, 400, statusOf(ts, t, "GET", "/events?wait=61", nil, nil))
+
+	// Creating and updating a user are recorded in order
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	var user1 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user1)
+	user1["last_name"] = "Smith"
+	jsonData, _ := json.Marshal(user1)
+	updateUser(ts, t, newUser1.Id, jsonData)
+
+	page := retrieveUserEvents(ts, t, after)
+	if assert.Len(t, page.Data, 2) {
+		assert.Equal(t, models.UserEventCreated, page.Data[0].Type)
+		assert.Equal(t, models.UserEventUpdated, page.Data[1].Type)
+		assert.True(t, page.Data[0].Sequence < page.Data[1].Sequence)
+		assert.Equal(t, page.Data[1].Sequence, page.Next)
+		assert.Equal(t, newUser1.Id, page.Data[0].UserId)
+		if assert.NotNil(t, page.Data[1].Data) {
+			assert.Equal(t, "Smith", page.Data[1].Data.LastName)
+		}
+	}
+
+	// Resuming from a checkpoint only gets what came after it
+	page = retrieveUserEvents(ts, t, fmt.Sprintf("?after=%d", page.Data[0].Sequence))
+	assert.Len(t, page.Data, 1)
+	checkpoint := page.Next
+	page = retrieveUserEvents(ts, t, fmt.Sprintf("?after=%d", checkpoint))
+	assert.Len(t, page.Data, 0)
+	assert.Equal(t, checkpoint, page.Next)
+
+	// Failed changes aren't recorded
+	createUser(ts, t, goodUser1Json, 400, "Response should be BAD REQUEST")
+	assert.Len(t, retrieveUserEvents(ts, t, fmt.Sprintf("?after=%d", checkpoint)).Data, 0)
+
+	// A long poll waits for the next event
+	polled := make(chan models.UserEventPage)
+	go func() {
+		polled <- retrieveUserEvents(ts, t, fmt.Sprintf("?after=%d&wait=30", checkpoint))
+	}()
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, 200, statusOf(ts, t, "POST", "/users/"+newUser1.PublicId+"/suspend", []byte(`{"reason": "spam"}`), adminHeaders))
+	select {
+	case page = <-polled:
+		if assert.Len(t, page.Data, 1) {
+			assert.Equal(t, models.UserEventUpdated, page.Data[0].Type)
+			assert.Equal(t, models.UserStatusSuspended, page.Data[0].Data.Status)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("The long poll wasn't woken by the event")
+	}
+
+	// A stream resumes after the last event received, then follows new ones
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/events/stream"+after, nil)
+	request.Header.Set("Last-Event-ID", fmt.Sprint(checkpoint))
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+	reader := bufio.NewReader(response.Body)
+	streamed := readStreamedEvent(t, reader)
+	assert.Equal(t, fmt.Sprint(page.Next), streamed.id)
+	assert.Equal(t, models.UserEventUpdated, streamed.event)
+
+	deleteUser(ts, t, newUser1.Id)
+	streamed = readStreamedEvent(t, reader)
+	assert.Equal(t, models.UserEventDeleted, streamed.event)
+	assert.Equal(t, newUser1.Id, streamed.data.UserId)
+	assert.Equal(t, streamed.id, fmt.Sprint(streamed.data.Sequence))
+}
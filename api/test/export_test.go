@@ -0,0 +1,56 @@
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportUsers(ts *httptest.Server, t *testing.T, query string, expectedStatus int) string {
+	response := doRequest(t, "GET", fmt.Sprintf("%s/users/export%s", ts.URL, query), nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	body, _ := ioutil.ReadAll(response.Body)
+	return string(body)
+}
+
+func TestUserExport(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	goodUser2Json, err := ioutil.ReadFile("fixtures/goodUser2.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	newUser2 := createUser(ts, t, goodUser2Json, 201, "Response should be CREATED")
+
+	// CSV with a subset of fields
+	csv := exportUsers(ts, t, "?fields=user_name,email", 200)
+	assert.Equal(t, "user_name,email\nuser1,user1@test.com\nuser2,user2@test.com\n", csv)
+
+	// NDJSON with a filter, never including the password hash
+	ndjson := exportUsers(ts, t, "?format=ndjson&user_name=user2", 200)
+	lines := strings.Split(strings.TrimSpace(ndjson), "\n")
+	assert.Equal(t, 1, len(lines))
+	assert.Contains(t, lines[0], `"user_name":"user2"`)
+	assert.NotContains(t, lines[0], "password_hash")
+
+	// Unknown and secret fields are rejected
+	exportUsers(ts, t, "?fields=password_hash", 400)
+
+	// Clean up
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
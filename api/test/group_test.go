@@ -0,0 +1,158 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type Groups struct {
+	Data []models.Group `json:"data"`
+}
+
+type GroupMembers struct {
+	Data []models.UserOutgoing `json:"data"`
+}
+
+func createGroup(ts *httptest.Server, t *testing.T, name string, parentId *uint) models.Group {
+	jsonData, _ := json.Marshal(models.GroupBase{Name: name, ParentId: parentId})
+	response := doRequest(t, "POST", ts.URL+"/groups", jsonData, nil)
+	defer response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+
+	var group models.Group
+	json.NewDecoder(response.Body).Decode(&group)
+
+	return group
+}
+
+func updateGroup(ts *httptest.Server, t *testing.T, group models.Group, expectedStatus int) {
+	jsonData, _ := json.Marshal(group.GroupBase)
+	response := doRequest(t, "PUT", fmt.Sprintf("%s/groups/%d", ts.URL, group.Id), jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+}
+
+func addGroupMember(ts *httptest.Server, t *testing.T, groupId uint, userId uint, expectedStatus int) {
+	response := doRequest(t, "PUT", fmt.Sprintf("%s/groups/%d/members/%d", ts.URL, groupId, userId), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+}
+
+func retrieveGroupMembers(ts *httptest.Server, t *testing.T, groupId uint, query string) GroupMembers {
+	response := doRequest(t, "GET", fmt.Sprintf("%s/groups/%d/members%s", ts.URL, groupId, query), nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var members GroupMembers
+	json.NewDecoder(response.Body).Decode(&members)
+
+	return members
+}
+
+func retrieveUserGroups(ts *httptest.Server, t *testing.T, userId uint, query string) Groups {
+	response := doRequest(t, "GET", fmt.Sprintf("%s/users/%d/groups%s", ts.URL, userId, query), nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var groups Groups
+	json.NewDecoder(response.Body).Decode(&groups)
+
+	return groups
+}
+
+func deleteGroup(ts *httptest.Server, t *testing.T, groupId uint) {
+	response := doRequest(t, "DELETE", fmt.Sprintf("%s/groups/%d", ts.URL, groupId), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 204, response.StatusCode, "Response should be NO_CONTENT")
+}
+
+func TestGroups(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	goodUser2Json, err := ioutil.ReadFile("fixtures/goodUser2.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	newUser2 := createUser(ts, t, goodUser2Json, 201, "Response should be CREATED")
+
+	// engineering > backend > databases
+	engineering := createGroup(ts, t, "engineering", nil)
+	backend := createGroup(ts, t, "backend", &engineering.Id)
+	databases := createGroup(ts, t, "databases", &backend.Id)
+	assert.Equal(t, backend.Id, *databases.ParentId)
+
+	// Group names are unique
+	jsonData, _ := json.Marshal(models.GroupBase{Name: "engineering"})
+	response := doRequest(t, "POST", ts.URL+"/groups", jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 400, response.StatusCode, "Response should be BAD REQUEST")
+
+	// A group can't be nested in itself, or in a group nested in it
+	engineering.ParentId = &engineering.Id
+	updateGroup(ts, t, engineering, 400)
+	engineering.ParentId = &databases.Id
+	updateGroup(ts, t, engineering, 400)
+	engineering.ParentId = nil
+	engineering.Description = "Everyone who builds things"
+	updateGroup(ts, t, engineering, 200)
+
+	// Membership is many-to-many
+	addGroupMember(ts, t, databases.Id, newUser1.Id, 204)
+	addGroupMember(ts, t, backend.Id, newUser1.Id, 204)
+	addGroupMember(ts, t, backend.Id, newUser1.Id, 204)
+	addGroupMember(ts, t, engineering.Id, newUser2.Id, 204)
+	addGroupMember(ts, t, engineering.Id, 999999, 404)
+
+	members := retrieveGroupMembers(ts, t, backend.Id, "")
+	assert.Equal(t, 1, len(members.Data))
+	assert.Equal(t, newUser1.Id, members.Data[0].Id)
+
+	// Nested groups' members are members of the groups they're in
+	members = retrieveGroupMembers(ts, t, engineering.Id, "?nested=true")
+	assert.Equal(t, 2, len(members.Data))
+	users := retrieveAllUsers(ts, t, fmt.Sprintf("?group=%d", engineering.Id))
+	assert.Equal(t, 2, len(users.Data))
+	users = retrieveAllUsers(ts, t, fmt.Sprintf("?group=%d", databases.Id))
+	assert.Equal(t, 1, len(users.Data))
+	assert.Equal(t, newUser1.Id, users.Data[0].Id)
+
+	groups := retrieveUserGroups(ts, t, newUser1.Id, "")
+	assert.Equal(t, 2, len(groups.Data))
+	groups = retrieveUserGroups(ts, t, newUser1.Id, "?nested=true")
+	assert.Equal(t, 3, len(groups.Data))
+
+	// Removing a member
+	response = doRequest(t, "DELETE", fmt.Sprintf("%s/groups/%d/members/%d", ts.URL, databases.Id, newUser1.Id), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 204, response.StatusCode, "Response should be NO_CONTENT")
+	response = doRequest(t, "DELETE", fmt.Sprintf("%s/groups/%d/members/%d", ts.URL, databases.Id, newUser1.Id), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 404, response.StatusCode, "Response should be NOT FOUND")
+
+	// Deleting a group moves its nested groups up to its parent
+	deleteGroup(ts, t, backend.Id)
+	response = doRequest(t, "GET", fmt.Sprintf("%s/groups/%d", ts.URL, databases.Id), nil, nil)
+	var movedGroup models.Group
+	json.NewDecoder(response.Body).Decode(&movedGroup)
+	response.Body.Close()
+	assert.Equal(t, engineering.Id, *movedGroup.ParentId)
+
+	deleteGroup(ts, t, databases.Id)
+	deleteGroup(ts, t, engineering.Id)
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
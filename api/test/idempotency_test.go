@@ -0,0 +1,74 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	headers := map[string]string{"Idempotency-Key": "test-create-user1"}
+	for name, value := range adminHeaders {
+		headers[name] = value
+	}
+
+	// The first request creates the user
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users", ts.URL), goodUser1Json, headers)
+	var newUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&newUser)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+
+	// A retry replays the original response instead of failing on the duplicate
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users", ts.URL), goodUser1Json, headers)
+	var replayedUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&replayedUser)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be replayed as CREATED")
+	assert.Equal(t, "true", response.Header.Get("Idempotent-Replayed"))
+	assert.Equal(t, newUser.Id, replayedUser.Id, "Replayed user should be the same user")
+
+	// Reusing the key for a different body is rejected
+	var userIncoming models.UserIncoming
+	json.Unmarshal(goodUser1Json, &userIncoming)
+	userIncoming.UserName = "user9"
+	jsonData, _ := json.Marshal(userIncoming)
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users", ts.URL), jsonData, headers)
+	response.Body.Close()
+	assert.Equal(t, 422, response.StatusCode, "Response should be UNPROCESSABLE_ENTITY")
+
+	// So is reusing it with a different query
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users?ref=retry", ts.URL), goodUser1Json, headers)
+	response.Body.Close()
+	assert.Equal(t, 422, response.StatusCode, "Response should be UNPROCESSABLE_ENTITY")
+
+	// Another caller's key is their own, so they don't get the replay. Nor do
+	// anonymous callers, whose keys are ignored as they can't be told apart.
+	response = doRequest(t, "POST", fmt.Sprintf("%s/users", ts.URL), goodUser1Json, map[string]string{"Idempotency-Key": "test-create-user1"})
+	response.Body.Close()
+	assert.Equal(t, 400, response.StatusCode, "Response should be BAD REQUEST")
+	assert.Empty(t, response.Header.Get("Idempotent-Replayed"))
+
+	// Clean up
+	response = doRequest(t, "DELETE", fmt.Sprintf("%s/users/%d", ts.URL, newUser.Id), nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 204, response.StatusCode, "Response should be NO_CONTENT")
+}
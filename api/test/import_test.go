@@ -0,0 +1,77 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+var importCsv = []byte(`Login,password,email,primary_phone_number
+user1,secret1min8chars,user1@test.com,+15555551234
+user2,short,user2@test.com,555-555-5678
+user1,secret1min8chars,user1@test.com,+15555551234
+`)
+
+var importNdjson = []byte(`{"user_name": "user3", "password": "secret3min8chars", "email": "user3@test.com", "primary_phone_number": "555-555-9012"}
+not json
+`)
+
+func importUsers(ts *httptest.Server, t *testing.T, query string, contentType string, body []byte, expectedStatus int) models.ImportJob {
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users/import%s", ts.URL, query), body, map[string]string{"Content-Type": contentType})
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var job models.ImportJob
+	json.NewDecoder(response.Body).Decode(&job)
+
+	return job
+}
+
+func TestUserImport(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	// A dry run reports without creating
+	job := importUsers(ts, t, "?dry_run=true&map[Login]=user_name", "text/csv", importCsv, 200)
+	assert.Equal(t, 3, job.Processed)
+	assert.Equal(t, 1, job.Created)
+	assert.Equal(t, 2, job.Failed)
+	assert.Equal(t, 2, job.Errors[0].Row, "Short password should be reported")
+	assert.Equal(t, 3, job.Errors[1].Row, "Duplicate user_name should be reported")
+	assert.Equal(t, 0, len(retrieveAllUsers(ts, t, "").Data), "There should be no users after a dry run")
+
+	// A real import creates the valid users
+	job = importUsers(ts, t, "?map[Login]=user_name", "text/csv", importCsv, 200)
+	assert.Equal(t, 1, job.Created)
+	userAccounts := retrieveAllUsers(ts, t, "")
+	assert.Equal(t, 1, len(userAccounts.Data))
+	assert.Equal(t, "+15555551234", userAccounts.Data[0].PrimaryPhoneNumber, "Phone number should be normalized")
+
+	// Unknown fields in the mapping are rejected
+	importUsers(ts, t, "?map[Login]=login", "text/csv", importCsv, 400)
+
+	// A background NDJSON import can be polled
+	job = importUsers(ts, t, "?async=true", "application/x-ndjson", importNdjson, 202)
+	for i := 0; i < 50 && job.Status == models.ImportStatusRunning; i++ {
+		time.Sleep(100 * time.Millisecond)
+		response := doRequest(t, "GET", fmt.Sprintf("%s/users/import/%d", ts.URL, job.Id), nil, nil)
+		json.NewDecoder(response.Body).Decode(&job)
+		response.Body.Close()
+	}
+	assert.Equal(t, models.ImportStatusCompleted, job.Status)
+	assert.Equal(t, 1, job.Created)
+	assert.Equal(t, 1, job.Failed)
+
+	// Clean up
+	userAccounts = retrieveAllUsers(ts, t, "")
+	for _, userAccount := range userAccounts.Data {
+		deleteUser(ts, t, userAccount.Id)
+	}
+}
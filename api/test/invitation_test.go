@@ -0,0 +1,111 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// The token in the last message mailed, which ends with it
+func lastMailedToken(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	var message mail.Message
+	json.Unmarshal([]byte(lines[len(lines)-1]), &message)
+	words := strings.Fields(message.Body)
+	return words[len(words)-1]
+}
+
+func TestInvitations(t *testing.T) {
+	// Mail invitations to a file
+	mailFile, err := ioutil.TempFile("", "mail")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	mailFile.Close()
+	defer os.Remove(mailFile.Name())
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Setenv("MAILER", "file")
+	os.Setenv("MAIL_FILE_PATH", mailFile.Name())
+	os.Setenv("INVITATION_RESEND_INTERVAL", "0s")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Unsetenv("MAILER")
+	defer os.Unsetenv("MAIL_FILE_PATH")
+	defer os.Unsetenv("INVITATION_RESEND_INTERVAL")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	// Invite someone, who is a pending user until they accept
+	jsonData := []byte(`{"email": "invitee@example.com", "first_name": "Invited"}`)
+	response := doRequest(t, "POST", ts.URL+"/invitations", jsonData, adminHeaders)
+	var invitation models.Invitation
+	json.NewDecoder(response.Body).Decode(&invitation)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, "invitee@example.com", invitation.Email)
+	assert.Equal(t, "pending", retrieveUser(ts, t, invitation.UserId).Status)
+	assert.Equal(t, 409, statusOf(ts, t, "POST", "/invitations", jsonData, adminHeaders))
+
+	contents, _ := ioutil.ReadFile(mailFile.Name())
+	assert.Contains(t, string(contents), `"to":"invitee@example.com"`)
+	firstToken := lastMailedToken(t, mailFile.Name())
+
+	// Outstanding invitations are listed
+	response = doRequest(t, "GET", ts.URL+"/invitations", nil, adminHeaders)
+	var invitations struct{ Data []models.Invitation }
+	json.NewDecoder(response.Body).Decode(&invitations)
+	response.Body.Close()
+	assert.Equal(t, 1, len(invitations.Data))
+
+	// Resending invalidates the earlier token
+	invitationPath := fmt.Sprintf("/invitations/%d", invitation.Id)
+	assert.Equal(t, 200, statusOf(ts, t, "POST", invitationPath+"/resend", nil, adminHeaders))
+	token := lastMailedToken(t, mailFile.Name())
+	assert.NotEqual(t, firstToken, token)
+
+	acceptance := []byte(`{"user_name": "invitee", "password": "invitedmin8chars"}`)
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/invitations/"+firstToken+"/accept", acceptance, nil))
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/invitations/"+token+"x/accept", acceptance, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/invitations/"+token+"/accept", []byte(`{"user_name": "invitee"}`), nil))
+
+	// Accepting makes the user active, and able to sign in
+	response = doRequest(t, "POST", ts.URL+"/invitations/"+token+"/accept", acceptance, nil)
+	var user models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, invitation.UserId, user.Id)
+	assert.Equal(t, "invitee", user.UserName)
+	assert.Equal(t, "active", user.Status)
+	signIn(ts, t, "invitee", "invitedmin8chars", 201)
+
+	// An invitation can only be accepted once
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/invitations/"+token+"/accept", acceptance, nil))
+	assert.Equal(t, 404, statusOf(ts, t, "POST", invitationPath+"/resend", nil, adminHeaders))
+	deleteUser(ts, t, user.Id)
+
+	// Revoking an invitation deletes its pending user
+	jsonData = []byte(`{"email": "revoked@example.com"}`)
+	response = doRequest(t, "POST", ts.URL+"/invitations", jsonData, adminHeaders)
+	json.NewDecoder(response.Body).Decode(&invitation)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	token = lastMailedToken(t, mailFile.Name())
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/invitations/%d", invitation.Id), nil, adminHeaders))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", invitation.UserId), nil, adminHeaders))
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/invitations/"+token+"/accept", acceptance, nil))
+}
@@ -0,0 +1,85 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func lookUpUser(ts *httptest.Server, t *testing.T, path string, expectedStatus int) models.UserOutgoing {
+	response := doRequest(t, "GET", ts.URL+path, nil, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var userOutgoing models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&userOutgoing)
+
+	return userOutgoing
+}
+
+func TestUserLookups(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+
+	// Anonymous callers can't look users up, even with users:lookup
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users/by-username/user1", nil, nil))
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users/by-email/user1@test.com", nil, nil))
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users/by-phone/+15555551234", nil, nil))
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users/by-email/nobody@test.com", nil, nil))
+	user1Headers := signIn(ts, t, "user1", "secret1min8chars", 201)
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/by-username/user1", nil, user1Headers))
+
+	// Nor can signed in users, or filter by email, without users:lookup
+	os.Setenv("ANONYMOUS_PERMISSIONS", "users:read")
+	assert.Equal(t, 403, statusOf(ts, t, "GET", "/users/by-username/user1", nil, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "GET", "/users/by-email/user1@test.com", nil, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "GET", "/users?email=user1@test.com", nil, user1Headers))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users?last_name=Doe", nil, user1Headers))
+	os.Setenv("ANONYMOUS_PERMISSIONS", openPermissions)
+
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-username/user1", 200).Id)
+	lookUpUser(ts, t, "/users/by-username/nobody", 404)
+
+	// Emails match whatever their case
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-email/User1@Test.com", 200).Id)
+	lookUpUser(ts, t, "/users/by-email/nobody@test.com", 404)
+	lookUpUser(ts, t, "/users/by-email/not-an-email", 400)
+
+	// Phone numbers match in any format
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-phone/"+url.PathEscape("+1 (555) 555-1234"), 200).Id)
+	assert.Equal(t, newUser1.Id, lookUpUser(ts, t, "/users/by-phone/5555551234?region=US", 200).Id)
+	lookUpUser(ts, t, "/users/by-phone/12", 400)
+
+	// A phone number more than one user has doesn't pick one, but an email
+	// can't be shared, whatever its case
+	var user2 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user2)
+	user2["user_name"] = "user2"
+	user2["email"] = "USER1@test.com"
+	jsonData, _ := json.Marshal(user2)
+	createUser(ts, t, jsonData, 400, "Response should be BAD_REQUEST")
+	user2["email"] = "user2@test.com"
+	jsonData, _ = json.Marshal(user2)
+	newUser2 := createUser(ts, t, jsonData, 201, "Response should be CREATED")
+	lookUpUser(ts, t, "/users/by-phone/"+url.PathEscape("+15555551234"), 409)
+
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 
 	"github.com/davidwarshaw/golang-user-crud/api/models"
@@ -14,6 +15,16 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// The suite predates access control, so it runs with the service open to
+// everyone, as it was. The access control tests lock it down themselves.
+const openPermissions = "users:read,users:write,users:delete,users:lookup,groups:read,groups:manage"
+
+func TestMain(m *testing.M) {
+	os.Setenv("ANONYMOUS_PERMISSIONS", openPermissions)
+	server.Configure()
+	os.Exit(m.Run())
+}
+
 type UserAccounts struct {
 	Data       []models.UserAccount `json:"data"`
 	Pagination models.Pagination    `json:"pagination"`
@@ -98,16 +109,30 @@ func TestUserRoute(t *testing.T) {
 	// Add some users
 	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
 	assert.Equal(t, newUser1.UserName, "user1", "User Name should match")
-	assert.Equal(t, newUser1.PrimaryPhoneNumber, "(555) 555-1234", "Primary Phone Number should be formatted")
+	assert.Equal(t, newUser1.PrimaryPhoneNumber, "+15555551234", "Primary Phone Number should be stored as E.164")
+	assert.Equal(t, newUser1.PrimaryPhoneNumberDisplay, "(555) 555-1234", "Primary Phone Number should be formatted for display")
 	assert.Greater(t, newUser1.Id, uint(0), "Id should be set by DB (greater than 0)")
 
 	newUser2 := createUser(ts, t, goodUser2Json, 201, "Response should be CREATED")
 	assert.Equal(t, newUser2.UserName, "user2", "User Name should match")
 	assert.Greater(t, newUser2.Id, uint(0), "Id should be set by DB (greater than 0)")
 
+	// An international number, parsed in the user's country
+	var internationalUser models.UserIncoming
+	json.Unmarshal(goodUser2Json, &internationalUser)
+	internationalUser.UserName = "user4"
+	internationalUser.Email = "user4@test.com"
+	internationalUser.Country = "GB"
+	internationalUser.PrimaryPhoneNumber = "07911 123456"
+	jsonData, _ := json.Marshal(internationalUser)
+	newUser4 := createUser(ts, t, jsonData, 201, "Response should be CREATED")
+	assert.Equal(t, newUser4.PrimaryPhoneNumber, "+447911123456", "Primary Phone Number should be stored as E.164")
+	assert.Equal(t, newUser4.PrimaryPhoneNumberDisplay, "07911 123456", "Primary Phone Number should be formatted for the country")
+	assert.Equal(t, newUser4.PrimaryPhoneNumberType, "mobile", "Primary Phone Number type should be detected")
+	deleteUser(ts, t, newUser4.Id)
+
 	// Users with bad data
 	var badUser models.UserIncoming
-	var jsonData []byte
 	json.Unmarshal(badUser3Json, &badUser)
 	badUser.UserName = "user1" // Duplicate username
 	jsonData, _ = json.Marshal(badUser)
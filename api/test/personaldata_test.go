@@ -0,0 +1,98 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+)
+
+func exportUserData(ts *httptest.Server, t *testing.T, publicId string) models.UserDataExport {
+	response := doRequest(t, "GET", ts.URL+"/users/"+publicId+"/data-export", nil, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, "attachment; filename=user-"+publicId+".json", response.Header.Get("Content-Disposition"))
+
+	var export models.UserDataExport
+	json.NewDecoder(response.Body).Decode(&export)
+
+	return export
+}
+
+func TestUserDataExportAndErasure(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	signIn(ts, t, "user1", "secret1min8chars", 201)
+	renameUser(ts, t, newUser1.Id, "janedoe", 200)
+
+	// The export has the user and everything about them
+	export := exportUserData(ts, t, newUser1.PublicId)
+	assert.Equal(t, "janedoe", export.User.UserName)
+	assert.Equal(t, 1, len(export.Emails))
+	assert.Equal(t, "user1@test.com", export.Emails[0].Email)
+	assert.Equal(t, 1, len(export.Phones))
+	assert.Equal(t, 1, len(export.NameChanges))
+	assert.Equal(t, "user1", export.NameChanges[0].UserName)
+	assert.Equal(t, 1, len(export.Sessions))
+	assert.Equal(t, 0, len(export.Erasures))
+
+	// Erasing needs its own permission, and a reason
+	path := "/users/" + newUser1.PublicId + "/erase"
+	reason := []byte(`{"reason": "Erasure request #42"}`)
+	assert.Equal(t, 401, statusOf(ts, t, "POST", path, reason, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "POST", path, []byte(`{}`), adminHeaders))
+
+	response := doRequest(t, "POST", ts.URL+path, reason, adminHeaders)
+	var erased models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&erased)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, newUser1.PublicId, erased.PublicId)
+	assert.Equal(t, "", erased.UserName)
+	assert.Equal(t, "", erased.LastName)
+	assert.Equal(t, "", erased.Email)
+	assert.Equal(t, "", erased.PrimaryPhoneNumber)
+	assert.Equal(t, "disabled", erased.Status)
+
+	// Nothing personal is left but the record of the erasure
+	signIn(ts, t, "janedoe", "secret1min8chars", 401)
+	export = exportUserData(ts, t, newUser1.PublicId)
+	assert.Equal(t, 0, len(export.Emails))
+	assert.Equal(t, 0, len(export.Phones))
+	assert.Equal(t, 0, len(export.NameChanges))
+	assert.Equal(t, 0, len(export.Sessions))
+	assert.Equal(t, 1, len(export.Erasures))
+	assert.Equal(t, "Erasure request #42", export.Erasures[0].Reason)
+	assert.Nil(t, export.Erasures[0].ErasedBy)
+	db := database.Connect()
+	defer db.Close()
+	var personalEvents int
+	_, err = db.QueryOne(pg.Scan(&personalEvents),
+		"SELECT count(*) FROM user_events WHERE user_id = ? AND (data::text LIKE '%user1@test.com%' OR data::text LIKE '%janedoe%')",
+		newUser1.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, personalEvents)
+
+	// Their old names are free again
+	newUser2 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// The code in the last message the file sender wrote
+func lastSentCode(t *testing.T, path string) string {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	words := strings.Fields(lines[len(lines)-1])
+	return words[len(words)-1]
+}
+
+func TestPhoneVerification(t *testing.T) {
+	// Send codes to a file
+	smsFile, err := ioutil.TempFile("", "sms")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	smsFile.Close()
+	defer os.Remove(smsFile.Name())
+	os.Setenv("SMS_SENDER", "file")
+	os.Setenv("SMS_FILE_PATH", smsFile.Name())
+	defer os.Unsetenv("SMS_SENDER")
+	defer os.Unsetenv("SMS_FILE_PATH")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	assert.Nil(t, newUser1.PhoneVerifiedAt, "A new phone number should not be verified")
+
+	verifyUrl := fmt.Sprintf("%s/users/%d/phones/verify", ts.URL, newUser1.Id)
+	confirmUrl := fmt.Sprintf("%s/users/%d/phones/confirm", ts.URL, newUser1.Id)
+
+	// Confirming before a code is sent fails
+	jsonData, _ := json.Marshal(models.PhoneVerificationConfirm{Code: "123456"})
+	response := doRequest(t, "POST", confirmUrl, jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 404, response.StatusCode, "Response should be NOT FOUND")
+
+	// Send a code
+	response = doRequest(t, "POST", verifyUrl, nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 202, response.StatusCode, "Response should be ACCEPTED")
+	contents, _ := ioutil.ReadFile(smsFile.Name())
+	assert.True(t, strings.HasPrefix(string(contents), "+15555551234\t"), "The code should be sent to the primary phone number")
+	code := lastSentCode(t, smsFile.Name())
+	assert.Len(t, code, 6)
+
+	// Another code can't be sent straight away
+	response = doRequest(t, "POST", verifyUrl, nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 429, response.StatusCode, "Response should be TOO MANY REQUESTS")
+
+	// A wrong code is rejected
+	wrongCode := "000000"
+	if code == wrongCode {
+		wrongCode = "111111"
+	}
+	jsonData, _ = json.Marshal(models.PhoneVerificationConfirm{Code: wrongCode})
+	response = doRequest(t, "POST", confirmUrl, jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 400, response.StatusCode, "Response should be BAD REQUEST")
+
+	// The right code verifies the phone number
+	jsonData, _ = json.Marshal(models.PhoneVerificationConfirm{Code: code})
+	response = doRequest(t, "POST", confirmUrl, jsonData, nil)
+	var verifiedUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&verifiedUser)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.NotNil(t, verifiedUser.PhoneVerifiedAt, "The phone number should be verified")
+	assert.NotNil(t, retrieveUser(ts, t, newUser1.Id).PhoneVerifiedAt)
+
+	// The code can't be used twice
+	response = doRequest(t, "POST", confirmUrl, jsonData, nil)
+	response.Body.Close()
+	assert.Equal(t, 404, response.StatusCode, "Response should be NOT FOUND")
+
+	// A verified number doesn't need verifying again
+	response = doRequest(t, "POST", verifyUrl, nil, nil)
+	response.Body.Close()
+	assert.Equal(t, 409, response.StatusCode, "Response should be CONFLICT")
+
+	// Updating the user with the same number keeps it verified
+	updatedUser := updateUser(ts, t, newUser1.Id, goodUser1Json)
+	assert.NotNil(t, updatedUser.PhoneVerifiedAt, "An unchanged phone number should stay verified")
+
+	// Changing the number resets the verification
+	var changedUser map[string]interface{}
+	json.Unmarshal(goodUser1Json, &changedUser)
+	changedUser["primary_phone_number"] = "+15555559876"
+	jsonData, _ = json.Marshal(changedUser)
+	updatedUser = updateUser(ts, t, newUser1.Id, jsonData)
+	assert.Nil(t, updatedUser.PhoneVerifiedAt, "A changed phone number should not be verified")
+	assert.Nil(t, retrieveUser(ts, t, newUser1.Id).PhoneVerifiedAt)
+
+	deleteUser(ts, t, newUser1.Id)
+}
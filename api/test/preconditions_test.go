@@ -0,0 +1,88 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func doRequest(t *testing.T, method string, url string, body []byte, headers map[string]string) *http.Response {
+	request, _ := http.NewRequest(method, url, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	return response
+}
+
+func TestUserPreconditions(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	// Creating returns the ETag
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users", ts.URL), goodUser1Json, nil)
+	var newUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&newUser)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	createdETag := response.Header.Get("ETag")
+	assert.NotEmpty(t, createdETag, "ETag should be set")
+
+	userUrl := fmt.Sprintf("%s/users/%d", ts.URL, newUser.Id)
+
+	// Retrieving returns the same ETag, and a 304 if the client has it
+	response = doRequest(t, "GET", userUrl, nil, nil)
+	response.Body.Close()
+	assert.Equal(t, createdETag, response.Header.Get("ETag"), "ETag should match")
+	response = doRequest(t, "GET", userUrl, nil, map[string]string{"If-None-Match": createdETag})
+	response.Body.Close()
+	assert.Equal(t, 304, response.StatusCode, "Response should be NOT_MODIFIED")
+
+	// Updating with the current ETag succeeds and changes the ETag
+	var userIncoming models.UserIncoming
+	json.Unmarshal(goodUser1Json, &userIncoming)
+	userIncoming.FirstName = "Janet"
+	jsonData, _ := json.Marshal(userIncoming)
+	response = doRequest(t, "PUT", userUrl, jsonData, map[string]string{"If-Match": createdETag})
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	updatedETag := response.Header.Get("ETag")
+	assert.NotEqual(t, createdETag, updatedETag, "ETag should change on update")
+
+	// A stale ETag is rejected for update and delete
+	response = doRequest(t, "PUT", userUrl, jsonData, map[string]string{"If-Match": createdETag})
+	response.Body.Close()
+	assert.Equal(t, 412, response.StatusCode, "Response should be PRECONDITION_FAILED")
+	response = doRequest(t, "DELETE", userUrl, nil, map[string]string{"If-Match": createdETag})
+	response.Body.Close()
+	assert.Equal(t, 412, response.StatusCode, "Response should be PRECONDITION_FAILED")
+
+	// The old ETag is no longer current
+	response = doRequest(t, "GET", userUrl, nil, map[string]string{"If-None-Match": createdETag})
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	// Deleting with the current ETag succeeds
+	response = doRequest(t, "DELETE", userUrl, nil, map[string]string{"If-Match": updatedETag})
+	response.Body.Close()
+	assert.Equal(t, 204, response.StatusCode, "Response should be NO_CONTENT")
+}
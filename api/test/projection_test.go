@@ -0,0 +1,67 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Retrieve a user, or users, as plain JSON objects so missing fields show
+func retrieveProjected(ts *httptest.Server, t *testing.T, path string, expectedStatus int, projected interface{}) {
+	response := doRequest(t, "GET", ts.URL+path, nil, nil)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+	json.NewDecoder(response.Body).Decode(projected)
+}
+
+func TestUserProjection(t *testing.T) {
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+
+	// Only the fields asked for come back
+	var users struct{ Data []map[string]interface{} }
+	retrieveProjected(ts, t, "/users?fields=id,user_name", 200, &users)
+	assert.Equal(t, 1, len(users.Data))
+	assert.Equal(t, map[string]interface{}{"id": float64(newUser1.Id), "user_name": "user1"}, users.Data[0])
+
+	var user map[string]interface{}
+	path := fmt.Sprintf("/users/%d", newUser1.Id)
+	retrieveProjected(ts, t, path+"?fields=user_name,primary_phone_number_display", 200, &user)
+	assert.Equal(t, 2, len(user))
+	assert.Equal(t, "user1", user["user_name"])
+	assert.Equal(t, newUser1.PrimaryPhoneNumberDisplay, user["primary_phone_number_display"])
+
+	// Fields users don't have are rejected
+	assert.Equal(t, 400, statusOf(ts, t, "GET", "/users?fields=id,password_hash", nil, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "GET", path+"?fields=nonsense", nil, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "GET", path+"?expand=nonsense", nil, nil))
+
+	// Expanded groups come with whichever fields are asked for
+	group := createGroup(ts, t, "Engineering", nil)
+	addGroupMember(ts, t, group.Id, newUser1.Id, 204)
+	user = nil
+	retrieveProjected(ts, t, path+"?fields=user_name&expand=groups", 200, &user)
+	assert.Equal(t, 2, len(user))
+	groups := user["groups"].([]interface{})
+	assert.Equal(t, 1, len(groups))
+	assert.Equal(t, "Engineering", groups[0].(map[string]interface{})["name"])
+	users.Data = nil
+	retrieveProjected(ts, t, "/users?expand=groups", 200, &users)
+	assert.Equal(t, "user1", users.Data[0]["user_name"])
+	assert.Equal(t, 1, len(users.Data[0]["groups"].([]interface{})))
+
+	deleteGroup(ts, t, group.Id)
+	deleteUser(ts, t, newUser1.Id)
+}
@@ -0,0 +1,69 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+var uuidV7Pattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestUserPublicId(t *testing.T) {
+	defer os.Unsetenv("NUMERIC_USER_IDS")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	assert.Regexp(t, uuidV7Pattern, newUser1.PublicId)
+
+	// Users can be had by either id during the transition
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/"+newUser1.PublicId, nil, nil))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/"+strings.ToUpper(newUser1.PublicId), nil, nil))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", newUser1.Id), nil, nil))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/"+newUser1.PublicId+"/emails", nil, nil))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", "/users/017f22e2-79b0-7cc3-98c4-dc0c0c07398f", nil, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "GET", "/users/not-an-id", nil, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "GET", "/users/-1", nil, nil))
+
+	// And only by public_id once it's over
+	os.Setenv("NUMERIC_USER_IDS", "false")
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", newUser1.Id), nil, nil))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/"+newUser1.PublicId, nil, nil))
+
+	// Wherever else users are named
+	group := createGroup(ts, t, "publicids", nil)
+	assert.Equal(t, 404, statusOf(ts, t, "PUT", fmt.Sprintf("/groups/%d/members/%d", group.Id, newUser1.Id), nil, nil))
+	assert.Equal(t, 204, statusOf(ts, t, "PUT", fmt.Sprintf("/groups/%d/members/%s", group.Id, newUser1.PublicId), nil, nil))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/groups/%d/members/%s", group.Id, newUser1.PublicId), nil, nil))
+	deleteGroup(ts, t, group.Id)
+
+	var goodUser1 models.UserIncoming
+	json.Unmarshal(goodUser1Json, &goodUser1)
+	goodUser1.FirstName = "Janet"
+	batchRequest(ts, t, "PUT", models.UserBatchUpdate{
+		Items: []models.UserBatchUpdateItem{{UserID: models.UserID{Id: newUser1.Id}, UserIncoming: goodUser1}},
+	}, 404)
+	result := batchRequest(ts, t, "PUT", models.UserBatchUpdate{
+		Items: []models.UserBatchUpdateItem{{PublicId: newUser1.PublicId, UserIncoming: goodUser1}},
+	}, 200)
+	if assert.NotNil(t, result.Results[0].User) {
+		assert.Equal(t, "Janet", result.Results[0].User.FirstName)
+	}
+	batchRequest(ts, t, "DELETE", models.UserBatchDelete{Ids: []uint{newUser1.Id}}, 404)
+	batchRequest(ts, t, "DELETE", models.UserBatchDelete{PublicIds: []string{newUser1.PublicId}}, 200)
+}
@@ -0,0 +1,140 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func signIn(ts *httptest.Server, t *testing.T, userName string, password string, expectedStatus int) map[string]string {
+	jsonData, _ := json.Marshal(models.SessionIncoming{UserName: userName, Password: password})
+	response := doRequest(t, "POST", ts.URL+"/sessions", jsonData, nil)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var session models.Session
+	json.NewDecoder(response.Body).Decode(&session)
+
+	return map[string]string{"Authorization": "Bearer " + session.Token}
+}
+
+func statusOf(ts *httptest.Server, t *testing.T, method string, path string, body []byte, headers map[string]string) int {
+	response := doRequest(t, method, ts.URL+path, body, headers)
+	response.Body.Close()
+	return response.StatusCode
+}
+
+func TestRoleBasedAccessControl(t *testing.T) {
+	// By default, nothing is open to everyone
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Unsetenv("ANONYMOUS_PERMISSIONS")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Setenv("ANONYMOUS_PERMISSIONS", openPermissions)
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users", nil, nil))
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/groups", nil, nil))
+
+	// Nothing but reading groups is open to everyone
+	os.Setenv("ANONYMOUS_PERMISSIONS", "groups:read")
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	goodUser2Json, err := ioutil.ReadFile("fixtures/goodUser2.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	// Anonymous callers can't create users, the admin can
+	assert.Equal(t, 401, statusOf(ts, t, "POST", "/users", goodUser1Json, nil))
+	response := doRequest(t, "POST", ts.URL+"/users", goodUser1Json, adminHeaders)
+	var newUser1 models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&newUser1)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	response = doRequest(t, "POST", ts.URL+"/users", goodUser2Json, adminHeaders)
+	var newUser2 models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&newUser2)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+
+	// Signing in
+	signIn(ts, t, "user1", "wrong password", 401)
+	user1Headers := signIn(ts, t, "user1", "secret1min8chars", 201)
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/users", nil, map[string]string{"Authorization": "Bearer nonsense"}))
+
+	// A signed in user can read and write themselves, but nobody else
+	user1Path := fmt.Sprintf("/users/%d", newUser1.Id)
+	user2Path := fmt.Sprintf("/users/%d", newUser2.Id)
+	assert.Equal(t, 200, statusOf(ts, t, "GET", user1Path, nil, user1Headers))
+	assert.Equal(t, 200, statusOf(ts, t, "PUT", user1Path, goodUser1Json, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "GET", user2Path, nil, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "PUT", user2Path, goodUser2Json, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "DELETE", user1Path, nil, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "GET", "/users", nil, user1Headers))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/groups", nil, user1Headers))
+
+	// Only admins can manage roles, and roles only grant real permissions
+	roleJson := []byte(`{"name": "directory", "permissions": ["users:read"]}`)
+	assert.Equal(t, 403, statusOf(ts, t, "POST", "/roles", roleJson, user1Headers))
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/roles", []byte(`{"name": "bad", "permissions": ["users:fly"]}`), adminHeaders))
+	response = doRequest(t, "POST", ts.URL+"/roles", roleJson, adminHeaders)
+	var role models.Role
+	json.NewDecoder(response.Body).Decode(&role)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+
+	// A role given to a group applies to the users in groups nested in it
+	response = doRequest(t, "POST", ts.URL+"/groups", []byte(`{"name": "staff"}`), adminHeaders)
+	var staff models.Group
+	json.NewDecoder(response.Body).Decode(&staff)
+	response.Body.Close()
+	response = doRequest(t, "POST", ts.URL+"/groups", []byte(fmt.Sprintf(`{"name": "support", "parent_id": %d}`, staff.Id)), adminHeaders)
+	var support models.Group
+	json.NewDecoder(response.Body).Decode(&support)
+	response.Body.Close()
+	assert.Equal(t, 204, statusOf(ts, t, "PUT", fmt.Sprintf("/groups/%d/members/%d", support.Id, newUser1.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "PUT", fmt.Sprintf("/roles/%d/groups/%d", role.Id, staff.Id), nil, adminHeaders))
+
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users", nil, user1Headers))
+	assert.Equal(t, 200, statusOf(ts, t, "GET", user2Path, nil, user1Headers))
+	assert.Equal(t, 403, statusOf(ts, t, "PUT", user2Path, goodUser2Json, user1Headers))
+
+	response = doRequest(t, "GET", ts.URL+user1Path+"/permissions", nil, user1Headers)
+	var permissions struct {
+		Data []string `json:"data"`
+	}
+	json.NewDecoder(response.Body).Decode(&permissions)
+	response.Body.Close()
+	assert.Equal(t, []string{"groups:read", "users:read", "users:read:self", "users:write:self"}, permissions.Data)
+
+	// A role given directly to a user
+	response = doRequest(t, "PUT", fmt.Sprintf("%s/roles/%d", ts.URL, role.Id), []byte(`{"name": "directory", "permissions": ["users:read", "users:delete"]}`), adminHeaders)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/roles/%d/groups/%d", role.Id, staff.Id), nil, adminHeaders))
+	assert.Equal(t, 403, statusOf(ts, t, "GET", "/users", nil, user1Headers))
+	assert.Equal(t, 204, statusOf(ts, t, "PUT", fmt.Sprintf("/roles/%d/users/%d", role.Id, newUser1.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", user2Path, nil, user1Headers))
+
+	// Signing out ends the session
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", "/sessions/current", nil, user1Headers))
+	assert.Equal(t, 401, statusOf(ts, t, "GET", user1Path, nil, user1Headers))
+
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/groups/%d", support.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/groups/%d", staff.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/roles/%d", role.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", user1Path, nil, adminHeaders))
+}
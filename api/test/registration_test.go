@@ -0,0 +1,160 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/captcha"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+// Find the nonce that solves a proof of work challenge, and respond with it
+func solveChallenge(challenge captcha.Challenge) string {
+	for nonce := 0; ; nonce++ {
+		response := fmt.Sprintf("%s:%d", challenge.Challenge, nonce)
+		hash := sha256.Sum256([]byte(response))
+		zeros := 0
+		for _, b := range hash {
+			if b != 0 {
+				for b&0x80 == 0 {
+					zeros++
+					b <<= 1
+				}
+				break
+			}
+			zeros += 8
+		}
+		if zeros >= challenge.Difficulty {
+			return response
+		}
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	// Mail tokens to a file
+	mailFile, err := ioutil.TempFile("", "mail")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	mailFile.Close()
+	defer os.Remove(mailFile.Name())
+	os.Setenv("MAILER", "file")
+	os.Setenv("MAIL_FILE_PATH", mailFile.Name())
+	os.Setenv("REGISTRATION_RATE_LIMIT", "0")
+	defer os.Unsetenv("MAILER")
+	defer os.Unsetenv("MAIL_FILE_PATH")
+	defer os.Unsetenv("REGISTRATION_RATE_LIMIT")
+	defer os.Unsetenv("REGISTRATION")
+	defer os.Unsetenv("REGISTRATION_ALLOWED_DOMAINS")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	var registration map[string]interface{}
+	json.Unmarshal(goodUser1Json, &registration)
+
+	// Registration is closed unless opened
+	assert.Equal(t, 403, statusOf(ts, t, "POST", "/register", goodUser1Json, nil))
+	os.Setenv("REGISTRATION", "allowlist")
+	os.Setenv("REGISTRATION_ALLOWED_DOMAINS", "example.com, test.org")
+	assert.Equal(t, 403, statusOf(ts, t, "POST", "/register", goodUser1Json, nil))
+	os.Setenv("REGISTRATION", "open")
+
+	// Disposable addresses are rejected
+	registration["email"] = "user1@mailinator.com"
+	jsonData, _ := json.Marshal(registration)
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/register", jsonData, nil))
+	registration["email"] = "user1@eu.trashmail.com"
+	jsonData, _ = json.Marshal(registration)
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/register", jsonData, nil))
+
+	// Registered users are pending until their email is verified
+	response := doRequest(t, "POST", ts.URL+"/register", goodUser1Json, nil)
+	var user models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, "pending", user.Status)
+	assert.Nil(t, user.EmailVerifiedAt)
+	signIn(ts, t, "user1", "secret1min8chars", 403)
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/register", goodUser1Json, nil))
+
+	// Asking for another token doesn't say whether there's a registration
+	assert.Equal(t, 202, statusOf(ts, t, "POST", "/register/resend", []byte(`{"email": "nobody@test.com"}`), nil))
+	assert.Equal(t, 202, statusOf(ts, t, "POST", "/register/resend", []byte(`{"email": "user1@test.com"}`), nil))
+
+	token := lastMailedToken(t, mailFile.Name())
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/register/verify", []byte(`{"token": "wrong"}`), nil))
+	response = doRequest(t, "POST", ts.URL+"/register/verify", []byte(fmt.Sprintf(`{"token": "%s"}`, token)), nil)
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+	assert.Equal(t, "active", user.Status)
+	assert.NotNil(t, user.EmailVerifiedAt)
+	signIn(ts, t, "user1", "secret1min8chars", 201)
+	assert.Equal(t, 404, statusOf(ts, t, "POST", "/register/verify", []byte(fmt.Sprintf(`{"token": "%s"}`, token)), nil))
+
+	// Changing the email makes it unverified
+	registration["email"] = "user1@test.org"
+	jsonData, _ = json.Marshal(registration)
+	assert.Nil(t, updateUser(ts, t, user.Id, jsonData).EmailVerifiedAt)
+
+	deleteUser(ts, t, user.Id)
+}
+
+func TestRegistrationAbuseControls(t *testing.T) {
+	os.Setenv("REGISTRATION", "open")
+	os.Setenv("REGISTRATION_RATE_LIMIT", "3")
+	os.Setenv("CAPTCHA", "pow")
+	os.Setenv("POW_DIFFICULTY", "8")
+	defer os.Unsetenv("REGISTRATION")
+	defer os.Unsetenv("REGISTRATION_RATE_LIMIT")
+	defer os.Unsetenv("CAPTCHA")
+	defer os.Unsetenv("POW_DIFFICULTY")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser2Json, err := ioutil.ReadFile("fixtures/goodUser2.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	var registration map[string]interface{}
+	json.Unmarshal(goodUser2Json, &registration)
+
+	// Registering needs a solved challenge, which can only be used once
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/register", goodUser2Json, nil))
+	response := doRequest(t, "GET", ts.URL+"/register/challenge", nil, nil)
+	var challenge captcha.Challenge
+	json.NewDecoder(response.Body).Decode(&challenge)
+	response.Body.Close()
+	assert.Equal(t, 8, challenge.Difficulty)
+	registration["captcha"] = solveChallenge(challenge)
+	jsonData, _ := json.Marshal(registration)
+	response = doRequest(t, "POST", ts.URL+"/register", jsonData, nil)
+	var user models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/register", jsonData, nil))
+
+	// Each IP can only make so many attempts
+	assert.Equal(t, 429, statusOf(ts, t, "POST", "/register", jsonData, nil))
+	// Which a client can't get around by claiming to be forwarding for another
+	assert.Equal(t, 429, statusOf(ts, t, "POST", "/register", jsonData, map[string]string{"X-Forwarded-For": "203.0.113.7"}))
+
+	deleteUser(ts, t, user.Id)
+}
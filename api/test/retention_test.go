@@ -0,0 +1,141 @@
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/mail"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func retrieveRetentionReport(ts *httptest.Server, t *testing.T) models.RetentionReport {
+	response := doRequest(t, "GET", ts.URL+"/users/retention-report?page_size=100", nil, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var report models.RetentionReport
+	json.NewDecoder(response.Body).Decode(&report)
+	return report
+}
+
+// The action the report says the next run will take on the user, if any
+func retentionActionFor(report models.RetentionReport, id uint) string {
+	for _, candidate := range report.Data {
+		if candidate.User.Id == id {
+			return candidate.Action
+		}
+	}
+	return ""
+}
+
+func TestUserRetention(t *testing.T) {
+	// Mail warnings to a file
+	mailFile, err := ioutil.TempFile("", "mail")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	mailFile.Close()
+	defer os.Remove(mailFile.Name())
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Setenv("MAILER", "file")
+	os.Setenv("MAIL_FILE_PATH", mailFile.Name())
+	os.Setenv("RETENTION_WARN_DAYS", "30")
+	os.Setenv("RETENTION_SUSPEND_DAYS", "60")
+	os.Setenv("RETENTION_ERASE_DAYS", "90")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Unsetenv("MAILER")
+	defer os.Unsetenv("MAIL_FILE_PATH")
+	defer os.Unsetenv("RETENTION_WARN_DAYS")
+	defer os.Unsetenv("RETENTION_SUSPEND_DAYS")
+	defer os.Unsetenv("RETENTION_ERASE_DAYS")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+	db := database.Connect()
+	defer db.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	assert.Nil(t, newUser1.LastLoginAt)
+
+	// Signing in is tracked
+	user1Headers := signIn(ts, t, "user1", "secret1min8chars", 201)
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/by-username/user1", nil, user1Headers))
+	user1 := retrieveUser(ts, t, newUser1.Id)
+	assert.NotNil(t, user1.LastLoginAt)
+	assert.NotNil(t, user1.LastSeenAt)
+	assert.Equal(t, "", retentionActionFor(retrieveRetentionReport(ts, t), newUser1.Id))
+
+	// Warned once inactive for the warn days
+	_, err = db.Exec(`UPDATE user_accounts SET created_at = now() - interval '45 days',
+		last_login_at = now() - interval '45 days', last_seen_at = now() - interval '45 days' WHERE id = ?`, newUser1.Id)
+	assert.Nil(t, err)
+	report := retrieveRetentionReport(ts, t)
+	assert.Equal(t, models.RetentionRules{WarnDays: 30, SuspendDays: 60, EraseDays: 90}, report.Rules)
+	assert.Equal(t, models.RetentionActionWarn, retentionActionFor(report, newUser1.Id))
+	run, err := handlers.ApplyRetention(db, mail.NewMailer())
+	assert.Nil(t, err)
+	if assert.NotNil(t, run) {
+		assert.True(t, run.Warned >= 1)
+	}
+	contents, _ := ioutil.ReadFile(mailFile.Name())
+	assert.Contains(t, string(contents), "user1@test.com")
+	assert.Contains(t, string(contents), "within 30 days")
+	assert.Equal(t, "", retentionActionFor(retrieveRetentionReport(ts, t), newUser1.Id))
+
+	// Suspended once inactive for the suspend days, and warned long enough ago
+	_, err = db.Exec(`UPDATE user_accounts SET last_seen_at = now() - interval '75 days',
+		retention_warned_at = now() - interval '31 days' WHERE id = ?`, newUser1.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, models.RetentionActionSuspend, retentionActionFor(retrieveRetentionReport(ts, t), newUser1.Id))
+	_, err = handlers.ApplyRetention(db, mail.NewMailer())
+	assert.Nil(t, err)
+	user1 = retrieveUser(ts, t, newUser1.Id)
+	assert.Equal(t, models.UserStatusSuspended, user1.Status)
+	assert.Equal(t, "inactive", user1.StatusReason)
+	signIn(ts, t, "user1", "secret1min8chars", 403)
+
+	// Erased once suspended for the gap to the erase days
+	_, err = db.Exec(`UPDATE user_accounts SET status_changed_at = now() - interval '31 days' WHERE id = ?`, newUser1.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, models.RetentionActionErase, retentionActionFor(retrieveRetentionReport(ts, t), newUser1.Id))
+	_, err = handlers.ApplyRetention(db, mail.NewMailer())
+	assert.Nil(t, err)
+	user1 = retrieveUser(ts, t, newUser1.Id)
+	assert.Equal(t, models.UserStatusDisabled, user1.Status)
+	assert.Equal(t, "", user1.Email)
+	assert.True(t, strings.HasPrefix(user1.StatusReason, "inactive for 90 days"))
+
+	// Users suspended by hand are never erased for it
+	var user2 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user2)
+	user2["user_name"] = "user2"
+	jsonData, _ := json.Marshal(user2)
+	newUser2 := createUser(ts, t, jsonData, 201, "Response should be CREATED")
+	assert.Equal(t, 200, statusOf(ts, t, "POST", "/users/"+newUser2.PublicId+"/suspend", []byte(`{"reason": "spam"}`), adminHeaders))
+	_, err = db.Exec(`UPDATE user_accounts SET created_at = now() - interval '1 year',
+		status_changed_at = now() - interval '1 year' WHERE id = ?`, newUser2.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "", retentionActionFor(retrieveRetentionReport(ts, t), newUser2.Id))
+
+	// Rules out of order aren't applied
+	os.Setenv("RETENTION_ERASE_DAYS", "45")
+	assert.Equal(t, 503, statusOf(ts, t, "GET", "/users/retention-report", nil, adminHeaders))
+	_, err = handlers.ApplyRetention(db, mail.NewMailer())
+	assert.NotNil(t, err)
+
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
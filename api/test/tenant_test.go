@@ -0,0 +1,119 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func createOrganization(ts *httptest.Server, t *testing.T, name string, slug string) models.Organization {
+	jsonData := []byte(fmt.Sprintf(`{"name": "%s", "slug": "%s"}`, name, slug))
+	response := doRequest(t, "POST", ts.URL+"/organizations", jsonData, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+
+	var organization models.Organization
+	json.NewDecoder(response.Body).Decode(&organization)
+
+	return organization
+}
+
+// The admin's headers, for an organization
+func tenantHeaders(slug string) map[string]string {
+	return map[string]string{"Authorization": adminHeaders["Authorization"], "X-Tenant": slug}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	// Only the admin manages organizations, and slugs must be usable as subdomains
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/organizations", nil, nil))
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/organizations", []byte(`{"name": "Bad", "slug": "Not A Slug"}`), adminHeaders))
+	acme := createOrganization(ts, t, "Acme", "acme")
+	globex := createOrganization(ts, t, "Globex", "globex")
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/organizations", []byte(`{"name": "Acme again", "slug": "acme"}`), adminHeaders))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", "/users", nil, map[string]string{"X-Tenant": "initech"}))
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	// user_name is unique within an organization, not across them
+	response := doRequest(t, "POST", ts.URL+"/users", goodUser1Json, tenantHeaders("acme"))
+	var acmeUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&acmeUser)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	response = doRequest(t, "POST", ts.URL+"/users", goodUser1Json, tenantHeaders("globex"))
+	var globexUser models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&globexUser)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	assert.Equal(t, 400, statusOf(ts, t, "POST", "/users", goodUser1Json, tenantHeaders("acme")))
+
+	// Each organization only sees its own users
+	response = doRequest(t, "GET", ts.URL+"/users", nil, tenantHeaders("acme"))
+	var acmeUsers UserAccounts
+	json.NewDecoder(response.Body).Decode(&acmeUsers)
+	response.Body.Close()
+	assert.Equal(t, 1, len(acmeUsers.Data))
+	assert.Equal(t, acmeUser.Id, acmeUsers.Data[0].Id)
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", globexUser.Id), nil, tenantHeaders("acme")))
+	assert.Equal(t, 404, statusOf(ts, t, "DELETE", fmt.Sprintf("/users/%d", globexUser.Id), nil, tenantHeaders("acme")))
+	users := retrieveAllUsers(ts, t, "?user_name=user1")
+	assert.Equal(t, 0, len(users.Data))
+
+	// Nor can it put another organization's users in its groups
+	response = doRequest(t, "POST", ts.URL+"/groups", []byte(`{"name": "staff"}`), tenantHeaders("acme"))
+	var staff models.Group
+	json.NewDecoder(response.Body).Decode(&staff)
+	response.Body.Close()
+	assert.Equal(t, 404, statusOf(ts, t, "PUT", fmt.Sprintf("/groups/%d/members/%d", staff.Id, globexUser.Id), nil, tenantHeaders("acme")))
+	assert.Equal(t, 204, statusOf(ts, t, "PUT", fmt.Sprintf("/groups/%d/members/%d", staff.Id, acmeUser.Id), nil, tenantHeaders("acme")))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/groups/%d", staff.Id), nil, tenantHeaders("globex")))
+
+	// A session is for the organization the user signed in to
+	assert.Equal(t, 401, statusOf(ts, t, "POST", "/sessions", []byte(`{"user_name": "user1", "password": "secret1min8chars"}`), nil))
+	response = doRequest(t, "POST", ts.URL+"/sessions", []byte(`{"user_name": "user1", "password": "secret1min8chars"}`), map[string]string{"X-Tenant": "acme"})
+	var session models.Session
+	json.NewDecoder(response.Body).Decode(&session)
+	response.Body.Close()
+	assert.Equal(t, 201, response.StatusCode, "Response should be CREATED")
+	acmeUserHeaders := map[string]string{"Authorization": "Bearer " + session.Token}
+
+	response = doRequest(t, "GET", ts.URL+"/organizations/current", nil, acmeUserHeaders)
+	var current models.Organization
+	json.NewDecoder(response.Body).Decode(&current)
+	response.Body.Close()
+	assert.Equal(t, acme.Id, current.Id)
+	assert.Equal(t, 200, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", acmeUser.Id), nil, acmeUserHeaders))
+	acmeUserHeaders["X-Tenant"] = "globex"
+	assert.Equal(t, 403, statusOf(ts, t, "GET", fmt.Sprintf("/users/%d", globexUser.Id), nil, acmeUserHeaders))
+
+	// Organizations can't be deleted while they have users, nor can the default
+	assert.Equal(t, 409, statusOf(ts, t, "DELETE", fmt.Sprintf("/organizations/%d", acme.Id), nil, adminHeaders))
+	response = doRequest(t, "GET", ts.URL+"/organizations/current", nil, nil)
+	json.NewDecoder(response.Body).Decode(&current)
+	response.Body.Close()
+	assert.Equal(t, "default", current.Slug)
+	assert.Equal(t, 409, statusOf(ts, t, "DELETE", fmt.Sprintf("/organizations/%d", current.Id), nil, adminHeaders))
+
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/users/%d", acmeUser.Id), nil, tenantHeaders("acme")))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/users/%d", globexUser.Id), nil, tenantHeaders("globex")))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/organizations/%d", acme.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/organizations/%d", globex.Id), nil, adminHeaders))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/organizations/%d", acme.Id), nil, adminHeaders))
+}
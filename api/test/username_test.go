@@ -0,0 +1,100 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func renameUser(ts *httptest.Server, t *testing.T, id uint, userName string, expectedStatus int) models.UserOutgoing {
+	jsonData := []byte(fmt.Sprintf(`{"user_name": "%s"}`, userName))
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users/%d/rename", ts.URL, id), jsonData, nil)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var userOutgoing models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&userOutgoing)
+
+	return userOutgoing
+}
+
+func TestUserRename(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	goodUser2Json, err := ioutil.ReadFile("fixtures/goodUser2.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+
+	// Reserved names can't be had, whatever the case
+	renameUser(ts, t, newUser1.Id, "admin", 400)
+	renameUser(ts, t, newUser1.Id, "Support", 400)
+
+	// Renaming keeps the old name in the history
+	user := renameUser(ts, t, newUser1.Id, "janedoe", 200)
+	assert.Equal(t, "janedoe", user.UserName)
+	response := doRequest(t, "GET", fmt.Sprintf("%s/users/%d/names", ts.URL, newUser1.Id), nil, nil)
+	var changes struct{ Data []models.UserNameChange }
+	json.NewDecoder(response.Body).Decode(&changes)
+	response.Body.Close()
+	assert.Equal(t, 1, len(changes.Data))
+	assert.Equal(t, "user1", changes.Data[0].UserName)
+
+	// Looking up the old name redirects to the user
+	response = doRequest(t, "GET", ts.URL+"/users/by-username/user1", nil, adminHeaders)
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, "/users/"+newUser1.PublicId, response.Request.URL.Path)
+	assert.Equal(t, newUser1.Id, user.Id)
+	assert.Equal(t, 404, statusOf(ts, t, "GET", "/users/by-username/nobody", nil, adminHeaders))
+
+	// Nobody else can claim the old name during its cooldown
+	createUser(ts, t, goodUser1Json, 400, "Response should be BAD REQUEST")
+	newUser2 := createUser(ts, t, goodUser2Json, 201, "Response should be CREATED")
+	renameUser(ts, t, newUser2.Id, "user1", 400)
+	renameUser(ts, t, newUser2.Id, "USER1", 400)
+
+	// But its user can have it back
+	user = renameUser(ts, t, newUser1.Id, "user1", 200)
+	assert.Equal(t, "user1", user.UserName)
+
+	// Updates that change the name go through the same checks
+	var changedUser map[string]interface{}
+	json.Unmarshal(goodUser2Json, &changedUser)
+	changedUser["user_name"] = "janedoe"
+	jsonData, _ := json.Marshal(changedUser)
+	assert.Equal(t, 400, statusOf(ts, t, "PUT", fmt.Sprintf("/users/%d", newUser2.Id), jsonData, nil))
+	changedUser["user_name"] = "johndoe"
+	jsonData, _ = json.Marshal(changedUser)
+	assert.Equal(t, "johndoe", updateUser(ts, t, newUser2.Id, jsonData).UserName)
+	assert.Equal(t, 200, statusOf(ts, t, "GET", "/users/by-username/johndoe", nil, adminHeaders))
+
+	// Names in use are unique whatever their case, and found in any case
+	renameUser(ts, t, newUser1.Id, "JohnDoe", 400)
+	response = doRequest(t, "GET", ts.URL+"/users/by-username/JOHNDOE", nil, adminHeaders)
+	json.NewDecoder(response.Body).Decode(&user)
+	response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode)
+	assert.Equal(t, newUser2.Id, user.Id)
+
+	deleteUser(ts, t, newUser1.Id)
+	deleteUser(ts, t, newUser2.Id)
+}
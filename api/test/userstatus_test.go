@@ -0,0 +1,83 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/stretchr/testify/assert"
+)
+
+func changeUserStatus(ts *httptest.Server, t *testing.T, id uint, action string, reason string, expectedStatus int) models.UserOutgoing {
+	jsonData := []byte(fmt.Sprintf(`{"reason": "%s"}`, reason))
+	response := doRequest(t, "POST", fmt.Sprintf("%s/users/%d/%s", ts.URL, id, action), jsonData, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var userOutgoing models.UserOutgoing
+	json.NewDecoder(response.Body).Decode(&userOutgoing)
+
+	return userOutgoing
+}
+
+func TestUserStatus(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	defer os.Unsetenv("ADMIN_TOKEN")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	// New users are active
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	assert.Equal(t, "active", newUser1.Status)
+	user1Headers := signIn(ts, t, "user1", "secret1min8chars", 201)
+	userPath := fmt.Sprintf("/users/%d", newUser1.Id)
+
+	// Status changes need the permission and a reason
+	assert.Equal(t, 401, statusOf(ts, t, "POST", userPath+"/suspend", []byte(`{"reason": "spam"}`), nil))
+	assert.Equal(t, 400, statusOf(ts, t, "POST", userPath+"/suspend", []byte(`{}`), adminHeaders))
+
+	// Suspended users are signed out, and can't sign in
+	user := changeUserStatus(ts, t, newUser1.Id, "suspend", "spam", 200)
+	assert.Equal(t, "suspended", user.Status)
+	assert.Equal(t, "spam", user.StatusReason)
+	assert.NotNil(t, user.StatusChangedAt)
+	assert.Equal(t, 401, statusOf(ts, t, "GET", userPath, nil, user1Headers))
+	signIn(ts, t, "user1", "secret1min8chars", 403)
+	changeUserStatus(ts, t, newUser1.Id, "suspend", "more spam", 409)
+
+	// A user's details can still be updated while suspended
+	user = updateUser(ts, t, newUser1.Id, goodUser1Json)
+	assert.Equal(t, "suspended", user.Status)
+
+	// Filter by status
+	users := retrieveAllUsers(ts, t, "?status=suspended")
+	assert.Equal(t, 1, len(users.Data))
+	users = retrieveAllUsers(ts, t, "?status=active")
+	assert.Equal(t, 0, len(users.Data))
+	assert.Equal(t, 400, statusOf(ts, t, "GET", "/users?status=asleep", nil, nil))
+
+	// Reactivated users can sign in again
+	user = changeUserStatus(ts, t, newUser1.Id, "reactivate", "appeal upheld", 200)
+	assert.Equal(t, "active", user.Status)
+	signIn(ts, t, "user1", "secret1min8chars", 201)
+
+	// Disabling is for good
+	user = changeUserStatus(ts, t, newUser1.Id, "disable", "account closed", 200)
+	assert.Equal(t, "disabled", user.Status)
+	changeUserStatus(ts, t, newUser1.Id, "reactivate", "changed our minds", 409)
+	signIn(ts, t, "user1", "secret1min8chars", 403)
+
+	deleteUser(ts, t, newUser1.Id)
+}
@@ -0,0 +1,226 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/davidwarshaw/golang-user-crud/api/database"
+	"github.com/davidwarshaw/golang-user-crud/api/handlers"
+	"github.com/davidwarshaw/golang-user-crud/api/models"
+	"github.com/davidwarshaw/golang-user-crud/api/server"
+	"github.com/davidwarshaw/golang-user-crud/api/webhook"
+	"github.com/go-pg/pg"
+	"github.com/stretchr/testify/assert"
+)
+
+type WebhookDeliveries struct {
+	Data []models.WebhookDelivery `json:"data"`
+}
+
+// Records what's posted to it, responding with status
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	body, _ := ioutil.ReadAll(request.Body)
+	r.requests = append(r.requests, request)
+	r.bodies = append(r.bodies, body)
+	w.WriteHeader(r.status)
+}
+
+// The last payload posted, checking its signature
+func (r *webhookReceiver) lastPayload(t *testing.T, secret string) models.WebhookPayload {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var payload models.WebhookPayload
+	if !assert.NotEmpty(t, r.requests) {
+		return payload
+	}
+	request, body := r.requests[len(r.requests)-1], r.bodies[len(r.bodies)-1]
+	timestamp, _ := strconv.ParseInt(request.Header.Get(webhook.TimestampHeader), 10, 64)
+	assert.Equal(t, webhook.Sign(secret, timestamp, body), request.Header.Get(webhook.SignatureHeader))
+	json.Unmarshal(body, &payload)
+	assert.Equal(t, payload.Type, request.Header.Get(webhook.EventHeader))
+	return payload
+}
+
+func createWebhook(ts *httptest.Server, t *testing.T, body string, expectedStatus int) models.Webhook {
+	response := doRequest(t, "POST", ts.URL+"/webhooks", []byte(body), adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, expectedStatus, response.StatusCode)
+
+	var hook models.Webhook
+	json.NewDecoder(response.Body).Decode(&hook)
+	return hook
+}
+
+func retrieveWebhookDeliveries(ts *httptest.Server, t *testing.T, webhookId uint, query string) WebhookDeliveries {
+	response := doRequest(t, "GET", fmt.Sprintf("%s/webhooks/%d/deliveries%s", ts.URL, webhookId, query), nil, adminHeaders)
+	defer response.Body.Close()
+	assert.Equal(t, 200, response.StatusCode, "Response should be OK")
+
+	var deliveries WebhookDeliveries
+	json.NewDecoder(response.Body).Decode(&deliveries)
+	return deliveries
+}
+
+func TestWebhooks(t *testing.T) {
+	os.Setenv("ADMIN_TOKEN", testAdminToken)
+	os.Setenv("WEBHOOK_MAX_ATTEMPTS", "2")
+	defer os.Unsetenv("ADMIN_TOKEN")
+	defer os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+
+	// Create server
+	ts := httptest.NewServer(server.Setup())
+	defer ts.Close()
+	db := database.Connect()
+	defer db.Close()
+
+	// One receiver that's up, and one that's down
+	up := &webhookReceiver{status: 204}
+	upServer := httptest.NewServer(up)
+	defer upServer.Close()
+
+	// Webhooks can't be sent to private addresses, like the receivers', unless
+	// they're allowed
+	status, err := webhook.NewSender().Send(webhook.Delivery{URL: upServer.URL, Payload: []byte("{}")})
+	assert.True(t, errors.Is(err, webhook.ErrPrivateAddress))
+	assert.Equal(t, 0, status)
+	assert.Empty(t, up.requests)
+	for _, address := range []string{"http://100.64.0.1/", "http://198.18.0.1/", "http://[64:ff9b::a00:1]/"} {
+		_, err = webhook.NewSender().Send(webhook.Delivery{URL: address, Payload: []byte("{}")})
+		assert.True(t, errors.Is(err, webhook.ErrPrivateAddress), address)
+	}
+	os.Setenv("WEBHOOK_ALLOW_PRIVATE_ADDRESSES", "true")
+	defer os.Unsetenv("WEBHOOK_ALLOW_PRIVATE_ADDRESSES")
+	sender := webhook.NewSender()
+	down := &webhookReceiver{status: 500}
+	downServer := httptest.NewServer(down)
+	defer downServer.Close()
+
+	// Webhooks need a web URL and known events, and are only for admins
+	createWebhook(ts, t, `{"url": "ftp://example.com"}`, 400)
+	createWebhook(ts, t, `{"url": "`+upServer.URL+`", "events": ["user.renamed"]}`, 400)
+	assert.Equal(t, 401, statusOf(ts, t, "GET", "/webhooks", nil, nil))
+
+	// The secret is only shown when it's created
+	upHook := createWebhook(ts, t, `{"url": "`+upServer.URL+`", "events": ["user.created", "user.deleted"]}`, 201)
+	assert.Len(t, upHook.Secret, 64)
+	downSecret := "down-secret-0123456789"
+	downHook := createWebhook(ts, t, `{"url": "`+downServer.URL+`", "secret": "`+downSecret+`"}`, 201)
+	response := doRequest(t, "GET", fmt.Sprintf("%s/webhooks/%d", ts.URL, upHook.Id), nil, adminHeaders)
+	var retrieved models.Webhook
+	json.NewDecoder(response.Body).Decode(&retrieved)
+	response.Body.Close()
+	assert.Equal(t, []string{"user.created", "user.deleted"}, retrieved.Events)
+	assert.Equal(t, "", retrieved.Secret)
+	response = doRequest(t, "GET", ts.URL+"/webhooks?page_size=1", nil, adminHeaders)
+	var listed struct {
+		Data       []models.Webhook  `json:"data"`
+		Pagination models.Pagination `json:"pagination"`
+	}
+	json.NewDecoder(response.Body).Decode(&listed)
+	response.Body.Close()
+	assert.Len(t, listed.Data, 1)
+	assert.Equal(t, 1, listed.Pagination.PageSize)
+
+	// Creating a user sends the event to both, signed
+	goodUser1Json, err := ioutil.ReadFile("fixtures/goodUser1.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	newUser1 := createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	payload := up.lastPayload(t, upHook.Secret)
+	assert.Equal(t, models.UserEventCreated, payload.Type)
+	if assert.NotNil(t, payload.Data) {
+		assert.Equal(t, newUser1.PublicId, payload.Data.PublicId)
+		assert.Equal(t, "user1", payload.Data.UserName)
+	}
+	assert.Equal(t, payload.Id, down.lastPayload(t, downSecret).Id)
+	delivered := retrieveWebhookDeliveries(ts, t, upHook.Id, "?status=delivered")
+	if assert.Len(t, delivered.Data, 1) {
+		assert.Equal(t, 1, delivered.Data[0].Attempts)
+		assert.Equal(t, 204, delivered.Data[0].ResponseStatus)
+	}
+
+	// Failed deliveries are retried later, then dead
+	pending := retrieveWebhookDeliveries(ts, t, downHook.Id, "?status=pending")
+	if assert.Len(t, pending.Data, 1) {
+		assert.Equal(t, 500, pending.Data[0].ResponseStatus)
+		assert.Contains(t, pending.Data[0].LastError, "500")
+		assert.True(t, pending.Data[0].NextAttemptAt.After(*pending.Data[0].LastAttemptAt))
+	}
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	assert.Len(t, down.requests, 1)
+	_, err = db.Exec("UPDATE webhook_deliveries SET next_attempt_at = now() WHERE webhook_id = ?", downHook.Id)
+	assert.Nil(t, err)
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	assert.Len(t, down.requests, 2)
+	dead := retrieveWebhookDeliveries(ts, t, downHook.Id, "?status=dead")
+	if assert.Len(t, dead.Data, 1) {
+		assert.Equal(t, 2, dead.Data[0].Attempts)
+		assert.Nil(t, dead.Data[0].NextAttemptAt)
+	}
+
+	// Dead deliveries can be redelivered
+	down.mu.Lock()
+	down.status = 200
+	down.mu.Unlock()
+	redeliverPath := fmt.Sprintf("/webhooks/%d/deliveries/%d/redeliver", downHook.Id, dead.Data[0].Id)
+	assert.Equal(t, 404, statusOf(ts, t, "POST", fmt.Sprintf("/webhooks/%d/deliveries/%d/redeliver", upHook.Id, dead.Data[0].Id), nil, adminHeaders))
+	assert.Equal(t, 200, statusOf(ts, t, "POST", redeliverPath, nil, adminHeaders))
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	assert.Len(t, retrieveWebhookDeliveries(ts, t, downHook.Id, "?status=delivered").Data, 1)
+
+	// Webhooks only get the events they want
+	var user1 map[string]interface{}
+	json.Unmarshal(goodUser1Json, &user1)
+	user1["last_name"] = "Smith"
+	jsonData, _ := json.Marshal(user1)
+	updateUser(ts, t, newUser1.Id, jsonData)
+	deleteUser(ts, t, newUser1.Id)
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	payload = up.lastPayload(t, upHook.Secret)
+	assert.Equal(t, models.UserEventDeleted, payload.Type)
+	assert.Len(t, up.requests, 2)
+	assert.Len(t, down.requests, 5)
+	assert.Len(t, retrieveWebhookDeliveries(ts, t, downHook.Id, "").Data, 3)
+
+	// Disabled webhooks get nothing new, and the secret is kept unless changed
+	disabled := `{"url": "` + downServer.URL + `", "disabled": true}`
+	assert.Equal(t, 200, statusOf(ts, t, "PUT", fmt.Sprintf("/webhooks/%d", downHook.Id), []byte(disabled), adminHeaders))
+	newUser1 = createUser(ts, t, goodUser1Json, 201, "Response should be CREATED")
+	_, err = handlers.DeliverWebhooks(db, sender)
+	assert.Nil(t, err)
+	assert.Len(t, down.requests, 5)
+	assert.Len(t, up.requests, 3)
+	var secret string
+	_, err = db.QueryOne(pg.Scan(&secret), "SELECT secret FROM webhooks WHERE id = ?", downHook.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, downSecret, secret)
+
+	deleteUser(ts, t, newUser1.Id)
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/webhooks/%d", upHook.Id), nil, adminHeaders))
+	assert.Equal(t, 204, statusOf(ts, t, "DELETE", fmt.Sprintf("/webhooks/%d", downHook.Id), nil, adminHeaders))
+	assert.Equal(t, 404, statusOf(ts, t, "GET", fmt.Sprintf("/webhooks/%d/deliveries", upHook.Id), nil, adminHeaders))
+}
@@ -0,0 +1,176 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// The headers each delivery is sent with
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+// A new random secret to sign a webhook's payloads with
+func NewSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// The signature of a payload sent at the timestamp: "sha256=" then the hex
+// HMAC-SHA256, keyed by the secret, of the timestamp, a dot, then the payload.
+// Receivers compute the same, and check the timestamp is recent so a payload
+// can't be replayed later.
+func Sign(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// A payload to send to a webhook
+type Delivery struct {
+	Id      uint
+	Event   string
+	URL     string
+	Secret  string
+	Payload []byte
+}
+
+// Sender posts signed payloads to webhooks
+type Sender struct {
+	Client *http.Client
+}
+
+// Post the delivery, returning the status of the response, if there was one.
+// Responses other than 2xx are errors.
+func (s *Sender) Send(delivery Delivery) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "golang-user-crud-webhooks")
+	request.Header.Set(EventHeader, delivery.Event)
+	request.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.Id), 10))
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, timestamp, delivery.Payload))
+
+	response, err := s.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	// Read some of the body so the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Returned when a webhook's host resolves to an address it can't be sent to
+var ErrPrivateAddress = errors.New("webhook address is not public")
+
+// The networks webhooks can't be sent to, besides loopback, link-local,
+// multicast and unspecified addresses: the private ones, carrier-grade NAT,
+// benchmarking, and NAT64, which would reach IPv4 addresses through IPv6
+var privateNetworks = parseNetworks(
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"fc00::/7",
+	"100.64.0.0/10",
+	"198.18.0.0/15",
+	"64:ff9b::/96",
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// Whether the IP is one webhooks can't be sent to: loopback, private,
+// link-local (which has the cloud metadata services), unspecified or multicast
+func isPrivateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks the address a connection is about to be made to, after its host was
+// resolved, so a host can't resolve to a public address when the webhook is
+// saved and a private one when it's sent to
+func checkPublicAddress(network string, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivateAddress(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Register the defaults of the sender's config, once at startup
+func SetDefaults() {
+	// How long a webhook has to respond before the delivery is retried
+	viper.SetDefault("webhook_timeout", "10s")
+	// Whether webhooks can be sent to loopback, private and link-local
+	// addresses, like a receiver on the same network
+	viper.SetDefault("webhook_allow_private_addresses", false)
+}
+
+// The sender, with the timeout from the config. Unless the config allows
+// them, it won't connect to private addresses, so webhooks can't be used to
+// reach the API's own network.
+func NewSender() *Sender {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !viper.GetBool("webhook_allow_private_addresses") {
+		dialer.Control = checkPublicAddress
+	}
+	// Without a proxy, which would be dialed instead of the webhook's host
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return &Sender{Client: &http.Client{Timeout: viper.GetDuration("webhook_timeout"), Transport: transport}}
+}
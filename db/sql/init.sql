@@ -1,18 +1,553 @@
 -- Create the schema on container startup
 -- (NOTE: we are the superuser in the default DB)
 
+-- The customers whose users we host. Every user, and everything about users,
+-- belongs to one organization, its tenant.
+DROP TABLE IF EXISTS organizations CASCADE;
+CREATE TABLE organizations (
+    id SERIAL PRIMARY KEY,
+
+    name VARCHAR(255) NOT NULL,
+    -- Names the organization in the X-Tenant header, and as a subdomain
+    slug VARCHAR(63) UNIQUE NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- Requests that don't name an organization are in this one
+INSERT INTO organizations (name, slug) VALUES ('Default', 'default');
+
+-- The keys personal data is encrypted with, each encrypted with a master key
+-- from the config. The newest encrypts new data.
+DROP TABLE IF EXISTS data_keys CASCADE;
+CREATE TABLE data_keys (
+    id SERIAL PRIMARY KEY,
+    master_key_id VARCHAR(64) NOT NULL,
+    encrypted_key BYTEA NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
 DROP TABLE IF EXISTS user_accounts CASCADE;
 CREATE TABLE user_accounts (
     id SERIAL PRIMARY KEY,
+    -- Organizations can't be deleted while they have users
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id),
+    -- The id used in URLs. The service makes UUIDv7s, so they sort by creation
+    -- time, but rows inserted directly get a random one.
+    public_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
     
-    user_name VARCHAR(255) UNIQUE NOT NULL,
-    password_hash VARCHAR(128) NOT NULL,
-    first_name VARCHAR(1024),
-    middle_name VARCHAR(1024),
-    last_name VARCHAR(1024),
-    email VARCHAR(1024),
-    primary_phone_number VARCHAR(17),
+    -- NULL for an invited user until they accept, choosing them
+    user_name VARCHAR(255),
+    password_hash VARCHAR(128),
+    -- The fields in pii_fields are encrypted, so are TEXT for the ciphertext,
+    -- and matched on the HMACs in their _index columns
+    first_name TEXT,
+    middle_name TEXT,
+    last_name TEXT,
+    email TEXT,
+    -- When email was verified by a mailed token, reset when it changes
+    email_verified_at TIMESTAMP WITH TIME ZONE,
+    -- E.164, e.g. +15555551234
+    primary_phone_number TEXT,
+    -- ISO 3166-1 alpha-2 region, the default for parsing and displaying phone numbers
+    country VARCHAR(2),
+    -- When primary_phone_number was verified by SMS, reset when it changes
+    phone_verified_at TIMESTAMP WITH TIME ZONE,
+    -- Custom attributes, each validated by its schema in attribute_schemas
+    attributes JSONB NOT NULL DEFAULT '{}',
+    -- Only active users can sign in. Changed by the status endpoints, with
+    -- the reason.
+    status VARCHAR(16) NOT NULL DEFAULT 'active'
+        CHECK (status IN ('pending', 'active', 'suspended', 'disabled')),
+    status_reason VARCHAR(1024),
+    status_changed_at TIMESTAMP WITH TIME ZONE,
+    last_login_at TIMESTAMP WITH TIME ZONE,
+    -- Updated by authenticated requests, every last_seen_interval at most
+    last_seen_at TIMESTAMP WITH TIME ZONE,
+    -- When the user was last warned they'd be suspended or erased for
+    -- inactivity, by the retention rules
+    retention_warned_at TIMESTAMP WITH TIME ZONE,
+
+    first_name_index VARCHAR(64),
+    middle_name_index VARCHAR(64),
+    last_name_index VARCHAR(64),
+    email_index VARCHAR(64),
+    primary_phone_number_index VARCHAR(64),
     
+    -- Incremented on every update, used for the ETag
+    version INTEGER NOT NULL DEFAULT 1,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (tenant_id, user_name),
+    -- For the foreign keys that keep rows referring to users in one tenant
+    UNIQUE (tenant_id, id)
+);
+-- user_names are unique ignoring case too, so no one can pose as another
+-- user with a name that differs only in case
+CREATE UNIQUE INDEX user_accounts_lower_user_name_key ON user_accounts (tenant_id, lower(user_name));
+-- Supports filtering by attribute values with @>
+CREATE INDEX ON user_accounts USING GIN (attributes jsonb_path_ops);
+CREATE INDEX ON user_accounts (tenant_id, status);
+-- Supports the lookups and filters on encrypted fields. Emails are unique
+-- in an organization, by their blind index once encrypted and ignoring case
+-- before then.
+CREATE UNIQUE INDEX user_accounts_email_index_key ON user_accounts (tenant_id, email_index);
+CREATE UNIQUE INDEX user_accounts_email_key ON user_accounts (tenant_id, lower(email)) WHERE email_index IS NULL;
+CREATE INDEX ON user_accounts (tenant_id, primary_phone_number_index);
+CREATE INDEX ON user_accounts (tenant_id, last_name_index);
+
+-- Each time the retention rules were applied, over every organization
+DROP TABLE IF EXISTS retention_runs CASCADE;
+CREATE TABLE retention_runs (
+    id SERIAL PRIMARY KEY,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    finished_at TIMESTAMP WITH TIME ZONE,
+    warned INTEGER NOT NULL DEFAULT 0,
+    suspended INTEGER NOT NULL DEFAULT 0,
+    erased INTEGER NOT NULL DEFAULT 0,
+    -- Why the run stopped early, if it did
+    error TEXT
+);
+
+-- The JSON Schema for each custom user attribute. Private attributes are only
+-- visible to admins.
+DROP TABLE IF EXISTS attribute_schemas CASCADE;
+CREATE TABLE attribute_schemas (
+    tenant_id INTEGER REFERENCES organizations (id) ON DELETE CASCADE,
+    name VARCHAR(255),
+
+    schema JSONB NOT NULL,
+    private BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (tenant_id, name)
+);
+
+-- Responses to POSTs, replayed when a client retries with the same Idempotency-Key
+DROP TABLE IF EXISTS idempotency_keys CASCADE;
+CREATE TABLE idempotency_keys (
+    tenant_id INTEGER REFERENCES organizations (id) ON DELETE CASCADE,
+    idempotency_key VARCHAR(255),
+    caller VARCHAR(32),
+    fingerprint VARCHAR(64) NOT NULL,
+
+    response_status INTEGER,
+    response_headers JSONB,
+    response_body BYTEA,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    PRIMARY KEY (tenant_id, caller, idempotency_key)
+);
+
+-- Progress and results of background user imports
+DROP TABLE IF EXISTS import_jobs CASCADE;
+CREATE TABLE import_jobs (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+
+    status VARCHAR(16) NOT NULL,
+    format VARCHAR(16) NOT NULL,
+    dry_run BOOLEAN NOT NULL,
+    processed INTEGER NOT NULL,
+    created INTEGER NOT NULL,
+    failed INTEGER NOT NULL,
+    errors JSONB,
+    message TEXT,
+
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Contact methods of a user. The primary email and phone number are also
+-- projected onto user_accounts.email and user_accounts.primary_phone_number.
+DROP TABLE IF EXISTS user_emails CASCADE;
+CREATE TABLE user_emails (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    label VARCHAR(64),
+    -- Encrypted like user_accounts.email, and indexed the same way
+    email TEXT NOT NULL,
+    email_index VARCHAR(64),
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ON user_emails (user_id);
+CREATE UNIQUE INDEX ON user_emails (user_id) WHERE is_primary;
+
+DROP TABLE IF EXISTS user_phones CASCADE;
+CREATE TABLE user_phones (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    label VARCHAR(64),
+    -- E.164, encrypted like user_accounts.primary_phone_number
+    phone_number TEXT NOT NULL,
+    phone_number_index VARCHAR(64),
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ON user_phones (user_id);
+CREATE UNIQUE INDEX ON user_phones (user_id) WHERE is_primary;
+
+DROP TABLE IF EXISTS user_addresses CASCADE;
+CREATE TABLE user_addresses (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    label VARCHAR(64),
+    line1 VARCHAR(1024) NOT NULL,
+    line2 VARCHAR(1024),
+    city VARCHAR(1024),
+    region VARCHAR(1024),
+    postal_code VARCHAR(32),
+    country VARCHAR(2) NOT NULL,
+    is_primary BOOLEAN NOT NULL DEFAULT FALSE,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ON user_addresses (user_id);
+CREATE UNIQUE INDEX ON user_addresses (user_id) WHERE is_primary;
+
+-- The user_names users had before changing them, so lookups by an old name
+-- find the user, and nobody else can claim one until reserved_until
+DROP TABLE IF EXISTS user_name_changes CASCADE;
+CREATE TABLE user_name_changes (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+
+    user_name VARCHAR(255) NOT NULL,
+
+    changed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    reserved_until TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    FOREIGN KEY (tenant_id, user_id) REFERENCES user_accounts (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX ON user_name_changes (tenant_id, user_name);
+-- Supports the cooldown check, which ignores case
+CREATE INDEX ON user_name_changes (tenant_id, lower(user_name));
+CREATE INDEX ON user_name_changes (user_id);
+
+-- Outstanding one-time codes sent to verify a user's primary phone number
+DROP TABLE IF EXISTS phone_verifications CASCADE;
+CREATE TABLE phone_verifications (
+    user_id INTEGER PRIMARY KEY REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    -- E.164, encrypted like user_accounts.primary_phone_number
+    phone_number TEXT NOT NULL,
+    phone_number_index VARCHAR(64),
+    code_hash VARCHAR(64) NOT NULL,
+    attempts INTEGER NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Outstanding tokens mailed to verify the email of a user who registered
+DROP TABLE IF EXISTS email_verifications CASCADE;
+CREATE TABLE email_verifications (
+    user_id INTEGER PRIMARY KEY REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    -- Encrypted like user_accounts.email, and indexed the same way
+    email TEXT NOT NULL,
+    email_index VARCHAR(64),
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+-- Groups of users, which can be nested in a parent group
+DROP TABLE IF EXISTS groups CASCADE;
+CREATE TABLE groups (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+
+    name VARCHAR(255) NOT NULL,
+    description VARCHAR(1024),
+    parent_id INTEGER,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (tenant_id, name),
+    UNIQUE (tenant_id, id),
+    -- A group can only be nested in a group of the same tenant
+    FOREIGN KEY (tenant_id, parent_id) REFERENCES groups (tenant_id, id)
+);
+CREATE INDEX ON groups (parent_id);
+
+-- The users directly in each group
+DROP TABLE IF EXISTS group_members CASCADE;
+CREATE TABLE group_members (
+    tenant_id INTEGER NOT NULL,
+    group_id INTEGER,
+    user_id INTEGER,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (group_id, user_id),
+    -- The group and the user must be in the same tenant
+    FOREIGN KEY (tenant_id, group_id) REFERENCES groups (tenant_id, id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id, user_id) REFERENCES user_accounts (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX ON group_members (user_id);
+
+-- Login sessions, by a hash of the bearer token given to the client
+DROP TABLE IF EXISTS sessions CASCADE;
+CREATE TABLE sessions (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES user_accounts (id) ON DELETE CASCADE,
+
+    token_hash VARCHAR(64) UNIQUE NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+CREATE INDEX ON sessions (user_id);
+
+-- Outstanding invitations of pending users, deleted when accepted or revoked
+DROP TABLE IF EXISTS invitations CASCADE;
+CREATE TABLE invitations (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL,
+    user_id INTEGER UNIQUE NOT NULL,
+
+    -- Encrypted like user_accounts.email, and indexed the same way
+    email TEXT NOT NULL,
+    email_index VARCHAR(64),
+    -- Of the secret in the latest token sent
+    secret_hash VARCHAR(64) NOT NULL,
+    invited_by INTEGER REFERENCES user_accounts (id) ON DELETE SET NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    sent_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    FOREIGN KEY (tenant_id, user_id) REFERENCES user_accounts (tenant_id, id) ON DELETE CASCADE
+);
+-- One outstanding invitation per address, like the emails of users
+CREATE UNIQUE INDEX invitations_email_index_key ON invitations (tenant_id, email_index);
+CREATE UNIQUE INDEX invitations_email_key ON invitations (tenant_id, lower(email)) WHERE email_index IS NULL;
+
+-- A record of each erasure of a user's personal data. It only has the user's
+-- ids, and is kept after the user is deleted.
+DROP TABLE IF EXISTS user_erasures CASCADE;
+CREATE TABLE user_erasures (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL,
+    public_id UUID NOT NULL,
+
+    -- NULL when erased with the admin token
+    erased_by INTEGER REFERENCES user_accounts (id) ON DELETE SET NULL,
+    reason VARCHAR(1024) NOT NULL,
+    erased_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ON user_erasures (tenant_id, user_id);
+
+-- Named sets of permissions, e.g. users:read
+DROP TABLE IF EXISTS roles CASCADE;
+CREATE TABLE roles (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+
+    name VARCHAR(255) NOT NULL,
+    description VARCHAR(1024),
+    permissions TEXT[] NOT NULL DEFAULT '{}',
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (tenant_id, name),
+    UNIQUE (tenant_id, id)
+);
+
+-- Roles held by users directly
+DROP TABLE IF EXISTS user_roles CASCADE;
+CREATE TABLE user_roles (
+    tenant_id INTEGER NOT NULL,
+    role_id INTEGER,
+    user_id INTEGER,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (role_id, user_id),
+    FOREIGN KEY (tenant_id, role_id) REFERENCES roles (tenant_id, id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id, user_id) REFERENCES user_accounts (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX ON user_roles (user_id);
+
+-- Roles held by every user in a group, or in a group nested in it
+DROP TABLE IF EXISTS group_roles CASCADE;
+CREATE TABLE group_roles (
+    tenant_id INTEGER NOT NULL,
+    role_id INTEGER,
+    group_id INTEGER,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (role_id, group_id),
+    FOREIGN KEY (tenant_id, role_id) REFERENCES roles (tenant_id, id) ON DELETE CASCADE,
+    FOREIGN KEY (tenant_id, group_id) REFERENCES groups (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX ON group_roles (group_id);
+
+-- Where events about users are posted, and the key their payloads are signed
+-- with
+DROP TABLE IF EXISTS webhooks CASCADE;
+CREATE TABLE webhooks (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+
+    url VARCHAR(2048) NOT NULL,
+    -- Every event if empty
+    events TEXT[] NOT NULL DEFAULT '{}',
+    description VARCHAR(1024),
+    disabled BOOLEAN NOT NULL DEFAULT FALSE,
+    secret VARCHAR(255) NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+
+    UNIQUE (tenant_id, id)
+);
+
+-- The queue of events to post to webhooks, kept as the log of their delivery.
+-- Pending deliveries are attempted from next_attempt_at, until they're
+-- delivered or dead.
+DROP TABLE IF EXISTS webhook_deliveries CASCADE;
+CREATE TABLE webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL,
+    webhook_id INTEGER NOT NULL,
+
+    -- The same in each webhook's delivery of an event
+    event_id UUID NOT NULL,
+    event VARCHAR(64) NOT NULL,
+    payload JSONB NOT NULL,
+
+    status VARCHAR(16) NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE,
+    last_attempt_at TIMESTAMP WITH TIME ZONE,
+    response_status INTEGER,
+    last_error TEXT,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+
+    FOREIGN KEY (tenant_id, webhook_id) REFERENCES webhooks (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX ON webhook_deliveries (webhook_id, id);
+
+-- The outbox of changes to users, written in the transaction that makes each
+-- one. Consumers read an organization's events in sequence order, resuming
+-- after the last they handled. Events are kept for events_retention.
+DROP TABLE IF EXISTS user_events CASCADE;
+CREATE TABLE user_events (
+    sequence BIGSERIAL PRIMARY KEY,
+    tenant_id INTEGER NOT NULL REFERENCES organizations (id) ON DELETE CASCADE,
+
+    id UUID NOT NULL,
+    type VARCHAR(64) NOT NULL,
+    -- Not a foreign key, as deleted users have events
+    user_id INTEGER NOT NULL,
+    data JSONB NOT NULL,
+
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX ON user_events (tenant_id, sequence);
+CREATE INDEX ON user_events (created_at);
+
+-- The sequence of the last of each organization's events published to the
+-- broker
+DROP TABLE IF EXISTS user_event_checkpoints CASCADE;
+CREATE TABLE user_event_checkpoints (
+    tenant_id INTEGER PRIMARY KEY REFERENCES organizations (id) ON DELETE CASCADE,
+    sequence BIGINT NOT NULL,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
+
+-- Row-level security keeps a connection that sets app.tenant_id to the rows of
+-- that organization, e.g. SET app.tenant_id = 2, and gives one that doesn't
+-- nothing. Table owners and superusers aren't subject to it, so the service
+-- scopes its own queries. Grant other roles, like reporting, access to these
+-- tables rather than connecting them as the owner.
+ALTER TABLE user_accounts ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_accounts
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE attribute_schemas ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON attribute_schemas
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE idempotency_keys ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON idempotency_keys
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE import_jobs ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON import_jobs
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE groups ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON groups
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE group_members ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON group_members
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE roles ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON roles
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE user_roles ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_roles
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE group_roles ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON group_roles
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE user_name_changes ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_name_changes
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE invitations ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON invitations
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE user_erasures ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_erasures
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE webhooks ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhooks
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhook_deliveries
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE user_events ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_events
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+ALTER TABLE user_event_checkpoints ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_event_checkpoints
+    USING (tenant_id = nullif(current_setting('app.tenant_id', TRUE), '')::integer);
+-- The rest belong to a user, so are visible with the user
+ALTER TABLE user_emails ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_emails
+    USING (user_id IN (SELECT id FROM user_accounts));
+ALTER TABLE user_phones ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_phones
+    USING (user_id IN (SELECT id FROM user_accounts));
+ALTER TABLE user_addresses ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON user_addresses
+    USING (user_id IN (SELECT id FROM user_accounts));
+ALTER TABLE phone_verifications ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON phone_verifications
+    USING (user_id IN (SELECT id FROM user_accounts));
+ALTER TABLE email_verifications ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON email_verifications
+    USING (user_id IN (SELECT id FROM user_accounts));
+ALTER TABLE sessions ENABLE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON sessions
+    USING (user_id IN (SELECT id FROM user_accounts));